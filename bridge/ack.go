@@ -0,0 +1,18 @@
+package bridge
+
+import "nad-navigation/nad_nav"
+
+// NewAck acknowledges p with the PWM values the bridge applied, in actuator
+// order, and the error applying them, if any. wd, if set, reports the
+// watchdog counters back to nad.
+func NewAck(p nad_nav.OutputPacket, pwm []uint16, err error, wd *Watchdog) nad_nav.Ack {
+	a := nad_nav.Ack{Seq: p.Seq, Heartbeat: p.Heartbeat, PWM: pwm}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	if wd != nil {
+		s := wd.Stats()
+		a.Watchdog = &nad_nav.AckWatchdog{Missed: s.Missed, Trips: s.Trips, Tripped: s.Tripped}
+	}
+	return a
+}
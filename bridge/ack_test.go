@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"nad-navigation/nad_nav"
+)
+
+func TestNewAck(t *testing.T) {
+	p := beat(7)
+	p.Seq = 42
+	pwm := []uint16{1500, 1520, 1480, 1500}
+
+	a := NewAck(p, pwm, nil, nil)
+	if a.Seq != 42 || a.Heartbeat != 7 || !reflect.DeepEqual(a.PWM, pwm) || a.Error != "" || a.Watchdog != nil {
+		t.Errorf("ack %+v, want seq 42 heartbeat 7 and the applied pwm", a)
+	}
+
+	w, clock, _ := newTestWatchdog()
+	w.Feed(beat(1))
+	clock.Advance(6 * interval)
+	w.Check()
+	a = NewAck(p, nil, errors.New("servo bus timeout"), w)
+	if a.Error != "servo bus timeout" {
+		t.Errorf("error %q, want the apply error", a.Error)
+	}
+	if want := (nad_nav.AckWatchdog{Missed: 6, Trips: 1, Tripped: true}); a.Watchdog == nil || *a.Watchdog != want {
+		t.Errorf("watchdog %+v, want %+v", a.Watchdog, want)
+	}
+
+	// The ack survives the wire.
+	got, err := nad_nav.ParseAck(nad_nav.EncodeAck(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, a) {
+		t.Errorf("round trip %+v, want %+v", got, a)
+	}
+}
@@ -0,0 +1,17 @@
+package bridge
+
+import (
+	"bytes"
+
+	"nad-navigation/nad_nav"
+)
+
+// ParsePacket decodes one payload in nad's binary (either version) or CSV
+// (legacy or version 2) formats. Signed payloads must be opened with a
+// nad_nav.Authenticator first.
+func ParsePacket(payload []byte) (nad_nav.OutputPacket, error) {
+	if bytes.HasPrefix(payload, []byte("NC")) {
+		return nad_nav.DecodeOutputPacket(payload)
+	}
+	return nad_nav.ParseOutputCSV(string(payload))
+}
@@ -0,0 +1,149 @@
+// Package bridge holds reference building blocks for programs that receive
+// nad's output packets and drive actuators, such as the flight-controller
+// bridge on the vehicle.
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"nad-navigation/nad_nav"
+)
+
+// WatchdogConfig sets the command-timeout contract with nad.
+//
+// IntervalSeconds is the heartbeat interval nad promises, the sink's
+// 1/min_hz or 1/schedule hz (default 0.1). TimeoutSeconds is how long the heartbeat may stall
+// before actuators are neutralized (default five intervals).
+type WatchdogConfig struct {
+	IntervalSeconds float64 `json:"interval_seconds"`
+	TimeoutSeconds  float64 `json:"timeout_seconds"`
+}
+
+// WatchdogStats counts what the watchdog saw.
+type WatchdogStats struct {
+	Packets  uint64        // packets fed
+	Repeats  uint64        // packets whose heartbeat did not advance
+	Missed   uint64        // intervals that passed without a new heartbeat
+	Trips    uint64        // times actuators were neutralized
+	Tripped  bool          // actuators are neutralized now
+	LastBeat uint32        // latest heartbeat value
+	Silence  time.Duration // since the heartbeat last advanced
+}
+
+// String formats the statistics for a console log.
+func (s WatchdogStats) String() string {
+	return fmt.Sprintf("watchdog packets=%d repeats=%d missed=%d trips=%d tripped=%t silence=%.0fms",
+		s.Packets, s.Repeats, s.Missed, s.Trips, s.Tripped, s.Silence.Seconds()*1000)
+}
+
+// Watchdog neutralizes actuators when nad's heartbeat stops advancing,
+// whether packets stopped arriving or nad's loop stalled while its sink kept
+// repeating the last command.
+//
+// Feed every received packet and call Check periodically, or use Run.
+type Watchdog struct {
+	interval time.Duration
+	timeout  time.Duration
+	clock    nad_nav.Clock
+	neutral  func()
+
+	mu       sync.Mutex
+	hasBeat  bool
+	lastBeat uint32
+	beatAt   time.Time
+	missed   int // intervals of the current silence already counted
+	stats    WatchdogStats
+}
+
+// NewWatchdog returns a watchdog that calls neutral once each time the
+// heartbeat stalls for the timeout. The silence counts from now, so
+// actuators are also neutralized if nad never starts.
+func NewWatchdog(cfg WatchdogConfig, clock nad_nav.Clock, neutral func()) *Watchdog {
+	if clock == nil {
+		clock = nad_nav.RealClock{}
+	}
+	if cfg.IntervalSeconds <= 0 {
+		cfg.IntervalSeconds = 0.1
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 5 * cfg.IntervalSeconds
+	}
+	return &Watchdog{
+		interval: time.Duration(cfg.IntervalSeconds * float64(time.Second)),
+		timeout:  time.Duration(cfg.TimeoutSeconds * float64(time.Second)),
+		clock:    clock,
+		neutral:  neutral,
+		beatAt:   clock.Now(),
+	}
+}
+
+// Feed records a received packet and reports whether its heartbeat advanced.
+// Version 1 packets carry no heartbeat and always count as advancing. Any
+// change counts, so a restarted nad is picked up at once.
+func (w *Watchdog) Feed(p nad_nav.OutputPacket) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Packets++
+	if p.Version >= nad_nav.OutputProtocolVersion && w.hasBeat && p.Heartbeat == w.lastBeat {
+		w.stats.Repeats++
+		return false
+	}
+	w.hasBeat = true
+	w.lastBeat = p.Heartbeat
+	w.beatAt = w.clock.Now()
+	w.missed = 0
+	w.stats.LastBeat = p.Heartbeat
+	w.stats.Tripped = false
+	return true
+}
+
+// Check counts missed intervals and neutralizes actuators when the heartbeat
+// has stalled for the timeout. It returns whether the watchdog is tripped;
+// commands should not be applied while it is.
+func (w *Watchdog) Check() bool {
+	w.mu.Lock()
+	silence := w.clock.Now().Sub(w.beatAt)
+	if n := int(silence / w.interval); n > w.missed {
+		w.stats.Missed += uint64(n - w.missed)
+		w.missed = n
+	}
+	trip := !w.stats.Tripped && silence >= w.timeout
+	if trip {
+		w.stats.Tripped = true
+		w.stats.Trips++
+	}
+	tripped := w.stats.Tripped
+	w.mu.Unlock()
+
+	if trip && w.neutral != nil {
+		w.neutral()
+	}
+	return tripped
+}
+
+// Run calls Check every interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	timer := w.clock.NewTimer(w.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		w.Check()
+		timer.Reset(w.interval)
+	}
+}
+
+// Stats returns the counters.
+func (w *Watchdog) Stats() WatchdogStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.stats
+	s.Silence = w.clock.Now().Sub(w.beatAt)
+	return s
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nad-navigation/nad_nav"
+)
+
+const interval = 100 * time.Millisecond
+
+func newTestWatchdog() (*Watchdog, *nad_nav.SimClock, *atomic.Int64) {
+	clock := nad_nav.NewSimClock(time.Unix(1000, 0))
+	var neutral atomic.Int64
+	w := NewWatchdog(WatchdogConfig{IntervalSeconds: 0.1, TimeoutSeconds: 0.5}, clock, func() { neutral.Add(1) })
+	return w, clock, &neutral
+}
+
+func beat(n uint32) nad_nav.OutputPacket {
+	return nad_nav.OutputPacket{Version: nad_nav.OutputProtocolVersion, Heartbeat: n}
+}
+
+func TestWatchdogTripsOncePerStall(t *testing.T) {
+	w, clock, neutral := newTestWatchdog()
+	w.Feed(beat(1))
+	for i := 1; i <= 8; i++ {
+		clock.Advance(interval)
+		tripped := w.Check()
+		if want := i >= 5; tripped != want {
+			t.Errorf("%v of silence: tripped %v, want %v", time.Duration(i)*interval, tripped, want)
+		}
+	}
+	if n := neutral.Load(); n != 1 {
+		t.Errorf("neutral called %d times, want once", n)
+	}
+	s := w.Stats()
+	if s.Missed != 8 || s.Trips != 1 || !s.Tripped || s.Silence != 8*interval || s.LastBeat != 1 {
+		t.Errorf("stats %+v", s)
+	}
+
+	// A fresh heartbeat clears the trip; the next stall trips again.
+	if !w.Feed(beat(2)) {
+		t.Error("a new heartbeat should advance")
+	}
+	if w.Check() || w.Stats().Tripped {
+		t.Error("a fresh heartbeat should clear Tripped")
+	}
+	clock.Advance(5 * interval)
+	if !w.Check() || neutral.Load() != 2 || w.Stats().Trips != 2 {
+		t.Errorf("second stall: neutral %d, stats %+v", neutral.Load(), w.Stats())
+	}
+}
+
+func TestWatchdogMissedIntervals(t *testing.T) {
+	w, clock, _ := newTestWatchdog()
+	w.Feed(beat(1))
+	clock.Advance(250 * time.Millisecond)
+	w.Check()
+	w.Check() // the same intervals are not counted twice
+	if s := w.Stats(); s.Missed != 2 {
+		t.Errorf("missed %d after 250ms, want 2", s.Missed)
+	}
+	w.Feed(beat(2))
+	clock.Advance(150 * time.Millisecond)
+	w.Check()
+	if s := w.Stats(); s.Missed != 3 {
+		t.Errorf("missed %d, want 3: a new heartbeat restarts the count", s.Missed)
+	}
+}
+
+func TestWatchdogRepeatedHeartbeat(t *testing.T) {
+	w, clock, neutral := newTestWatchdog()
+	w.Feed(beat(7))
+	// nad's sink keeps repeating the last command while its loop is stalled.
+	for i := 0; i < 5; i++ {
+		clock.Advance(interval)
+		if w.Feed(beat(7)) {
+			t.Error("a repeated heartbeat should not advance")
+		}
+	}
+	if !w.Check() || neutral.Load() != 1 {
+		t.Error("repeats must not reset the silence")
+	}
+	if s := w.Stats(); s.Packets != 6 || s.Repeats != 5 {
+		t.Errorf("stats %+v, want 6 packets and 5 repeats", s)
+	}
+	// Any change counts, so a restarted nad is picked up at once.
+	if !w.Feed(beat(0)) || w.Check() {
+		t.Error("a restarted heartbeat should clear the trip")
+	}
+}
+
+func TestWatchdogVersion1Packets(t *testing.T) {
+	w, clock, neutral := newTestWatchdog()
+	v1 := nad_nav.OutputPacket{Version: nad_nav.CommandProtocolVersion, Armed: true}
+	for i := 0; i < 20; i++ {
+		if !w.Feed(v1) {
+			t.Fatal("v1 packets should always advance")
+		}
+		clock.Advance(3 * interval)
+		if w.Check() {
+			t.Fatalf("tripped after %d v1 packets", i+1)
+		}
+	}
+	if neutral.Load() != 0 || w.Stats().Repeats != 0 {
+		t.Errorf("neutral %d, stats %+v", neutral.Load(), w.Stats())
+	}
+}
+
+func TestWatchdogNeverStarted(t *testing.T) {
+	w, clock, neutral := newTestWatchdog()
+	clock.Advance(5 * interval)
+	if !w.Check() || neutral.Load() != 1 {
+		t.Error("the watchdog should trip when nad never starts")
+	}
+}
+
+func TestWatchdogRun(t *testing.T) {
+	w, clock, neutral := newTestWatchdog()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	// Step one interval at a time, waiting for Run to re-arm its timer.
+	armed := func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if next, ok := clock.NextDeadline(); ok && next.After(clock.Now()) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Run did not arm its timer")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		armed()
+		clock.Advance(interval)
+	}
+	armed()
+	if neutral.Load() != 1 || w.Stats().Missed != 6 {
+		t.Errorf("neutral %d, stats %+v", neutral.Load(), w.Stats())
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
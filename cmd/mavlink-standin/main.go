@@ -0,0 +1,110 @@
+// Command mavlink-standin is a local stand-in for a flight controller. It
+// listens for MAVLink v2 over UDP, decodes the frames nad sends and records
+// them as JSON lines, optionally keeping the raw bytes too.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"nad-navigation/nad_nav"
+)
+
+type record struct {
+	T         float64                `json:"t"`
+	From      string                 `json:"from"`
+	Seq       uint8                  `json:"seq"`
+	SystemID  uint8                  `json:"sys"`
+	Component uint8                  `json:"comp"`
+	Message   string                 `json:"msg"`
+	Fields    nad_nav.MAVLinkMessage `json:"fields"`
+}
+
+func main() {
+	var listenAddr string
+	var outPath string
+	var rawPath string
+	flag.StringVar(&listenAddr, "listen", "127.0.0.1:14550", "UDP address to listen on (host:port).")
+	flag.StringVar(&outPath, "out", "", "Append decoded messages as JSON lines to this file instead of stdout.")
+	flag.StringVar(&rawPath, "raw", "", "Also append the raw received bytes to this file.")
+	flag.Parse()
+
+	conn, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		log.Fatalf("listen %s: %v", listenAddr, err)
+	}
+	out := os.Stdout
+	if outPath != "" {
+		out, err = os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+	var raw *os.File
+	if rawPath != "" {
+		raw, err = os.OpenFile(rawPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer raw.Close()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		conn.Close()
+	}()
+
+	log.Printf("listening for MAVLink on %s", conn.LocalAddr())
+	start := time.Now()
+	enc := json.NewEncoder(out)
+	counts := map[string]int{}
+	var parser nad_nav.MAVLinkParser
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if raw != nil {
+			_, _ = raw.Write(buf[:n])
+		}
+		for _, frame := range parser.Parse(buf[:n]) {
+			msg, err := nad_nav.DecodeMAVLinkMessage(frame)
+			if err != nil {
+				continue
+			}
+			name := nad_nav.MAVLinkMessageName(frame.MessageID)
+			counts[name]++
+			_ = enc.Encode(record{
+				T:         time.Since(start).Seconds(),
+				From:      addr.String(),
+				Seq:       frame.Seq,
+				SystemID:  frame.SystemID,
+				Component: frame.ComponentID,
+				Message:   name,
+				Fields:    msg,
+			})
+		}
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%s=%d\n", name, counts[name])
+	}
+	fmt.Fprintf(os.Stderr, "frames=%d errors=%d unknown=%d\n", parser.Frames, parser.Errors, parser.Unknown)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 
 	"nad-navigation/nad_nav"
@@ -10,10 +12,12 @@ import (
 func main() {
 	var configPath string
 	var liveAddr string
+	var liveSource string
 	var outputAddr string
 	var modeOverride string
 	flag.StringVar(&configPath, "config", "config.testing.json", "Path to JSON config.")
 	flag.StringVar(&liveAddr, "live-addr", "", "Override live UDP listen addr (host:port).")
+	flag.StringVar(&liveSource, "live-source", "", "Override live input source (udp, tcp, unix, stdin, file, pipe).")
 	flag.StringVar(&outputAddr, "output-addr", "", "Override output UDP addr (host:port).")
 	flag.StringVar(&modeOverride, "mode-override", "", "Force controller mode (e.g., FLY_STRAIGHT).")
 	flag.Parse()
@@ -26,6 +30,9 @@ func main() {
 	if liveAddr != "" {
 		cfg.Live.UDPAddr = liveAddr
 	}
+	if liveSource != "" {
+		cfg.Live.Source = liveSource
+	}
 	if outputAddr != "" {
 		cfg.Output.UDPAddr = outputAddr
 	}
@@ -37,7 +44,9 @@ func main() {
 		cfg.Controller.ModeOverride = &mode
 	}
 
-	if err := nad_nav.RunLive(cfg); err != nil {
+	summary, err := nad_nav.RunLive(context.Background(), cfg)
+	if err != nil {
 		log.Fatal(err)
 	}
+	fmt.Println(summary)
 }
@@ -0,0 +1,354 @@
+// Package mixer turns body commands into per-actuator outputs.
+//
+// A mix matrix weights the yaw, vertical and forward commands into one
+// normalized position per actuator. Saturation handling keeps the result
+// inside each actuator's endpoints, per-channel reversal and trims adapt it
+// to the airframe, and each position finally maps onto a PWM range.
+//
+// Fin positions are normalized to [-1, 1] with 0 centred; throttle positions
+// to [0, 1] with 0 off. The package has no dependency on the controller so
+// bridges can link it on their own.
+package mixer
+
+import (
+	"fmt"
+	"math"
+)
+
+// Input is one body command: yaw and vertical in [-1, 1], forward in [0, 1].
+type Input struct {
+	Yaw      float64
+	Vertical float64
+	Forward  float64
+}
+
+// Channel configures one actuator.
+//
+// Yaw, Vertical and Forward are the mix weights. Throttle channels take
+// forward in [0, 1], map 0 to pwm_min and are left out of fin saturation.
+// Reverse flips the mixed position, trim (normalized units) offsets it, and
+// min/max are its endpoints (defaults -1/1, or 0/1 for throttle). PWMMin and
+// PWMMax override the mixer's PWM range. Output is the 1-based servo or RC
+// channel the actuator is wired to (default: its position in the list).
+type Channel struct {
+	Name     string   `json:"name"`
+	Yaw      float64  `json:"yaw"`
+	Vertical float64  `json:"vertical"`
+	Forward  float64  `json:"forward"`
+	Throttle bool     `json:"throttle"`
+	Reverse  bool     `json:"reverse"`
+	Trim     float64  `json:"trim"`
+	Min      *float64 `json:"min"`
+	Max      *float64 `json:"max"`
+	PWMMin   int      `json:"pwm_min"`
+	PWMMax   int      `json:"pwm_max"`
+	Output   int      `json:"output"`
+}
+
+// Config selects a mix matrix and output ranges.
+//
+// Preset is x_tail (default), v_tail, plus or custom. Presets fill in the
+// weights of their fins; channels then only needs the entries that change
+// reversal, trims, endpoints or PWM ranges, in preset order. custom takes
+// every channel, weights included, from channels. Motor appends a throttle
+// channel driven by forward.
+//
+// Saturation is yaw_priority (default: vertical authority is given up before
+// yaw when a fin would pass its endpoint), normalize (scale every fin by the
+// largest magnitude, as the original Python bridge did) or clip (clamp each
+// fin on its own). PWMMin and PWMMax default to 1000 and 2000.
+type Config struct {
+	Preset     string    `json:"preset"`
+	Channels   []Channel `json:"channels"`
+	Motor      bool      `json:"motor"`
+	Saturation string    `json:"saturation"`
+	PWMMin     int       `json:"pwm_min"`
+	PWMMax     int       `json:"pwm_max"`
+}
+
+// Output is one mixed command.
+type Output struct {
+	Positions []float64 // normalized positions after trims and endpoints
+	PWM       []uint16
+	Saturated bool // a fin command was reduced to fit its endpoints
+}
+
+// Mixer maps body commands onto actuators. It holds no state between calls.
+type Mixer struct {
+	channels   []channel
+	saturation string
+}
+
+// channel is a Channel with defaults resolved.
+type channel struct {
+	Channel
+	lo, hi         float64
+	pwmMin, pwmMax int
+}
+
+// Presets. Fins 1-4 of the X-tail are numbered around the tail, so 1 and 3
+// and 2 and 4 form the diagonal pairs; pair A (1, 3) is flipped. The V-tail
+// has left and right ruddervators; the plus tail has top, right, bottom and
+// left fins.
+var presets = map[string][]Channel{
+	"x_tail": {
+		{Name: "fin1", Yaw: -1, Vertical: -1},
+		{Name: "fin2", Yaw: -1, Vertical: 1},
+		{Name: "fin3", Yaw: -1, Vertical: -1},
+		{Name: "fin4", Yaw: -1, Vertical: 1},
+	},
+	"v_tail": {
+		{Name: "left", Yaw: 1, Vertical: 1},
+		{Name: "right", Yaw: -1, Vertical: 1},
+	},
+	"plus": {
+		{Name: "top", Yaw: 1},
+		{Name: "right", Vertical: 1},
+		{Name: "bottom", Yaw: 1},
+		{Name: "left", Vertical: 1},
+	},
+}
+
+// New validates cfg and builds a mixer.
+func New(cfg Config) (*Mixer, error) {
+	var chans []Channel
+	switch cfg.Preset {
+	case "", "x_tail", "v_tail", "plus":
+		preset := cfg.Preset
+		if preset == "" {
+			preset = "x_tail"
+		}
+		base := presets[preset]
+		if len(cfg.Channels) > len(base) {
+			return nil, fmt.Errorf("mixer: preset %s has %d channels, got %d", preset, len(base), len(cfg.Channels))
+		}
+		chans = append(chans, base...)
+		for i, over := range cfg.Channels {
+			chans[i] = overlay(chans[i], over)
+		}
+	case "custom":
+		if len(cfg.Channels) == 0 {
+			return nil, fmt.Errorf("mixer: custom preset needs channels")
+		}
+		chans = append(chans, cfg.Channels...)
+	default:
+		return nil, fmt.Errorf("mixer: unknown preset %q", cfg.Preset)
+	}
+	if cfg.Motor {
+		chans = append(chans, Channel{Name: "motor", Forward: 1, Throttle: true})
+	}
+
+	switch cfg.Saturation {
+	case "":
+		cfg.Saturation = "yaw_priority"
+	case "yaw_priority", "normalize", "clip":
+	default:
+		return nil, fmt.Errorf("mixer: unknown saturation %q", cfg.Saturation)
+	}
+	cfg.PWMMin = defaultInt(cfg.PWMMin, 1000)
+	cfg.PWMMax = defaultInt(cfg.PWMMax, 2000)
+
+	m := &Mixer{saturation: cfg.Saturation}
+	for i, ch := range chans {
+		c := channel{Channel: ch, lo: -1, hi: 1, pwmMin: defaultInt(ch.PWMMin, cfg.PWMMin), pwmMax: defaultInt(ch.PWMMax, cfg.PWMMax)}
+		if ch.Throttle {
+			c.lo = 0
+		}
+		if ch.Min != nil {
+			c.lo = *ch.Min
+		}
+		if ch.Max != nil {
+			c.hi = *ch.Max
+		}
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("ch%d", i+1)
+		}
+		if c.Output == 0 {
+			c.Output = i + 1
+		}
+		switch {
+		case c.lo >= c.hi:
+			return nil, fmt.Errorf("mixer: channel %s endpoints %g..%g are invalid", c.Name, c.lo, c.hi)
+		case c.pwmMin < 0 || c.pwmMin >= c.pwmMax || c.pwmMax > math.MaxUint16:
+			return nil, fmt.Errorf("mixer: channel %s pwm range %d..%d is invalid", c.Name, c.pwmMin, c.pwmMax)
+		case c.Output < 0:
+			return nil, fmt.Errorf("mixer: channel %s output %d is invalid", c.Name, c.Output)
+		}
+		m.channels = append(m.channels, c)
+	}
+	return m, nil
+}
+
+// overlay applies the non-zero settings of over to a preset channel. The
+// preset's weights are kept.
+func overlay(base, over Channel) Channel {
+	if over.Name != "" {
+		base.Name = over.Name
+	}
+	base.Reverse = over.Reverse
+	base.Trim = over.Trim
+	base.Min = over.Min
+	base.Max = over.Max
+	base.PWMMin = over.PWMMin
+	base.PWMMax = over.PWMMax
+	base.Output = over.Output
+	return base
+}
+
+// Len returns the number of actuators.
+func (m *Mixer) Len() int {
+	return len(m.channels)
+}
+
+// Names returns the actuator names in output order.
+func (m *Mixer) Names() []string {
+	names := make([]string, len(m.channels))
+	for i, c := range m.channels {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Outputs returns the 1-based servo or RC channel of each actuator.
+func (m *Mixer) Outputs() []int {
+	outs := make([]int, len(m.channels))
+	for i, c := range m.channels {
+		outs[i] = c.Output
+	}
+	return outs
+}
+
+// Neutral returns the output for a stopped vehicle: fins at trim and
+// throttle off.
+func (m *Mixer) Neutral() Output {
+	return m.Mix(Input{})
+}
+
+// Mix maps one body command onto the actuators.
+func (m *Mixer) Mix(in Input) Output {
+	in.Yaw = clamp(in.Yaw, -1, 1)
+	in.Vertical = clamp(in.Vertical, -1, 1)
+	in.Forward = clamp(in.Forward, 0, 1)
+
+	n := len(m.channels)
+	yaw := make([]float64, n)  // yaw share of each fin
+	rest := make([]float64, n) // vertical and forward share
+	out := Output{Positions: make([]float64, n), PWM: make([]uint16, n)}
+	for i, c := range m.channels {
+		yaw[i] = c.Yaw * in.Yaw
+		rest[i] = c.Vertical*in.Vertical + c.Forward*in.Forward
+	}
+
+	switch m.saturation {
+	case "yaw_priority":
+		out.Saturated = m.yawPriority(yaw, rest)
+	case "normalize":
+		out.Saturated = m.normalize(yaw, rest)
+	}
+
+	for i, c := range m.channels {
+		v := yaw[i] + rest[i]
+		if c.Reverse {
+			v = -v
+		}
+		v = clamp(v+c.Trim, c.lo, c.hi)
+		out.Positions[i] = v
+		out.PWM[i] = c.pwm(v)
+	}
+	return out
+}
+
+// limits returns the range a fin's mixed value may take so that, after
+// reversal and trim, it stays inside the endpoints.
+func (c channel) limits() (lo, hi float64) {
+	lo, hi = c.lo-c.Trim, c.hi-c.Trim
+	if c.Reverse {
+		lo, hi = -hi, -lo
+	}
+	return lo, hi
+}
+
+// yawPriority scales the yaw share only when yaw alone passes an endpoint,
+// then gives each fin's remaining room to the vertical share, scaling it
+// uniformly across fins so the commanded direction is kept. It reports
+// whether anything was scaled.
+func (m *Mixer) yawPriority(yaw, rest []float64) bool {
+	ys, rs := 1.0, 1.0
+	for i, c := range m.channels {
+		if c.Throttle {
+			continue
+		}
+		lo, hi := c.limits()
+		ys = math.Min(ys, fit(yaw[i], lo, hi))
+	}
+	for i, c := range m.channels {
+		if c.Throttle {
+			continue
+		}
+		lo, hi := c.limits()
+		y := yaw[i] * ys
+		rs = math.Min(rs, fit(rest[i], lo-y, hi-y))
+	}
+	for i, c := range m.channels {
+		if c.Throttle {
+			continue
+		}
+		yaw[i] *= ys
+		rest[i] *= rs
+	}
+	return ys < 1 || rs < 1
+}
+
+// normalize divides every fin by the largest mixed magnitude above 1.
+func (m *Mixer) normalize(yaw, rest []float64) bool {
+	peak := 1.0
+	for i, c := range m.channels {
+		if !c.Throttle {
+			peak = math.Max(peak, math.Abs(yaw[i]+rest[i]))
+		}
+	}
+	if peak == 1 {
+		return false
+	}
+	for i, c := range m.channels {
+		if !c.Throttle {
+			yaw[i] /= peak
+			rest[i] /= peak
+		}
+	}
+	return true
+}
+
+// fit returns the largest scale in [0, 1] that keeps v*scale inside [lo, hi].
+func fit(v, lo, hi float64) float64 {
+	switch {
+	case v > hi && v > 0:
+		return math.Max(0, hi/v)
+	case v < lo && v < 0:
+		return math.Max(0, lo/v)
+	}
+	return 1
+}
+
+// pwm maps a position onto the channel's PWM range: [-1, 1] for fins, [0, 1]
+// for throttle.
+func (c channel) pwm(v float64) uint16 {
+	if !c.Throttle {
+		v = (v + 1) / 2
+	}
+	span := float64(c.pwmMax - c.pwmMin)
+	return uint16(clamp(math.Round(float64(c.pwmMin)+v*span), float64(c.pwmMin), float64(c.pwmMax)))
+}
+
+// clamp keeps value inside [lo, hi].
+func clamp(value, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, value))
+}
+
+// defaultInt returns fallback when v is zero.
+func defaultInt(v, fallback int) int {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
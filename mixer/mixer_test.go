@@ -0,0 +1,200 @@
+package mixer
+
+import (
+	"math"
+	"testing"
+)
+
+// mixXTail is the Python bridge's mix_xtail: pair A (fins 1 and 3) is
+// flipped and both pairs are normalized together.
+func mixXTail(vertical, yaw float64) []float64 {
+	a := -(vertical + yaw)
+	b := vertical - yaw
+	if peak := math.Max(math.Abs(a), math.Abs(b)); peak > 1 {
+		a, b = a/peak, b/peak
+	}
+	return []float64{a, b, a, b}
+}
+
+// toPWM is the Python bridge's to_pwm for a 1000..2000 range.
+func toPWM(v float64) int {
+	v = math.Max(-1, math.Min(1, v))
+	return int((v+1)/2*1000 + 1000)
+}
+
+func float64p(v float64) *float64 { return &v }
+
+func TestXTailMatchesPythonMix(t *testing.T) {
+	m, err := New(Config{Preset: "x_tail", Saturation: "normalize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []Input{
+		{},
+		{Yaw: 0.5},
+		{Vertical: -0.5},
+		{Yaw: 0.3, Vertical: 0.4},
+		{Yaw: -0.8, Vertical: 0.6},
+		{Yaw: 1, Vertical: 1},
+		{Yaw: -1, Vertical: 0.25},
+	} {
+		out := m.Mix(in)
+		want := mixXTail(in.Vertical, in.Yaw)
+		for i := range want {
+			if math.Abs(out.Positions[i]-want[i]) > 1e-12 {
+				t.Errorf("%+v fin%d: position %v, want %v", in, i+1, out.Positions[i], want[i])
+			}
+			// Python truncates, Go rounds.
+			if d := int(out.PWM[i]) - toPWM(want[i]); d < 0 || d > 1 {
+				t.Errorf("%+v fin%d: pwm %d, python %d", in, i+1, out.PWM[i], toPWM(want[i]))
+			}
+		}
+	}
+}
+
+func TestYawPriority(t *testing.T) {
+	m, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in        Input
+		positions []float64
+		saturated bool
+	}{
+		{Input{Yaw: 0.4, Vertical: 0.2}, []float64{-0.6, -0.2, -0.6, -0.2}, false},
+		// Fin 1 would reach -1.4: vertical is halved, yaw kept.
+		{Input{Yaw: 0.6, Vertical: 0.8}, []float64{-1, -0.2, -1, -0.2}, true},
+		// Full yaw leaves no room for vertical on fins 1 and 3.
+		{Input{Yaw: 1, Vertical: 0.5}, []float64{-1, -1, -1, -1}, true},
+		{Input{Yaw: -1, Vertical: -1}, []float64{1, 1, 1, 1}, true},
+	}
+	for _, tt := range tests {
+		out := m.Mix(tt.in)
+		for i, want := range tt.positions {
+			if math.Abs(out.Positions[i]-want) > 1e-12 {
+				t.Errorf("%+v: positions %v, want %v", tt.in, out.Positions, tt.positions)
+				break
+			}
+		}
+		if out.Saturated != tt.saturated {
+			t.Errorf("%+v: saturated %v, want %v", tt.in, out.Saturated, tt.saturated)
+		}
+	}
+}
+
+func TestTrimAndReverseStayInsideEndpoints(t *testing.T) {
+	for _, saturation := range []string{"yaw_priority", "normalize", "clip"} {
+		m, err := New(Config{
+			Saturation: saturation,
+			Channels: []Channel{
+				{Trim: 0.2, Reverse: true},
+				{Trim: -0.3, Min: float64p(-0.8), Max: float64p(0.9)},
+				{Reverse: true, Max: float64p(0.5)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Neutral().Positions; got[0] != 0.2 || got[1] != -0.3 || got[2] != 0 {
+			t.Errorf("%s: neutral positions %v, want the trims", saturation, got)
+		}
+		if got := m.Mix(Input{Yaw: 0.5}).Positions[0]; math.Abs(got-0.7) > 1e-12 {
+			t.Errorf("%s: reversed fin1 at yaw 0.5 = %v, want 0.7", saturation, got)
+		}
+		bounds := [][2]float64{{-1, 1}, {-0.8, 0.9}, {-1, 0.5}, {-1, 1}}
+		for _, yaw := range []float64{-1, -0.7, 0, 0.7, 1} {
+			for _, vertical := range []float64{-1, -0.5, 0, 0.5, 1} {
+				out := m.Mix(Input{Yaw: yaw, Vertical: vertical})
+				for i, p := range out.Positions {
+					if p < bounds[i][0]-1e-12 || p > bounds[i][1]+1e-12 {
+						t.Errorf("%s yaw %v vertical %v: fin%d at %v outside %v", saturation, yaw, vertical, i+1, p, bounds[i])
+					}
+					lo, hi := m.channels[i].pwm(bounds[i][0]), m.channels[i].pwm(bounds[i][1])
+					if out.PWM[i] < lo || out.PWM[i] > hi {
+						t.Errorf("%s yaw %v vertical %v: fin%d pwm %d outside %d..%d", saturation, yaw, vertical, i+1, out.PWM[i], lo, hi)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPWMMapping(t *testing.T) {
+	m, err := New(Config{
+		Preset:   "v_tail",
+		Motor:    true,
+		Channels: []Channel{{}, {PWMMin: 1100, PWMMax: 1900}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fin, custom, motor := m.channels[0], m.channels[1], m.channels[2]
+	tests := []struct {
+		c    channel
+		v    float64
+		want uint16
+	}{
+		{fin, -1, 1000},
+		{fin, 0, 1500},
+		{fin, 1, 2000},
+		{fin, 1.5, 2000},
+		{custom, -1, 1100},
+		{custom, 0, 1500},
+		{custom, 1, 1900},
+		{motor, 0, 1000},
+		{motor, 0.5, 1500},
+		{motor, 1, 2000},
+		{motor, -1, 1000},
+	}
+	for _, tt := range tests {
+		if got := tt.c.pwm(tt.v); got != tt.want {
+			t.Errorf("%s pwm(%v) = %d, want %d", tt.c.Name, tt.v, got, tt.want)
+		}
+	}
+
+	// The throttle follows forward and is left out of fin saturation.
+	for _, tt := range []struct {
+		forward float64
+		want    uint16
+	}{{0, 1000}, {0.25, 1250}, {1, 2000}, {-0.5, 1000}, {2, 2000}} {
+		out := m.Mix(Input{Yaw: 1, Vertical: 1, Forward: tt.forward})
+		if out.PWM[2] != tt.want {
+			t.Errorf("forward %v: motor pwm %d, want %d", tt.forward, out.PWM[2], tt.want)
+		}
+	}
+	if got := m.Outputs(); got[0] != 1 || got[2] != 3 {
+		t.Errorf("outputs %v, want list positions", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown preset", Config{Preset: "h_tail"}},
+		{"too many channels", Config{Preset: "v_tail", Channels: make([]Channel, 3)}},
+		{"custom without channels", Config{Preset: "custom"}},
+		{"unknown saturation", Config{Saturation: "wrap"}},
+		{"lo equals hi", Config{Channels: []Channel{{Min: float64p(0.5), Max: float64p(0.5)}}}},
+		{"lo above hi", Config{Channels: []Channel{{Min: float64p(0.5), Max: float64p(-0.5)}}}},
+		{"throttle max at zero", Config{Preset: "custom", Channels: []Channel{{Throttle: true, Max: float64p(0)}}}},
+		{"pwm min above max", Config{PWMMin: 2000, PWMMax: 1000}},
+		{"pwm max too large", Config{PWMMax: 70000}},
+		{"negative pwm", Config{Channels: []Channel{{PWMMin: -5}}}},
+		{"negative output", Config{Channels: []Channel{{Output: -1}}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	m, err := New(Config{Preset: "plus", Motor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 5 || m.Names()[4] != "motor" {
+		t.Errorf("plus with motor: %d channels %v", m.Len(), m.Names())
+	}
+}
@@ -0,0 +1,278 @@
+package nad_nav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"nad-navigation/mixer"
+)
+
+// AckConfig enables the acknowledgment channel of a udp, tcp or unix sink.
+// The bridge answers each version 2 packet on the same socket with one JSON
+// Ack per datagram or line.
+//
+// A packet without an ack after timeout_seconds (default 0.5) counts as lost.
+// When no ack arrives for fault_seconds (default 1) while commands are being
+// sent, the sink raises a fault, logged and reported in its stats until
+// acks resume. When the sink has a mixer, applied PWM values further than
+// pwm_tolerance (default 2) from nad's own mix count as mismatches.
+type AckConfig struct {
+	Enabled        bool    `json:"enabled"`
+	TimeoutSeconds float64 `json:"timeout_seconds"`
+	FaultSeconds   float64 `json:"fault_seconds"`
+	PWMTolerance   int     `json:"pwm_tolerance"`
+}
+
+// Ack is a bridge's acknowledgment of one output packet. PWM holds the
+// values it applied, actuator order; Error is set when applying failed.
+// Watchdog carries the bridge's command-timeout counters, when it has one.
+type Ack struct {
+	Seq       uint32       `json:"seq"`
+	Heartbeat uint32       `json:"heartbeat"`
+	PWM       []uint16     `json:"pwm,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Watchdog  *AckWatchdog `json:"watchdog,omitempty"`
+}
+
+// AckWatchdog is the bridge watchdog state reported in acks.
+type AckWatchdog struct {
+	Missed  uint64 `json:"missed"`
+	Trips   uint64 `json:"trips"`
+	Tripped bool   `json:"tripped"`
+}
+
+// EncodeAck serializes an ack as one JSON object.
+func EncodeAck(a Ack) []byte {
+	data, _ := json.Marshal(a)
+	return data
+}
+
+// ParseAck parses one JSON ack.
+func ParseAck(data []byte) (Ack, error) {
+	var a Ack
+	if err := json.Unmarshal(bytes.TrimSpace(data), &a); err != nil {
+		return Ack{}, fmt.Errorf("ack: %w", err)
+	}
+	return a, nil
+}
+
+// AckStats describes the acknowledgment channel of one sink. RTT values are
+// seconds; RTT is smoothed.
+type AckStats struct {
+	Acked      uint64
+	Lost       uint64 // packets not acked within the timeout
+	Late       uint64 // acks for lost or unknown packets
+	Invalid    uint64 // unparseable acks
+	Mismatched uint64 // acks whose applied PWM differs from nad's mix
+	Errors     uint64 // acks reporting an error
+	LastError  string
+	RTT        float64
+	LastRTT    float64
+	MaxRTT     float64
+	Fault      bool // acks stopped while commands were being sent
+	Faults     uint64
+	Watchdog   *AckWatchdog // latest bridge watchdog report
+}
+
+// String formats the statistics for the console log.
+func (s AckStats) String() string {
+	out := fmt.Sprintf("ack acked=%d lost=%d late=%d mismatched=%d errors=%d rtt=%.2fms max=%.2fms fault=%t faults=%d",
+		s.Acked, s.Lost, s.Late, s.Mismatched, s.Errors, s.RTT*1000, s.MaxRTT*1000, s.Fault, s.Faults)
+	if s.Watchdog != nil {
+		out += fmt.Sprintf(" bridge_missed=%d bridge_trips=%d", s.Watchdog.Missed, s.Watchdog.Trips)
+	}
+	if s.LastError != "" {
+		out += " last_error=" + s.LastError
+	}
+	return out
+}
+
+// ackMaxPending bounds the packets awaiting an ack.
+const ackMaxPending = 1024
+
+// ackTracker matches acks to sent packets.
+type ackTracker struct {
+	name      string
+	timeout   time.Duration
+	fault     time.Duration
+	tolerance int
+	mixer     *mixer.Mixer // nil skips PWM checks
+	clock     Clock        // timestamps received acks
+
+	mu      sync.Mutex
+	pending []pendingAck // in send order
+	since   time.Time    // last ack, or the first send after one
+	stats   AckStats
+}
+
+// pendingAck is a sent packet awaiting its ack.
+type pendingAck struct {
+	seq uint32
+	at  time.Time
+	pwm []uint16
+}
+
+// newAckTracker resolves cfg's defaults. mix, if set, provides the expected
+// PWM values; clock must be the one the sink stamps sends with.
+func newAckTracker(name string, cfg AckConfig, mix *mixer.Mixer, clock Clock) *ackTracker {
+	t := &ackTracker{
+		name:      name,
+		timeout:   500 * time.Millisecond,
+		fault:     time.Second,
+		tolerance: defaultInt(cfg.PWMTolerance, 2),
+		mixer:     mix,
+		clock:     clock,
+	}
+	if cfg.TimeoutSeconds > 0 {
+		t.timeout = time.Duration(cfg.TimeoutSeconds * float64(time.Second))
+	}
+	if cfg.FaultSeconds > 0 {
+		t.fault = time.Duration(cfg.FaultSeconds * float64(time.Second))
+	}
+	return t
+}
+
+// sent records a queued packet.
+func (t *ackTracker) sent(p OutputPacket, now time.Time) {
+	if t == nil {
+		return
+	}
+	var pwm []uint16
+	if t.mixer != nil {
+		pwm = mixCommand(t.mixer, p).PWM
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.since.IsZero() {
+		t.since = now
+	}
+	if len(t.pending) == ackMaxPending {
+		t.pending = t.pending[1:]
+		t.stats.Lost++
+	}
+	t.pending = append(t.pending, pendingAck{seq: p.Seq, at: now, pwm: pwm})
+	t.checkLocked(now)
+}
+
+// receive matches one ack payload.
+func (t *ackTracker) receive(data []byte, now time.Time) {
+	a, err := ParseAck(data)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.stats.Invalid++
+		return
+	}
+	if a.Watchdog != nil {
+		t.stats.Watchdog = a.Watchdog
+	}
+	i := 0
+	for i < len(t.pending) && t.pending[i].seq != a.Seq {
+		i++
+	}
+	if i == len(t.pending) {
+		t.stats.Late++
+		return
+	}
+	sent := t.pending[i]
+	t.pending = append(t.pending[:i], t.pending[i+1:]...)
+
+	rtt := now.Sub(sent.at).Seconds()
+	t.stats.LastRTT = rtt
+	if t.stats.Acked == 0 {
+		t.stats.RTT = rtt
+	} else {
+		t.stats.RTT += 0.1 * (rtt - t.stats.RTT)
+	}
+	t.stats.MaxRTT = max(t.stats.MaxRTT, rtt)
+	t.stats.Acked++
+	if a.Error != "" {
+		t.stats.Errors++
+		t.stats.LastError = a.Error
+	}
+	if sent.pwm != nil && a.PWM != nil && !pwmMatch(sent.pwm, a.PWM, t.tolerance) {
+		t.stats.Mismatched++
+	}
+	t.since = now
+	if t.stats.Fault {
+		t.stats.Fault = false
+		log.Printf("output %s: acks resumed", t.name)
+	}
+}
+
+// checkLocked expires unacked packets and raises the fault. Callers must
+// hold t.mu.
+func (t *ackTracker) checkLocked(now time.Time) {
+	n := 0
+	for n < len(t.pending) && now.Sub(t.pending[n].at) > t.timeout {
+		n++
+	}
+	t.stats.Lost += uint64(n)
+	t.pending = t.pending[n:]
+	if !t.stats.Fault && now.Sub(t.since) > t.fault {
+		t.stats.Fault = true
+		t.stats.Faults++
+		log.Printf("output %s: ack fault: no acks for %.1fs", t.name, now.Sub(t.since).Seconds())
+	}
+}
+
+// Stats returns the counters.
+func (t *ackTracker) Stats() AckStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	if s.Watchdog != nil {
+		wd := *s.Watchdog
+		s.Watchdog = &wd
+	}
+	return s
+}
+
+// read feeds acks from conn until it fails; stream connections carry one
+// ack per line, datagram sockets one per packet.
+func (t *ackTracker) read(conn io.Reader, stream bool) {
+	if stream {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+				t.receive(scanner.Bytes(), t.clock.Now())
+			}
+		}
+		return
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Connected UDP sockets report ICMP port unreachable as a
+			// read error; the bridge may simply not be up yet.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		t.receive(buf[:n], t.clock.Now())
+	}
+}
+
+// pwmMatch reports whether applied matches expected within tolerance.
+func pwmMatch(expected, applied []uint16, tolerance int) bool {
+	if len(expected) != len(applied) {
+		return false
+	}
+	for i := range expected {
+		if d := int(expected[i]) - int(applied[i]); d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	return true
+}
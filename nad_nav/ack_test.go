@@ -0,0 +1,199 @@
+package nad_nav
+
+import (
+	"bufio"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"nad-navigation/mixer"
+)
+
+func TestAckTrackerTiming(t *testing.T) {
+	clock := NewSimClock(time.Unix(1000, 0))
+	acks := newAckTracker("fc", AckConfig{Enabled: true}, nil, clock)
+	send := func(seq uint32) {
+		acks.sent(OutputPacket{Version: OutputProtocolVersion, Seq: seq}, clock.Now())
+	}
+	ack := func(seq uint32) {
+		acks.receive(EncodeAck(Ack{Seq: seq}), clock.Now())
+	}
+
+	send(1)
+	clock.Advance(5 * time.Millisecond)
+	ack(1)
+	send(2)
+	clock.Advance(600 * time.Millisecond)
+	send(3) // expires 2
+	clock.Advance(10 * time.Millisecond)
+	ack(2)
+	ack(3)
+
+	s := acks.Stats()
+	if s.Acked != 2 || s.Lost != 1 || s.Late != 1 {
+		t.Errorf("acked=%d lost=%d late=%d, want 2 1 1", s.Acked, s.Lost, s.Late)
+	}
+	if math.Abs(s.LastRTT-0.010) > 1e-9 || math.Abs(s.MaxRTT-0.010) > 1e-9 {
+		t.Errorf("last rtt %v max %v, want 0.010", s.LastRTT, s.MaxRTT)
+	}
+
+	// Sends without acks for longer than fault_seconds raise the fault.
+	for i := uint32(4); i < 20; i++ {
+		clock.Advance(100 * time.Millisecond)
+		send(i)
+	}
+	if s := acks.Stats(); !s.Fault || s.Faults != 1 {
+		t.Errorf("fault=%t faults=%d, want a fault", s.Fault, s.Faults)
+	}
+	ack(19)
+	if s := acks.Stats(); s.Fault {
+		t.Error("fault should clear when acks resume")
+	}
+}
+
+func TestAckTrackerPWMAndErrors(t *testing.T) {
+	mix, err := mixer.New(mixer.Config{Preset: "x_tail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewSimClock(time.Unix(1000, 0))
+	acks := newAckTracker("fc", AckConfig{Enabled: true}, mix, clock)
+	cmd := BodyCommand{T: 1, Mode: ModeTrack, Yaw: 0.5, Vertical: -0.25, Forward: 0.5}
+	want := mixCommand(mix, OutputPacket{Command: cmd, Armed: true}).PWM
+
+	offset := func(d int) []uint16 {
+		pwm := append([]uint16(nil), want...)
+		pwm[0] = uint16(int(pwm[0]) + d)
+		return pwm
+	}
+	tests := []struct {
+		name string
+		ack  Ack
+	}{
+		{name: "exact", ack: Ack{PWM: want}},
+		{name: "within tolerance", ack: Ack{PWM: offset(-2)}},
+		{name: "outside tolerance", ack: Ack{PWM: offset(3)}},
+		{name: "wrong length", ack: Ack{PWM: want[:len(want)-1]}},
+		{name: "no pwm", ack: Ack{}},
+		{name: "error", ack: Ack{PWM: want, Error: "servo bus timeout"}},
+		{name: "watchdog", ack: Ack{PWM: want, Watchdog: &AckWatchdog{Missed: 4, Trips: 1}}},
+	}
+	for i, tt := range tests {
+		seq := uint32(i + 1)
+		acks.sent(OutputPacket{Version: OutputProtocolVersion, Seq: seq, Command: cmd, Armed: true}, clock.Now())
+		tt.ack.Seq = seq
+		acks.receive(EncodeAck(tt.ack), clock.Now())
+	}
+	acks.receive([]byte("pwm=1500"), clock.Now())
+
+	s := acks.Stats()
+	if s.Acked != uint64(len(tests)) || s.Mismatched != 2 || s.Errors != 1 || s.Invalid != 1 || s.Lost != 0 {
+		t.Errorf("stats %s invalid=%d, want %d acked, 2 mismatched, 1 error, 1 invalid", s, s.Invalid, len(tests))
+	}
+	if s.LastError != "servo bus timeout" {
+		t.Errorf("last error %q", s.LastError)
+	}
+	if s.Watchdog == nil || s.Watchdog.Missed != 4 || s.Watchdog.Trips != 1 {
+		t.Fatalf("watchdog %+v, want the bridge report", s.Watchdog)
+	}
+	s.Watchdog.Missed = 100
+	if acks.Stats().Watchdog.Missed != 4 {
+		t.Error("Stats shares the watchdog report with the tracker")
+	}
+}
+
+func TestAckTrackerPendingLimit(t *testing.T) {
+	clock := NewSimClock(time.Unix(1000, 0))
+	acks := newAckTracker("fc", AckConfig{Enabled: true}, nil, clock)
+	for seq := uint32(1); seq <= ackMaxPending+6; seq++ {
+		acks.sent(OutputPacket{Version: OutputProtocolVersion, Seq: seq}, clock.Now())
+	}
+	acks.receive(EncodeAck(Ack{Seq: 1}), clock.Now())
+	acks.receive(EncodeAck(Ack{Seq: ackMaxPending + 6}), clock.Now())
+	if s := acks.Stats(); s.Lost != 6 || s.Late != 1 || s.Acked != 1 {
+		t.Errorf("lost=%d late=%d acked=%d, want 6 1 1", s.Lost, s.Late, s.Acked)
+	}
+}
+
+func TestAckChannelOverSockets(t *testing.T) {
+	// reply answers each packet the bridge reads with an ack.
+	reply := func(line []byte) []byte {
+		p, err := ParseOutputCSV(strings.TrimSpace(string(line)))
+		if err != nil {
+			t.Errorf("bridge: %v", err)
+			return nil
+		}
+		return EncodeAck(Ack{Seq: p.Seq, Heartbeat: p.Heartbeat, PWM: []uint16{1500}})
+	}
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteToUDP(reply(buf[:n]), from)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			// Blank lines between acks are ignored.
+			_, _ = conn.Write(append(reply(scanner.Bytes()), '\n', '\n'))
+		}
+	}()
+
+	for _, cfg := range []SinkConfig{
+		{Name: "udp", Type: "udp", Addr: udp.LocalAddr().String()},
+		{Name: "tcp", Type: "tcp", Addr: ln.Addr().String()},
+	} {
+		t.Run(cfg.Type, func(t *testing.T) {
+			cfg.Version = OutputProtocolVersion
+			cfg.Ack = AckConfig{Enabled: true}
+			sink, err := NewOutputSink(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+			for i := 0; i < 3; i++ {
+				sink.Send(BodyCommand{T: float64(i) / 10, Mode: ModeTrack})
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				s := sink.Stats().Ack
+				if s == nil {
+					t.Fatal("sink has no ack stats")
+				}
+				if s.Acked == 3 {
+					if s.Lost != 0 || s.Late != 0 || s.Invalid != 0 || s.RTT <= 0 {
+						t.Errorf("ack stats %s", s)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("acked %d packets, want 3", s.Acked)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package nad_nav
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+)
+
+// AttitudeConfig controls the flight-controller attitude input and ego-rotation compensation.
+//
+// Attitude packets are CSV "t,roll,pitch,yaw,rollspeed,pitchspeed,yawspeed" (radians
+// and rad/s, MAVLink ATTITUDE conventions); t is optional. An empty UDPAddr disables
+// compensation unless vehicle.attitude feeds it from MAVLink telemetry.
+type AttitudeConfig struct {
+	UDPAddr           string     `json:"udp_addr"`
+	ReadBuffer        int        `json:"read_buffer"`
+	History           int        `json:"history"`
+	MaxAge            float64    `json:"max_age"`
+	DelaySeconds      float64    `json:"delay_seconds"`
+	CompensateYawRate bool       `json:"compensate_yaw_rate"`
+	Auth              AuthConfig `json:"auth"`
+}
+
+// AttitudeSample is one vehicle attitude reading stamped with controller receive time.
+type AttitudeSample struct {
+	T         float64
+	Roll      float64
+	Pitch     float64
+	Yaw       float64
+	RollRate  float64
+	PitchRate float64
+	YawRate   float64
+}
+
+// attitudeStore keeps a short history of attitude samples for time alignment.
+type attitudeStore struct {
+	mu      sync.RWMutex
+	samples []AttitudeSample
+	next    int
+	count   int
+	maxAge  float64
+}
+
+// newAttitudeStore creates a ring buffer holding up to size samples.
+func newAttitudeStore(size int, maxAge float64) *attitudeStore {
+	if size <= 0 {
+		size = 64
+	}
+	if maxAge <= 0 {
+		maxAge = 0.25
+	}
+	return &attitudeStore{samples: make([]AttitudeSample, size), maxAge: maxAge}
+}
+
+// Add appends a sample, overwriting the oldest one when full.
+func (s *attitudeStore) Add(sample AttitudeSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % len(s.samples)
+	if s.count < len(s.samples) {
+		s.count++
+	}
+}
+
+// At returns the attitude at time t, interpolating between samples or
+// extrapolating the newest one with its rates.
+func (s *attitudeStore) At(t float64) (AttitudeSample, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.count == 0 {
+		return AttitudeSample{}, false
+	}
+
+	newest := s.at(s.count - 1)
+	if t >= newest.T {
+		if t-newest.T > s.maxAge {
+			return AttitudeSample{}, false
+		}
+		return extrapolateAttitude(newest, t), true
+	}
+
+	for i := s.count - 2; i >= 0; i-- {
+		a := s.at(i)
+		if a.T <= t {
+			b := s.at(i + 1)
+			return interpolateAttitude(a, b, t), true
+		}
+	}
+
+	oldest := s.at(0)
+	if oldest.T-t > s.maxAge {
+		return AttitudeSample{}, false
+	}
+	return oldest, true
+}
+
+// at returns the i-th oldest sample. Callers must hold the lock.
+func (s *attitudeStore) at(i int) AttitudeSample {
+	start := (s.next - s.count + len(s.samples)) % len(s.samples)
+	return s.samples[(start+i)%len(s.samples)]
+}
+
+// interpolateAttitude blends two samples linearly, wrapping yaw.
+func interpolateAttitude(a, b AttitudeSample, t float64) AttitudeSample {
+	span := b.T - a.T
+	if span <= 0 {
+		return b
+	}
+	w := (t - a.T) / span
+	lerp := func(x, y float64) float64 { return x + (y-x)*w }
+	return AttitudeSample{
+		T:         t,
+		Roll:      lerp(a.Roll, b.Roll),
+		Pitch:     lerp(a.Pitch, b.Pitch),
+		Yaw:       wrapAngle(a.Yaw + wrapAngle(b.Yaw-a.Yaw)*w),
+		RollRate:  lerp(a.RollRate, b.RollRate),
+		PitchRate: lerp(a.PitchRate, b.PitchRate),
+		YawRate:   lerp(a.YawRate, b.YawRate),
+	}
+}
+
+// extrapolateAttitude advances a sample to time t using its body rates.
+func extrapolateAttitude(a AttitudeSample, t float64) AttitudeSample {
+	dt := t - a.T
+	out := a
+	out.T = t
+	out.Roll += a.RollRate * dt
+	out.Pitch += a.PitchRate * dt
+	out.Yaw = wrapAngle(a.Yaw + a.YawRate*dt)
+	return out
+}
+
+// Derotator removes vehicle ego-rotation from image measurements.
+type Derotator struct {
+	auth   *Authenticator
+	conn   *net.UDPConn
+	camera *CameraModel
+	store  *attitudeStore
+	cfg    AttitudeConfig
+}
+
+// Observation levels the image point using the roll and pitch at time t.
+//
+// The returned cx/cy are what a camera with the same mount would see on a
+// level vehicle, so attitude changes no longer look like target motion.
+func (d *Derotator) Observation(obs AnchorObservation, t float64) AnchorObservation {
+	if d == nil || !obs.Detected {
+		return obs
+	}
+	att, ok := d.store.At(t)
+	if !ok {
+		return obs
+	}
+
+	r := d.camera.ray(obs.CX, obs.CY)
+	fwd, right, up := r[0], r[1], r[2]
+
+	// Body to level frame: undo roll about forward, then pitch about right.
+	sr, cr := math.Sincos(att.Roll)
+	right, up = right*cr+up*sr, -right*sr+up*cr
+	sp, cp := math.Sincos(att.Pitch)
+	fwd, up = fwd*cp-up*sp, fwd*sp+up*cp
+
+	cx, cy, ok := d.camera.project([3]float64{fwd, right, up})
+	if !ok {
+		return obs
+	}
+	obs.CX = cx
+	obs.CY = cy
+	return obs
+}
+
+// State removes the image motion caused by the vehicle yaw rate at time t.
+func (d *Derotator) State(st AnchorState, t float64) AnchorState {
+	if d == nil || !d.cfg.CompensateYawRate {
+		return st
+	}
+	att, ok := d.store.At(t)
+	if !ok {
+		return st
+	}
+
+	// Positive yaw rate turns the nose right, so the world drifts left in the image.
+	const h = 1e-3
+	r := d.camera.ray(st.CX, st.CY)
+	s, c := math.Sincos(-att.YawRate * h)
+	rotated := [3]float64{r[0]*c - r[1]*s, r[0]*s + r[1]*c, r[2]}
+	cx, cy, ok := d.camera.project(rotated)
+	if !ok {
+		return st
+	}
+	st.VX -= (cx - st.CX) / h
+	st.VY -= (cy - st.CY) / h
+	return st
+}
+
+// Latest returns the most recent attitude sample.
+func (d *Derotator) Latest() (AttitudeSample, bool) {
+	if d == nil {
+		return AttitudeSample{}, false
+	}
+	d.store.mu.RLock()
+	defer d.store.mu.RUnlock()
+	if d.store.count == 0 {
+		return AttitudeSample{}, false
+	}
+	return d.store.at(d.store.count - 1), true
+}
+
+// ForCamera returns a compensator for another camera sharing the same
+// attitude stream.
+func (d *Derotator) ForCamera(camera *CameraModel) *Derotator {
+	if d == nil {
+		return nil
+	}
+	return &Derotator{auth: d.auth, conn: d.conn, camera: camera, store: d.store, cfg: d.cfg}
+}
+
+// Add stores an attitude sample stamped with controller time, for sources
+// other than the UDP listener.
+func (d *Derotator) Add(sample AttitudeSample) {
+	if d == nil {
+		return
+	}
+	d.store.Add(sample)
+}
+
+// AuthStats returns the attitude listener's authentication counters.
+func (d *Derotator) AuthStats() (AuthStats, bool) {
+	if d == nil || d.auth == nil {
+		return AuthStats{}, false
+	}
+	return d.auth.Stats(), true
+}
+
+// StartDerotator starts the attitude listener and returns a compensator.
+//
+// It returns nil when attitude input is not configured. now supplies the
+// controller time used to stamp incoming samples.
+func StartDerotator(cfg AttitudeConfig, camera *CameraModel, now func() float64) (*Derotator, error) {
+	if cfg.UDPAddr == "" {
+		return nil, nil
+	}
+	d, err := NewDerotator(cfg, camera)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("attitude.auth: %w", err)
+	}
+	conn, err := startAttitudeListener(cfg, d.store, auth, now)
+	if err != nil {
+		return nil, err
+	}
+	d.auth, d.conn = auth, conn
+	return d, nil
+}
+
+// NewDerotator returns a compensator without a listener; samples arrive
+// through Add.
+func NewDerotator(cfg AttitudeConfig, camera *CameraModel) (*Derotator, error) {
+	if camera == nil {
+		return nil, fmt.Errorf("attitude compensation requires camera.hfov_deg")
+	}
+	store := newAttitudeStore(cfg.History, cfg.MaxAge)
+	return &Derotator{camera: camera, store: store, cfg: cfg}, nil
+}
+
+// startAttitudeListener spawns a goroutine that listens for attitude packets.
+//
+// Packets from senders outside the allowlist or failing verification are dropped.
+func startAttitudeListener(cfg AttitudeConfig, store *attitudeStore, auth *Authenticator, now func() float64) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", cfg.UDPAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	bufSize := cfg.ReadBuffer
+	if bufSize <= 0 {
+		bufSize = 2048
+	}
+
+	go func() {
+		buf := make([]byte, bufSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			if !auth.AllowAddr(from) {
+				continue
+			}
+			payload, err := auth.Open(buf[:n])
+			if err != nil {
+				continue
+			}
+			sample, err := parseAttitudeSample(payload)
+			if err != nil {
+				continue
+			}
+			sample.T = now()
+			store.Add(sample)
+		}
+	}()
+
+	return conn, nil
+}
+
+// Close stops the attitude listener shared by all cameras.
+func (d *Derotator) Close() error {
+	if d == nil || d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+// parseAttitudeSample parses "[t,]roll,pitch,yaw,rollspeed,pitchspeed,yawspeed".
+//
+// The optional flight-controller timestamp is ignored; samples are stamped on receive.
+func parseAttitudeSample(b []byte) (AttitudeSample, error) {
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return AttitudeSample{}, errors.New("empty payload")
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 6 && len(parts) != 7 {
+		return AttitudeSample{}, fmt.Errorf("expected 6 or 7 fields, got %d", len(parts))
+	}
+	if len(parts) == 7 {
+		parts = parts[1:]
+	}
+
+	var values [6]float64
+	for i, part := range parts {
+		v, err := parseF64(part)
+		if err != nil {
+			return AttitudeSample{}, err
+		}
+		values[i] = v
+	}
+	return AttitudeSample{
+		Roll: values[0], Pitch: values[1], Yaw: values[2],
+		RollRate: values[3], PitchRate: values[4], YawRate: values[5],
+	}, nil
+}
+
+// wrapAngle maps an angle into [-pi, pi).
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
@@ -0,0 +1,223 @@
+package nad_nav
+
+import (
+	"math"
+	"testing"
+)
+
+// bodyRay turns a level-frame direction into what the camera sees on a
+// vehicle at the given roll and pitch: the inverse of the de-rotation.
+func bodyRay(level [3]float64, roll, pitch float64) [3]float64 {
+	fwd, right, up := level[0], level[1], level[2]
+	sp, cp := math.Sincos(pitch)
+	fwd, up = fwd*cp+up*sp, -fwd*sp+up*cp
+	sr, cr := math.Sincos(roll)
+	right, up = right*cr-up*sr, right*sr+up*cr
+	return [3]float64{fwd, right, up}
+}
+
+func TestDerotatorObservation(t *testing.T) {
+	camera, err := NewCameraModel(CameraConfig{HFOVDeg: 90, VFOVDeg: 70})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name               string
+		roll, pitch        float64 // degrees
+		bearing, elevation float64 // degrees, level frame
+	}{
+		{name: "level", bearing: 20, elevation: 5},
+		{name: "nose up", pitch: 10},
+		{name: "nose down", pitch: -15, bearing: -10},
+		{name: "right wing down", roll: 30, bearing: 20},
+		{name: "roll and pitch", roll: -25, pitch: 12, bearing: 15, elevation: -8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDerotator(AttitudeConfig{}, camera)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roll, pitch := deg2rad(tt.roll), deg2rad(tt.pitch)
+			d.Add(AttitudeSample{T: 1, Roll: roll, Pitch: pitch})
+
+			level := direction(deg2rad(tt.bearing), deg2rad(tt.elevation))
+			wantCX, wantCY, _ := camera.project(level)
+			cx, cy, ok := camera.project(bodyRay(level, roll, pitch))
+			if !ok {
+				t.Fatal("test setup: target behind the camera")
+			}
+			obs := d.Observation(AnchorObservation{Detected: true, CX: cx, CY: cy, Size: 0.01}, 1)
+			if math.Abs(obs.CX-wantCX) > 1e-9 || math.Abs(obs.CY-wantCY) > 1e-9 {
+				t.Errorf("levelled (%v, %v), want (%v, %v)", obs.CX, obs.CY, wantCX, wantCY)
+			}
+			if obs.Size != 0.01 {
+				t.Errorf("size %v changed", obs.Size)
+			}
+		})
+	}
+}
+
+func TestDerotatorSigns(t *testing.T) {
+	camera, err := NewCameraModel(CameraConfig{HFOVDeg: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDerotator(AttitudeConfig{}, camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nose up 10 degrees: a target on the horizon sits below the image centre
+	// and is levelled back onto it.
+	d.Add(AttitudeSample{T: 0, Pitch: deg2rad(10)})
+	obs := d.Observation(AnchorObservation{Detected: true, CY: math.Tan(deg2rad(10))}, 0)
+	if math.Abs(obs.CX) > 1e-9 || math.Abs(obs.CY) > 1e-9 {
+		t.Errorf("nose up: levelled (%v, %v), want the centre", obs.CX, obs.CY)
+	}
+
+	// Right wing down: a target level and to the right appears raised.
+	d.Add(AttitudeSample{T: 0.01, Roll: deg2rad(30)})
+	cx, cy, _ := camera.project(bodyRay(direction(deg2rad(20), 0), deg2rad(30), 0))
+	if cy >= 0 {
+		t.Fatalf("test setup: rolled target cy %v, want above centre", cy)
+	}
+	obs = d.Observation(AnchorObservation{Detected: true, CX: cx, CY: cy}, 0.01)
+	if math.Abs(obs.CY) > 1e-9 || obs.CX <= 0 {
+		t.Errorf("right wing down: levelled (%v, %v), want on the horizon to the right", obs.CX, obs.CY)
+	}
+}
+
+func TestDerotatorPassThrough(t *testing.T) {
+	camera, err := NewCameraModel(CameraConfig{HFOVDeg: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDerotator(AttitudeConfig{MaxAge: 0.1}, camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := AnchorObservation{Detected: true, CX: 0.3, CY: -0.2}
+	if got := d.Observation(obs, 0); got != obs {
+		t.Errorf("without attitude: %+v, want unchanged", got)
+	}
+
+	d.Add(AttitudeSample{T: 1, Pitch: 0.3})
+	if got := d.Observation(obs, 1.2); got != obs {
+		t.Errorf("stale attitude: %+v, want unchanged", got)
+	}
+	lost := AnchorObservation{CX: 0.3, CY: -0.2}
+	if got := d.Observation(lost, 1); got != lost {
+		t.Errorf("undetected: %+v, want unchanged", got)
+	}
+	var none *Derotator
+	if got := none.Observation(obs, 1); got != obs {
+		t.Errorf("nil derotator: %+v, want unchanged", got)
+	}
+	if _, err := NewDerotator(AttitudeConfig{}, nil); err == nil {
+		t.Error("derotator without a camera model should be rejected")
+	}
+}
+
+func TestDerotatorStateYawRate(t *testing.T) {
+	camera, err := NewCameraModel(CameraConfig{HFOVDeg: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Yawing right at 0.5 rad/s, a still target at the centre drifts left
+	// at d(cx)/d(bearing) = 1 / tan(45 deg) = 1 per radian.
+	st := AnchorState{Valid: true, VX: -0.5, VY: 0.1}
+	for _, compensate := range []bool{false, true} {
+		d, err := NewDerotator(AttitudeConfig{CompensateYawRate: compensate}, camera)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Add(AttitudeSample{T: 0, YawRate: 0.5})
+		got := d.State(st, 0)
+		wantVX := st.VX
+		if compensate {
+			wantVX = 0
+		}
+		if math.Abs(got.VX-wantVX) > 1e-6 || math.Abs(got.VY-st.VY) > 1e-6 {
+			t.Errorf("compensate %v: vx %v vy %v, want %v %v", compensate, got.VX, got.VY, wantVX, st.VY)
+		}
+	}
+}
+
+func TestAttitudeStoreAt(t *testing.T) {
+	s := newAttitudeStore(4, 0.2)
+	s.Add(AttitudeSample{T: 0, Pitch: 0, Yaw: 3.1})
+	s.Add(AttitudeSample{T: 0.1, Pitch: 0.2, Yaw: -3.1, YawRate: 1})
+
+	tests := []struct {
+		name      string
+		t         float64
+		wantOK    bool
+		wantPitch float64
+		wantYaw   float64
+	}{
+		{name: "interpolated across the wrap", t: 0.05, wantOK: true, wantPitch: 0.1, wantYaw: -math.Pi},
+		{name: "newest", t: 0.1, wantOK: true, wantPitch: 0.2, wantYaw: -3.1},
+		{name: "extrapolated", t: 0.2, wantOK: true, wantPitch: 0.2, wantYaw: -3.0},
+		{name: "too new", t: 0.31},
+		{name: "oldest", t: -0.1, wantOK: true, wantPitch: 0, wantYaw: 3.1},
+		{name: "too old", t: -0.21},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, ok := s.At(tt.t)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(a.Pitch-tt.wantPitch) > 1e-9 || math.Abs(wrapAngle(a.Yaw-tt.wantYaw)) > 1e-9 {
+				t.Errorf("pitch %v yaw %v, want %v %v", a.Pitch, a.Yaw, tt.wantPitch, tt.wantYaw)
+			}
+		})
+	}
+
+	// The ring keeps only the newest samples.
+	for i := 2; i < 6; i++ {
+		s.Add(AttitudeSample{T: float64(i) / 10, Pitch: float64(i)})
+	}
+	if a, ok := s.At(0.2); !ok || a.Pitch != 2 {
+		t.Errorf("oldest kept sample %+v %v, want pitch 2", a, ok)
+	}
+}
+
+func TestParseAttitudeSample(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AttitudeSample
+		wantErr bool
+	}{
+		{in: "0.1,0.2,0.3,0.4,0.5,0.6", want: AttitudeSample{Roll: 0.1, Pitch: 0.2, Yaw: 0.3, RollRate: 0.4, PitchRate: 0.5, YawRate: 0.6}},
+		{in: "12.5,0.1,0.2,0.3,0.4,0.5,0.6\n", want: AttitudeSample{Roll: 0.1, Pitch: 0.2, Yaw: 0.3, RollRate: 0.4, PitchRate: 0.5, YawRate: 0.6}},
+		{in: "", wantErr: true},
+		{in: "0.1,0.2,0.3", wantErr: true},
+		{in: "0.1,0.2,0.3,0.4,0.5,x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAttitudeSample([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAttitudeSample(%q) = %+v, %v; want %+v error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWrapAngle(t *testing.T) {
+	for _, tt := range []struct{ in, want float64 }{
+		{0, 0},
+		{math.Pi / 2, math.Pi / 2},
+		{math.Pi, -math.Pi},
+		{3 * math.Pi / 2, -math.Pi / 2},
+		{-3 * math.Pi / 2, math.Pi / 2},
+		{5 * math.Pi, -math.Pi},
+	} {
+		if got := wrapAngle(tt.in); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("wrapAngle(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package nad_nav
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signed envelope layout (little endian), wrapping any observation, attitude
+// or command payload:
+//
+//	offset  size  field
+//	0       2     magic "NS"
+//	2       1     version (1)
+//	3       1     key id
+//	4       8     sequence (uint64, strictly increasing per sender)
+//	12      8     sender wall time in Unix seconds (float64)
+//	20      2     payload length N
+//	22      N     payload
+//	22+N    32    HMAC-SHA256 over all preceding bytes
+//
+// Senders should start the sequence at the current Unix time in microseconds
+// so it keeps increasing across restarts without persistent state.
+const (
+	SignedEnvelopeVersion = 1
+
+	signedHeaderLen = 22
+	signedMACLen    = sha256.Size
+	maxReplayWindow = 64
+)
+
+var signedMagic = [2]byte{'N', 'S'}
+
+// AuthConfig enables packet authentication on a listener.
+//
+// With key_file set every packet must carry a valid signed envelope unless
+// allow_unsigned is true. replay_window (default and maximum 64) bounds how
+// far behind the newest sequence a packet may arrive; max_skew_seconds, when
+// positive, rejects packets whose sender time differs from local wall time by
+// more than that. allow_from lists sender IPs or CIDRs; empty allows all.
+type AuthConfig struct {
+	KeyFile        string   `json:"key_file"`
+	AllowUnsigned  bool     `json:"allow_unsigned"`
+	ReplayWindow   int      `json:"replay_window"`
+	MaxSkewSeconds float64  `json:"max_skew_seconds"`
+	AllowFrom      []string `json:"allow_from"`
+}
+
+var (
+	errAuthUnsigned   = errors.New("unsigned packet")
+	errAuthMalformed  = errors.New("malformed signed packet")
+	errAuthUnknownKey = errors.New("unknown key id")
+	errAuthBadMAC     = errors.New("signature mismatch")
+	errAuthReplay     = errors.New("replayed packet")
+	errAuthStale      = errors.New("packet time outside window")
+)
+
+// AuthStats counts accepted and rejected packets by reason.
+type AuthStats struct {
+	Accepted uint64
+	Rejected map[string]uint64
+}
+
+// String formats the counters for the console log.
+func (s AuthStats) String() string {
+	var parts []string
+	for reason, n := range s.Rejected {
+		parts = append(parts, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("auth accepted=%d rejected={%s}", s.Accepted, strings.Join(parts, " "))
+}
+
+// Authenticator verifies signed envelopes and sender addresses for one listener.
+type Authenticator struct {
+	keys      map[uint8][]byte
+	window    uint64
+	maxSkew   float64
+	unsigned  bool
+	allowNets []*net.IPNet
+
+	mu       sync.Mutex
+	replay   map[uint8]*replayWindow
+	accepted uint64
+	rejected map[string]uint64
+}
+
+// replayWindow is a sliding bitmap of recently seen sequence numbers.
+type replayWindow struct {
+	high   uint64
+	bitmap uint64
+}
+
+// NewAuthenticator builds an authenticator, or returns nil when neither keys
+// nor an allowlist are configured.
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	if cfg.KeyFile == "" && len(cfg.AllowFrom) == 0 {
+		return nil, nil
+	}
+	a := &Authenticator{
+		window:   uint64(cfg.ReplayWindow),
+		maxSkew:  cfg.MaxSkewSeconds,
+		unsigned: cfg.AllowUnsigned || cfg.KeyFile == "",
+		replay:   map[uint8]*replayWindow{},
+		rejected: map[string]uint64{},
+	}
+	if a.window == 0 || a.window > maxReplayWindow {
+		a.window = maxReplayWindow
+	}
+	if cfg.KeyFile != "" {
+		keys, err := LoadAuthKeys(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+	}
+	for _, entry := range cfg.AllowFrom {
+		ipNet, err := parseAllowEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		a.allowNets = append(a.allowNets, ipNet)
+	}
+	return a, nil
+}
+
+// LoadAuthKeys reads a key file.
+//
+// Each non-empty line is "<id> <hex secret>" with id in 0..255; a line holding
+// only a hex secret is key 0. Lines starting with # are comments.
+func LoadAuthKeys(path string) (map[uint8][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := map[uint8][]byte{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var id uint64
+		secret := fields[0]
+		switch len(fields) {
+		case 1:
+		case 2:
+			id, err = strconv.ParseUint(fields[0], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: bad key id %q", path, lineNo, fields[0])
+			}
+			secret = fields[1]
+		default:
+			return nil, fmt.Errorf("%s:%d: expected \"<id> <hex secret>\"", path, lineNo)
+		}
+		key, err := hex.DecodeString(secret)
+		if err != nil || len(key) < 16 {
+			return nil, fmt.Errorf("%s:%d: secret must be at least 16 bytes of hex", path, lineNo)
+		}
+		if _, dup := keys[uint8(id)]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %d", path, lineNo, id)
+		}
+		keys[uint8(id)] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return keys, nil
+}
+
+// parseAllowEntry parses an IP or CIDR allowlist entry.
+func parseAllowEntry(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("allow_from: %w", err)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("allow_from: invalid address %q", entry)
+	}
+	bits := 8 * len(ip)
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// HasAllowlist reports whether sender addresses are restricted.
+func (a *Authenticator) HasAllowlist() bool {
+	return a != nil && len(a.allowNets) > 0
+}
+
+// AllowAddr reports whether a sender address passes the allowlist, counting
+// rejections.
+func (a *Authenticator) AllowAddr(addr net.Addr) bool {
+	if !a.HasAllowlist() {
+		return true
+	}
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		ip = v.IP
+	case *net.TCPAddr:
+		ip = v.IP
+	}
+	for _, n := range a.allowNets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	a.reject("source")
+	return false
+}
+
+// Open verifies a packet and returns the payload it carries.
+//
+// Unsigned packets pass through only when no keys are configured or
+// allow_unsigned is set.
+func (a *Authenticator) Open(packet []byte) ([]byte, error) {
+	if a == nil {
+		return packet, nil
+	}
+	if !isSignedPacket(packet) {
+		if !a.unsigned {
+			a.reject("unsigned")
+			return nil, errAuthUnsigned
+		}
+		a.accept()
+		return packet, nil
+	}
+	payload, err := a.open(packet, float64(time.Now().UnixNano())/1e9)
+	if err != nil {
+		a.reject(authErrorReason(err))
+		return nil, err
+	}
+	a.accept()
+	return payload, nil
+}
+
+// open checks the envelope, signature, time window and replay window.
+func (a *Authenticator) open(packet []byte, now float64) ([]byte, error) {
+	env, err := parseSignedPacket(packet)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := a.keys[env.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", errAuthUnknownKey, env.keyID)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(env.signed)
+	if !hmac.Equal(mac.Sum(nil), env.mac) {
+		return nil, errAuthBadMAC
+	}
+	if a.maxSkew > 0 && math.Abs(now-env.t) > a.maxSkew {
+		return nil, fmt.Errorf("%w: skew %.3fs", errAuthStale, now-env.t)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.replay[env.keyID]
+	if !ok {
+		a.replay[env.keyID] = &replayWindow{high: env.seq, bitmap: 1}
+		return env.payload, nil
+	}
+	if !w.check(env.seq, a.window) {
+		return nil, errAuthReplay
+	}
+	return env.payload, nil
+}
+
+// check accepts seq if it is new and within window of the highest sequence.
+func (w *replayWindow) check(seq, window uint64) bool {
+	if seq > w.high {
+		shift := seq - w.high
+		if shift >= maxReplayWindow {
+			w.bitmap = 1
+		} else {
+			w.bitmap = w.bitmap<<shift | 1
+		}
+		w.high = seq
+		return true
+	}
+	diff := w.high - seq
+	if diff >= window || w.bitmap&(1<<diff) != 0 {
+		return false
+	}
+	w.bitmap |= 1 << diff
+	return true
+}
+
+// Handler wraps next so it only sees verified payloads.
+func (a *Authenticator) Handler(next PacketHandler) PacketHandler {
+	if a == nil {
+		return next
+	}
+	return func(payload []byte, err error) {
+		if err != nil {
+			next(nil, err)
+			return
+		}
+		inner, err := a.Open(payload)
+		if err != nil {
+			return
+		}
+		next(inner, nil)
+	}
+}
+
+// Stats returns a copy of the accept/reject counters.
+func (a *Authenticator) Stats() AuthStats {
+	if a == nil {
+		return AuthStats{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := AuthStats{Accepted: a.accepted, Rejected: make(map[string]uint64, len(a.rejected))}
+	for k, v := range a.rejected {
+		stats.Rejected[k] = v
+	}
+	return stats
+}
+
+func (a *Authenticator) accept() {
+	a.mu.Lock()
+	a.accepted++
+	a.mu.Unlock()
+}
+
+func (a *Authenticator) reject(reason string) {
+	a.mu.Lock()
+	a.rejected[reason]++
+	a.mu.Unlock()
+}
+
+// authErrorReason buckets verification errors into short, stable reason names.
+func authErrorReason(err error) string {
+	switch {
+	case errors.Is(err, errAuthMalformed):
+		return "malformed"
+	case errors.Is(err, errAuthUnknownKey):
+		return "unknown_key"
+	case errors.Is(err, errAuthBadMAC):
+		return "bad_mac"
+	case errors.Is(err, errAuthReplay):
+		return "replay"
+	case errors.Is(err, errAuthStale):
+		return "stale"
+	default:
+		return "other"
+	}
+}
+
+// Sealer signs outgoing payloads with one key.
+type Sealer struct {
+	keyID uint8
+	key   []byte
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// NewSealer loads keyID from keyFile, or returns nil when keyFile is empty.
+func NewSealer(keyFile string, keyID int) (*Sealer, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	if keyID < 0 || keyID > math.MaxUint8 {
+		return nil, fmt.Errorf("key id %d out of range", keyID)
+	}
+	keys, err := LoadAuthKeys(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[uint8(keyID)]
+	if !ok {
+		return nil, fmt.Errorf("%s: no key with id %d", keyFile, keyID)
+	}
+	return &Sealer{keyID: uint8(keyID), key: key, seq: uint64(time.Now().UnixMicro())}, nil
+}
+
+// Seal wraps payload in a signed envelope; a nil sealer returns payload unchanged.
+func (s *Sealer) Seal(payload []byte) []byte {
+	if s == nil {
+		return payload
+	}
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+	return SealPacket(s.key, s.keyID, seq, float64(time.Now().UnixNano())/1e9, payload)
+}
+
+// SealPacket builds a signed envelope around payload.
+func SealPacket(key []byte, keyID uint8, seq uint64, t float64, payload []byte) []byte {
+	buf := make([]byte, signedHeaderLen+len(payload), signedHeaderLen+len(payload)+signedMACLen)
+	buf[0] = signedMagic[0]
+	buf[1] = signedMagic[1]
+	buf[2] = SignedEnvelopeVersion
+	buf[3] = keyID
+	binary.LittleEndian.PutUint64(buf[4:], seq)
+	binary.LittleEndian.PutUint64(buf[12:], math.Float64bits(t))
+	binary.LittleEndian.PutUint16(buf[20:], uint16(len(payload)))
+	copy(buf[signedHeaderLen:], payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(buf)
+	return mac.Sum(buf)
+}
+
+// signedPacket is a parsed, not yet verified, envelope.
+type signedPacket struct {
+	keyID   uint8
+	seq     uint64
+	t       float64
+	payload []byte
+	signed  []byte
+	mac     []byte
+}
+
+// parseSignedPacket splits an envelope into its fields.
+func parseSignedPacket(b []byte) (signedPacket, error) {
+	if len(b) < signedHeaderLen+signedMACLen {
+		return signedPacket{}, fmt.Errorf("%w: %d bytes", errAuthMalformed, len(b))
+	}
+	if b[2] != SignedEnvelopeVersion {
+		return signedPacket{}, fmt.Errorf("%w: version %d", errAuthMalformed, b[2])
+	}
+	n := int(binary.LittleEndian.Uint16(b[20:]))
+	if len(b) != signedHeaderLen+n+signedMACLen {
+		return signedPacket{}, fmt.Errorf("%w: length %d for payload %d", errAuthMalformed, len(b), n)
+	}
+	end := signedHeaderLen + n
+	return signedPacket{
+		keyID:   b[3],
+		seq:     binary.LittleEndian.Uint64(b[4:]),
+		t:       math.Float64frombits(binary.LittleEndian.Uint64(b[12:])),
+		payload: b[signedHeaderLen:end],
+		signed:  b[:end],
+		mac:     b[end:],
+	}, nil
+}
+
+// isSignedPacket reports whether b starts with the signed envelope magic.
+func isSignedPacket(b []byte) bool {
+	return len(b) >= 2 && b[0] == signedMagic[0] && b[1] == signedMagic[1]
+}
+
+// signedPacketLen returns the full envelope length from a buffered header.
+func signedPacketLen(header []byte) int {
+	return signedHeaderLen + int(binary.LittleEndian.Uint16(header[20:])) + signedMACLen
+}
+
+// unsealedPayload returns the payload of an envelope without verifying it.
+// It is only used to pace file replay.
+func unsealedPayload(b []byte) []byte {
+	if !isSignedPacket(b) {
+		return b
+	}
+	env, err := parseSignedPacket(b)
+	if err != nil {
+		return b
+	}
+	return env.payload
+}
@@ -0,0 +1,185 @@
+package nad_nav
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAuthKey = "00112233445566778899aabbccddeeff"
+
+// writeKeyFile writes a key file and returns its path.
+func writeKeyFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestAuthenticator(t *testing.T, cfg AuthConfig) (*Authenticator, []byte) {
+	t.Helper()
+	cfg.KeyFile = writeKeyFile(t, "# test keys\n3 "+testAuthKey+"\n")
+	a, err := NewAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, a.keys[3]
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	a, key := newTestAuthenticator(t, AuthConfig{MaxSkewSeconds: 1})
+	payload := []byte("0.5,0.25,0.01,0.9")
+	now := float64(time.Now().UnixNano()) / 1e9
+	packet := SealPacket(key, 3, 1000, now, payload)
+	if len(packet) != signedHeaderLen+len(payload)+signedMACLen {
+		t.Errorf("envelope is %d bytes", len(packet))
+	}
+	got, err := a.Open(packet)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("Open: %q %v", got, err)
+	}
+	if s := a.Stats(); s.Accepted != 1 || len(s.Rejected) != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	const now = 1e9
+	seal := func(key []byte, keyID uint8, seq uint64, t float64) []byte {
+		return SealPacket(key, keyID, seq, t, []byte("payload"))
+	}
+	tamper := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 0x01
+		return b
+	}
+	a, key := newTestAuthenticator(t, AuthConfig{MaxSkewSeconds: 0.5})
+	good := seal(key, 3, 100, now)
+	other := bytes.Repeat([]byte{0x42}, 16)
+	tests := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"tampered body", tamper(good, signedHeaderLen+1), errAuthBadMAC},
+		{"tampered sequence", tamper(good, 5), errAuthBadMAC},
+		{"tampered tag", tamper(good, len(good)-1), errAuthBadMAC},
+		{"wrong key", seal(other, 3, 100, now), errAuthBadMAC},
+		{"unknown key id", seal(key, 4, 100, now), errAuthUnknownKey},
+		{"truncated", good[:len(good)-1], errAuthMalformed},
+		{"bad version", tamper(good, 2), errAuthMalformed},
+		{"sender ahead", seal(key, 3, 100, now+0.6), errAuthStale},
+		{"sender behind", seal(key, 3, 100, now-0.6), errAuthStale},
+	}
+	for _, tt := range tests {
+		if _, err := a.open(tt.packet, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := a.open(seal(key, 3, 100, now+0.4), now); err != nil {
+		t.Errorf("skew inside the limit: %v", err)
+	}
+}
+
+func TestOpenReplayWindow(t *testing.T) {
+	a, key := newTestAuthenticator(t, AuthConfig{})
+	const now = 1e9
+	open := func(seq uint64) error {
+		_, err := a.open(SealPacket(key, 3, seq, now, []byte("x")), now)
+		return err
+	}
+	if err := open(1000); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		seq  uint64
+		want error
+	}{
+		{"replayed", 1000, errAuthReplay},
+		{"newer", 1010, nil},
+		{"late but new", 1005, nil},
+		{"late replay", 1005, errAuthReplay},
+		{"just inside the window", 1010 - 63, nil},
+		{"just outside the window", 1010 - 64, errAuthReplay},
+		{"far ahead", 1010 + 200, nil},
+		{"old after a jump", 1010, errAuthReplay},
+	}
+	for _, tt := range tests {
+		if err := open(tt.seq); !errors.Is(err, tt.want) {
+			t.Errorf("%s (seq %d): got %v, want %v", tt.name, tt.seq, err, tt.want)
+		}
+	}
+
+	w := &replayWindow{high: 100, bitmap: 1}
+	if w.check(100-8, 8) || !w.check(100-7, 8) {
+		t.Error("replay_window 8 should accept 7 behind and reject 8 behind")
+	}
+}
+
+func TestOpenUnsigned(t *testing.T) {
+	a, _ := newTestAuthenticator(t, AuthConfig{})
+	if _, err := a.Open([]byte("0.5,0.5,0.01,0.9")); !errors.Is(err, errAuthUnsigned) {
+		t.Errorf("got %v, want an unsigned rejection", err)
+	}
+	a, _ = newTestAuthenticator(t, AuthConfig{AllowUnsigned: true})
+	if got, err := a.Open([]byte("plain")); err != nil || string(got) != "plain" {
+		t.Errorf("allow_unsigned: %q %v", got, err)
+	}
+}
+
+func TestAllowAddr(t *testing.T) {
+	a, err := NewAuthenticator(AuthConfig{AllowFrom: []string{"192.168.1.0/24", "10.0.0.7"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 5000}, true},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.8"), Port: 5000}, false},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.2.1"), Port: 5000}, false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := a.AllowAddr(tt.addr); got != tt.want {
+			t.Errorf("%v: allowed %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if n := a.Stats().Rejected["source"]; n != 3 {
+		t.Errorf("source rejections %d, want 3", n)
+	}
+	if _, err := NewAuthenticator(AuthConfig{AllowFrom: []string{"not-an-ip"}}); err == nil {
+		t.Error("invalid allow_from entry should be rejected")
+	}
+}
+
+func TestLoadAuthKeys(t *testing.T) {
+	keys, err := LoadAuthKeys(writeKeyFile(t, testAuthKey+"\n\n# comment\n7 "+testAuthKey+"ff\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || len(keys[0]) != 16 || len(keys[7]) != 17 {
+		t.Errorf("keys %x", keys)
+	}
+	for name, contents := range map[string]string{
+		"empty":        "# nothing\n",
+		"short secret": "1 0011223344\n",
+		"not hex":      "1 zz112233445566778899aabbccddeeff\n",
+		"bad id":       "256 " + testAuthKey + "\n",
+		"extra fields": "1 " + testAuthKey + " x\n",
+		"duplicate id": "1 " + testAuthKey + "\n1 " + testAuthKey + "\n",
+	} {
+		if _, err := LoadAuthKeys(writeKeyFile(t, contents)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
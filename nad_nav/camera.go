@@ -0,0 +1,202 @@
+package nad_nav
+
+import (
+	"fmt"
+	"math"
+)
+
+// CameraConfig describes the lens and mount used to turn image coordinates into angles.
+//
+// A zero HFOVDeg disables the camera model and the controller keeps working in
+// normalized image units.
+type CameraConfig struct {
+	HFOVDeg         float64 `json:"hfov_deg"`
+	VFOVDeg         float64 `json:"vfov_deg"`
+	K1              float64 `json:"k1"`
+	K2              float64 `json:"k2"`
+	MountPitchDeg   float64 `json:"mount_pitch_deg"`
+	MountYawDeg     float64 `json:"mount_yaw_deg"`
+	BoresightCX     float64 `json:"boresight_cx"`
+	BoresightCY     float64 `json:"boresight_cy"`
+	TargetDiameterM float64 `json:"target_diameter_m"`
+}
+
+// CameraModel converts normalized observations into bearing, elevation and range.
+//
+// Conventions:
+//   - bearing is positive to the right of the vehicle nose.
+//   - elevation is positive above the vehicle nose.
+//   - angles are in radians, range is in meters.
+type CameraModel struct {
+	cfg        CameraConfig
+	tanHalfH   float64
+	tanHalfV   float64
+	mountPitch float64
+	mountYaw   float64
+}
+
+// NewCameraModel validates the configuration and returns a camera model.
+//
+// It returns nil when the camera model is not configured.
+func NewCameraModel(cfg CameraConfig) (*CameraModel, error) {
+	if cfg.HFOVDeg == 0 {
+		return nil, nil
+	}
+	if cfg.HFOVDeg <= 0 || cfg.HFOVDeg >= 180 {
+		return nil, fmt.Errorf("camera.hfov_deg must be in (0, 180)")
+	}
+	if cfg.VFOVDeg == 0 {
+		cfg.VFOVDeg = cfg.HFOVDeg
+	}
+	if cfg.VFOVDeg <= 0 || cfg.VFOVDeg >= 180 {
+		return nil, fmt.Errorf("camera.vfov_deg must be in (0, 180)")
+	}
+	if cfg.TargetDiameterM < 0 {
+		return nil, fmt.Errorf("camera.target_diameter_m must be >= 0")
+	}
+	return &CameraModel{
+		cfg:        cfg,
+		tanHalfH:   math.Tan(deg2rad(cfg.HFOVDeg) / 2),
+		tanHalfV:   math.Tan(deg2rad(cfg.VFOVDeg) / 2),
+		mountPitch: deg2rad(cfg.MountPitchDeg),
+		mountYaw:   deg2rad(cfg.MountYawDeg),
+	}, nil
+}
+
+// Angles returns the bearing and elevation of a normalized image point.
+func (c *CameraModel) Angles(cx, cy float64) (float64, float64) {
+	r := c.ray(cx, cy)
+	bearing := math.Atan2(r[1], r[0])
+	elevation := math.Atan2(r[2], math.Hypot(r[0], r[1]))
+	return bearing, elevation
+}
+
+// ray returns the body-frame direction (forward, right, up) of a normalized image point.
+func (c *CameraModel) ray(cx, cy float64) [3]float64 {
+	x, y := c.undistort(cx-c.cfg.BoresightCX, cy-c.cfg.BoresightCY)
+
+	// Ray in the camera frame: forward, right, up. Image cy grows downwards.
+	fwd := 1.0
+	right := x * c.tanHalfH
+	up := -y * c.tanHalfV
+
+	// Mount pitch rotates about the right axis, then mount yaw about the up axis.
+	sp, cp := math.Sincos(c.mountPitch)
+	fwd, up = fwd*cp-up*sp, fwd*sp+up*cp
+	sy, cyaw := math.Sincos(c.mountYaw)
+	fwd, right = fwd*cyaw-right*sy, fwd*sy+right*cyaw
+	return [3]float64{fwd, right, up}
+}
+
+// project maps a body-frame direction back to normalized image coordinates.
+//
+// It returns false when the direction is behind the camera.
+func (c *CameraModel) project(r [3]float64) (float64, float64, bool) {
+	fwd, right, up := r[0], r[1], r[2]
+
+	sy, cyaw := math.Sincos(-c.mountYaw)
+	fwd, right = fwd*cyaw-right*sy, fwd*sy+right*cyaw
+	sp, cp := math.Sincos(-c.mountPitch)
+	fwd, up = fwd*cp-up*sp, fwd*sp+up*cp
+	if fwd <= 1e-9 {
+		return 0, 0, false
+	}
+
+	x := right / fwd / c.tanHalfH
+	y := -up / fwd / c.tanHalfV
+	x, y = c.distort(x, y)
+	return x + c.cfg.BoresightCX, y + c.cfg.BoresightCY, true
+}
+
+// Range estimates the distance to the target from its apparent size.
+//
+// size is treated as the bounding-box area fraction of the image, so a round
+// target of apparent angular diameter theta satisfies
+// tan(theta/2)^2 = size * tan(hfov/2) * tan(vfov/2).
+func (c *CameraModel) Range(size float64) (float64, bool) {
+	if c == nil || c.cfg.TargetDiameterM <= 0 || size <= 0 {
+		return 0, false
+	}
+	tanHalf := math.Sqrt(size * c.tanHalfH * c.tanHalfV)
+	if tanHalf <= 0 {
+		return 0, false
+	}
+	return c.cfg.TargetDiameterM / (2 * tanHalf), true
+}
+
+// size is the inverse of Range: the apparent size of the target at rng meters.
+func (c *CameraModel) size(rng float64) (float64, bool) {
+	if c.cfg.TargetDiameterM <= 0 || rng <= 0 {
+		return 0, false
+	}
+	tanHalf := c.cfg.TargetDiameterM / (2 * rng)
+	return tanHalf * tanHalf / (c.tanHalfH * c.tanHalfV), true
+}
+
+// Observe converts a raw observation into angles and range.
+func (c *CameraModel) Observe(obs AnchorObservation) (bearing, elevation, rng float64, hasRange bool) {
+	if c == nil {
+		return 0, 0, 0, false
+	}
+	bearing, elevation = c.Angles(obs.CX, obs.CY)
+	rng, hasRange = c.Range(obs.Size)
+	return bearing, elevation, rng, hasRange
+}
+
+// Annotate fills the angular and metric fields of a filtered state.
+func (c *CameraModel) Annotate(st AnchorState) AnchorState {
+	if c == nil {
+		return st
+	}
+	st.Bearing, st.Elevation = c.Angles(st.CX, st.CY)
+
+	// Angular rates via a short step along the image-plane velocity.
+	const h = 1e-3
+	b2, e2 := c.Angles(st.CX+st.VX*h, st.CY+st.VY*h)
+	st.VBearing = (b2 - st.Bearing) / h
+	st.VElevation = (e2 - st.Elevation) / h
+
+	st.Range, st.HasRange = c.Range(st.Size)
+	if st.HasRange {
+		if r2, ok := c.Range(st.Size + st.VSize*h); ok {
+			st.VRange = (r2 - st.Range) / h
+		}
+	}
+	return st
+}
+
+// undistort applies the inverse radial distortion to normalized coordinates.
+func (c *CameraModel) undistort(x, y float64) (float64, float64) {
+	if c.cfg.K1 == 0 && c.cfg.K2 == 0 {
+		return x, y
+	}
+	// Fixed-point iteration of x_d = x_u * (1 + k1 r^2 + k2 r^4).
+	ux, uy := x, y
+	for i := 0; i < 5; i++ {
+		r2 := ux*ux + uy*uy
+		scale := 1 + c.cfg.K1*r2 + c.cfg.K2*r2*r2
+		if scale == 0 {
+			break
+		}
+		ux = x / scale
+		uy = y / scale
+	}
+	return ux, uy
+}
+
+// distort applies the forward radial distortion to normalized coordinates.
+func (c *CameraModel) distort(x, y float64) (float64, float64) {
+	r2 := x*x + y*y
+	scale := 1 + c.cfg.K1*r2 + c.cfg.K2*r2*r2
+	return x * scale, y * scale
+}
+
+// deg2rad converts degrees to radians.
+func deg2rad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// rad2deg converts radians to degrees.
+func rad2deg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
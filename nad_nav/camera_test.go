@@ -0,0 +1,135 @@
+package nad_nav
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewCameraModelValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     CameraConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", cfg: CameraConfig{}, wantNil: true},
+		{name: "negative hfov", cfg: CameraConfig{HFOVDeg: -10}, wantErr: true},
+		{name: "hfov 180", cfg: CameraConfig{HFOVDeg: 180}, wantErr: true},
+		{name: "negative vfov", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: -1}, wantErr: true},
+		{name: "vfov 180", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: 180}, wantErr: true},
+		{name: "negative diameter", cfg: CameraConfig{HFOVDeg: 90, TargetDiameterM: -1}, wantErr: true},
+		{name: "valid", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: 60, TargetDiameterM: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCameraModel(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (c == nil) != tt.wantNil {
+				t.Errorf("model %v, want nil %v", c, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestCameraModelAngles(t *testing.T) {
+	tests := []struct {
+		name                  string
+		cfg                   CameraConfig
+		cx, cy                float64
+		wantBearing, wantElev float64 // degrees
+	}{
+		{name: "centre", cfg: CameraConfig{HFOVDeg: 90}, wantBearing: 0, wantElev: 0},
+		{name: "right edge", cfg: CameraConfig{HFOVDeg: 90}, cx: 1, wantBearing: 45},
+		{name: "left edge", cfg: CameraConfig{HFOVDeg: 90}, cx: -1, wantBearing: -45},
+		{name: "top edge", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: 60}, cy: -1, wantElev: 30},
+		{name: "bottom edge", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: 60}, cy: 1, wantElev: -30},
+		{name: "mount yaw", cfg: CameraConfig{HFOVDeg: 90, MountYawDeg: 90}, wantBearing: 90},
+		{name: "mount pitch", cfg: CameraConfig{HFOVDeg: 90, MountPitchDeg: -20}, wantElev: -20},
+		{name: "boresight offset", cfg: CameraConfig{HFOVDeg: 90, BoresightCX: 1}, cx: 1, wantBearing: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCameraModel(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bearing, elev := c.Angles(tt.cx, tt.cy)
+			if math.Abs(rad2deg(bearing)-tt.wantBearing) > 1e-9 || math.Abs(rad2deg(elev)-tt.wantElev) > 1e-9 {
+				t.Errorf("bearing %v elevation %v, want %v %v", rad2deg(bearing), rad2deg(elev), tt.wantBearing, tt.wantElev)
+			}
+		})
+	}
+}
+
+func TestCameraModelProjectRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  CameraConfig
+	}{
+		{name: "pinhole", cfg: CameraConfig{HFOVDeg: 90, VFOVDeg: 70}},
+		{name: "distorted", cfg: CameraConfig{HFOVDeg: 90, K1: 0.05, K2: 0.01}},
+		{name: "mounted", cfg: CameraConfig{HFOVDeg: 60, MountPitchDeg: -30, MountYawDeg: 45, BoresightCX: 0.1, BoresightCY: -0.05}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCameraModel(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range [][2]float64{{0, 0}, {0.5, -0.25}, {-0.8, 0.6}, {0.3, 0.9}} {
+				cx, cy, ok := c.project(c.ray(p[0], p[1]))
+				if !ok || math.Abs(cx-p[0]) > 1e-4 || math.Abs(cy-p[1]) > 1e-4 {
+					t.Errorf("(%v, %v) round trips to (%v, %v) ok %v", p[0], p[1], cx, cy, ok)
+				}
+			}
+			// A direction behind the camera does not project.
+			r := c.ray(0, 0)
+			if _, _, ok := c.project([3]float64{-r[0], -r[1], -r[2]}); ok {
+				t.Error("direction behind the camera projected")
+			}
+		})
+	}
+}
+
+func TestCameraModelRange(t *testing.T) {
+	// tan(hfov/2) = tan(vfov/2) = 1, so size = tan(theta/2)^2.
+	c, err := NewCameraModel(CameraConfig{HFOVDeg: 90, TargetDiameterM: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		size   float64
+		want   float64
+		wantOK bool
+	}{
+		{size: 0.01, want: 5, wantOK: true},
+		{size: 0.25, want: 1, wantOK: true},
+		{size: 0},
+		{size: -0.1},
+	}
+	for _, tt := range tests {
+		rng, ok := c.Range(tt.size)
+		if ok != tt.wantOK || math.Abs(rng-tt.want) > 1e-9 {
+			t.Errorf("Range(%v) = %v %v, want %v %v", tt.size, rng, ok, tt.want, tt.wantOK)
+		}
+		if ok {
+			if size, _ := c.size(rng); math.Abs(size-tt.size) > 1e-12 {
+				t.Errorf("size(%v) = %v, want %v", rng, size, tt.size)
+			}
+		}
+	}
+
+	noDiameter, err := NewCameraModel(CameraConfig{HFOVDeg: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := noDiameter.Range(0.01); ok {
+		t.Error("range without a target diameter")
+	}
+	var none *CameraModel
+	if _, _, _, ok := none.Observe(AnchorObservation{Detected: true, Size: 0.01}); ok {
+		t.Error("nil model reported a range")
+	}
+}
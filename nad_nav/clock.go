@@ -0,0 +1,187 @@
+package nad_nav
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock supplies time to the live loop, sources and controller.
+//
+// RealClock reads the system clock; SimClock only moves when advanced, so
+// replays and simulations run deterministically at any speed.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Timer is the subset of time.Timer used by the loop.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the system clock.
+type RealClock struct{}
+
+// Now implements Clock.
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer implements Clock.
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// Sleep implements Clock.
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// SimClock is a manually advanced clock.
+//
+// Timers fire, in deadline order, when Advance or Set moves the clock past
+// their deadline; Sleep blocks until another goroutine advances the clock.
+type SimClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*simTimer
+}
+
+// NewSimClock creates a simulated clock reading start.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+// Now implements Clock.
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements Clock.
+func (c *SimClock) NewTimer(d time.Duration) Timer {
+	t := &simTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Sleep implements Clock.
+func (c *SimClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-c.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing due timers.
+func (c *SimClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due timers. Moving backwards is ignored.
+func (c *SimClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.now) {
+		return
+	}
+	sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].deadline.Before(c.timers[j].deadline) })
+	kept := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(t) {
+			kept = append(kept, timer)
+			continue
+		}
+		c.now = timer.deadline
+		timer.active = false
+		select {
+		case timer.ch <- timer.deadline:
+		default:
+		}
+	}
+	c.timers = kept
+	c.now = t
+}
+
+// NextDeadline returns the earliest pending timer deadline.
+func (c *SimClock) NextDeadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Time
+	for i, timer := range c.timers {
+		if i == 0 || timer.deadline.Before(next) {
+			next = timer.deadline
+		}
+	}
+	return next, len(c.timers) > 0
+}
+
+type simTimer struct {
+	clock    *SimClock
+	ch       chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *simTimer) C() <-chan time.Time { return t.ch }
+
+// Stop implements Timer; it reports whether the timer was pending.
+func (t *simTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return t.remove()
+}
+
+// Reset implements Timer; it reports whether the timer was pending.
+func (t *simTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	wasActive := t.remove()
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		c.mu.Unlock()
+		select {
+		case t.ch <- t.deadline:
+		default:
+		}
+		return wasActive
+	}
+	t.active = true
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	return wasActive
+}
+
+// remove drops the timer from the pending list. Callers must hold the clock lock.
+func (t *simTimer) remove() bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// sinceSeconds returns a clock function measuring seconds since t0.
+func sinceSeconds(clock Clock, t0 time.Time) func() float64 {
+	return func() float64 { return clock.Now().Sub(t0).Seconds() }
+}
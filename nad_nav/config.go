@@ -7,15 +7,41 @@ import (
 	"strings"
 )
 
-// LiveConfig controls UDP input settings for camera observations.
+// LiveConfig controls input settings for camera observations.
+//
+// Source selects the transport: "udp" (default), "tcp", "unix", "stdin", "file" or "pipe".
+// Ingest selects "latest" (default, newest observation per tick) or "queue"
+// (every observation in order, bounded by queue_size with the given overflow
+// policy: "drop_oldest" (default) or "drop_newest").
 type LiveConfig struct {
-	UDPAddr    string `json:"udp_addr"`
-	ReadBuffer int    `json:"read_buffer"`
+	Source            string     `json:"source"`
+	UDPAddr           string     `json:"udp_addr"`
+	TCPAddr           string     `json:"tcp_addr"`
+	Path              string     `json:"path"`
+	ReplaySpeed       float64    `json:"replay_speed"`
+	ReplayLoop        bool       `json:"replay_loop"`
+	ReadBuffer        int        `json:"read_buffer"`
+	ClockResetSeconds float64    `json:"clock_reset_seconds"`
+	StaleSeconds      float64    `json:"stale_seconds"`
+	Ingest            string     `json:"ingest"`
+	QueueSize         int        `json:"queue_size"`
+	Overflow          string     `json:"overflow"`
+	Auth              AuthConfig `json:"auth"`
 }
 
-// OutputConfig controls UDP output settings for controller commands.
+// OutputConfig lists the destinations for controller commands.
+//
+// udp_addr is shorthand for a CSV UDP sink named "udp" using version and
+// target like SinkConfig; when key_file is set its commands are wrapped in a
+// signed envelope using the key with id key_id. Sinks declares further
+// destinations.
 type OutputConfig struct {
-	UDPAddr string `json:"udp_addr"`
+	UDPAddr string       `json:"udp_addr"`
+	Version int          `json:"version"`
+	Target  bool         `json:"target"`
+	KeyFile string       `json:"key_file"`
+	KeyID   int          `json:"key_id"`
+	Sinks   []SinkConfig `json:"sinks"`
 }
 
 // LogConfig controls console logging.
@@ -26,10 +52,18 @@ type LogConfig struct {
 // AppConfig aggregates all configuration sections.
 type AppConfig struct {
 	Hz         float64          `json:"hz"`
+	Loop       LoopConfig       `json:"loop"`
 	Tracker    TrackerConfig    `json:"tracker"`
 	Controller ControllerConfig `json:"controller"`
+	Camera     CameraConfig     `json:"camera"`
+	Attitude   AttitudeConfig   `json:"attitude"`
+	Vehicle    VehicleConfig    `json:"vehicle"`
+	Latency    LatencyConfig    `json:"latency"`
 	Live       LiveConfig       `json:"live"`
+	Inputs     []InputConfig    `json:"inputs"`
+	Fusion     FusionConfig     `json:"fusion"`
 	Output     OutputConfig     `json:"output"`
+	Shutdown   ShutdownConfig   `json:"shutdown"`
 	Viz        VizConfig        `json:"viz"`
 	Log        LogConfig        `json:"log"`
 }
@@ -1,6 +1,9 @@
 package nad_nav
 
-import "math"
+import (
+	"math"
+	"time"
+)
 
 // ModeCommandConfig defines constant offsets applied per mode.
 type ModeCommandConfig struct {
@@ -22,7 +25,12 @@ type ControllerConfig struct {
 	YTol               float64 `json:"y_tol"`
 	CenteredHoldFrames int     `json:"centered_hold_frames"`
 
-	SizeCapture float64 `json:"size_capture"`
+	SizeCapture   float64 `json:"size_capture"`
+	CaptureRangeM float64 `json:"capture_range_m"`
+
+	// Units selects the error space: "normalized" (default) or "angles".
+	// In angle units x_tol, y_tol, x_gate, y_gate and gains are per radian.
+	Units string `json:"units"`
 
 	KpX float64 `json:"kp_x"`
 	KdX float64 `json:"kd_x"`
@@ -45,6 +53,8 @@ type ControllerConfig struct {
 	FlyStraightYaw      float64 `json:"fly_straight_yaw"`
 	FlyStraightVertical float64 `json:"fly_straight_vertical"`
 	FlyStraightAfter    Mode    `json:"fly_straight_after_mode"`
+
+	VehicleGuard VehicleGuardConfig `json:"vehicle_guard"`
 }
 
 // DroneController implements the state machine and PD control.
@@ -57,6 +67,9 @@ type DroneController struct {
 	lateralStartT *float64
 	lastCmd       BodyCommand
 	hasLastCmd    bool
+	now           func() float64
+	vehicle       *VehicleState
+	guardReason   string
 }
 
 // NewDroneController constructs a controller with the given configuration.
@@ -64,9 +77,51 @@ func NewDroneController(cfg ControllerConfig) *DroneController {
 	return &DroneController{Cfg: cfg, mode: cfg.DefaultMode}
 }
 
+// Mode returns the mode of the most recent command, or the default mode
+// before the first step.
+func (dc *DroneController) Mode() Mode {
+	if dc.hasLastCmd {
+		return dc.lastCmd.Mode
+	}
+	return dc.mode
+}
+
+// SetClock makes the controller read time from clock, in seconds since t0,
+// instead of the state timestamp. Mode timers and command times then follow
+// the clock even when the state carries camera time.
+func (dc *DroneController) SetClock(clock Clock, t0 time.Time) {
+	dc.now = sinceSeconds(clock, t0)
+}
+
+// SetVehicle gives the controller the flight controller's latest telemetry.
+// Once set, the vehicle guard is checked on every step.
+func (dc *DroneController) SetVehicle(v VehicleState) {
+	dc.vehicle = &v
+}
+
+// GuardReason returns why the vehicle guard forced STOP on the last step, or
+// "" when it did not.
+func (dc *DroneController) GuardReason() string {
+	return dc.guardReason
+}
+
 // Step computes the next command for the current time step.
 func (dc *DroneController) Step(st AnchorState, dt float64) BodyCommand {
-	cmd := dc.step(st, dt)
+	if dc.now != nil {
+		st.T = dc.now()
+	}
+	dc.guardReason = ""
+	if dc.vehicle != nil {
+		dc.guardReason = dc.Cfg.VehicleGuard.Check(*dc.vehicle)
+	}
+	var cmd BodyCommand
+	if dc.guardReason != "" {
+		// Neutral rather than the held STOP command: the vehicle itself is
+		// not in a state to follow the target.
+		cmd = BodyCommand{T: st.T, Mode: ModeStop}
+	} else {
+		cmd = dc.step(st, dt)
+	}
 	dc.lastCmd = cmd
 	dc.hasLastCmd = true
 	return cmd
@@ -124,14 +179,15 @@ func (dc *DroneController) selectMode(st AnchorState) Mode {
 		return ModeSearch
 	}
 
-	centered := math.Abs(st.CX) < dc.Cfg.XTol && math.Abs(st.CY) < dc.Cfg.YTol
+	x, y, _, _ := dc.errorInputs(st)
+	centered := math.Abs(x) < dc.Cfg.XTol && math.Abs(y) < dc.Cfg.YTol
 	if centered {
 		dc.centeredCount++
 	} else {
 		dc.centeredCount = 0
 	}
 
-	if st.Size >= dc.Cfg.SizeCapture && dc.centeredCount >= dc.Cfg.CenteredHoldFrames {
+	if dc.closeEnough(st) && dc.centeredCount >= dc.Cfg.CenteredHoldFrames {
 		return ModeCapture
 	}
 	if dc.centeredCount >= dc.Cfg.CenteredHoldFrames {
@@ -140,6 +196,24 @@ func (dc *DroneController) selectMode(st AnchorState) Mode {
 	return ModeTrack
 }
 
+// closeEnough reports whether the target is near enough to capture.
+func (dc *DroneController) closeEnough(st AnchorState) bool {
+	if dc.Cfg.CaptureRangeM > 0 && st.HasRange {
+		return st.Range <= dc.Cfg.CaptureRangeM
+	}
+	return st.Size >= dc.Cfg.SizeCapture
+}
+
+// errorInputs returns the horizontal/vertical target offsets and rates in controller units.
+//
+// Both unit systems share the image sign convention: x positive right, y positive down.
+func (dc *DroneController) errorInputs(st AnchorState) (x, y, vx, vy float64) {
+	if dc.Cfg.Units == "angles" {
+		return st.Bearing, -st.Elevation, st.VBearing, -st.VElevation
+	}
+	return st.CX, st.CY, st.VX, st.VY
+}
+
 // applyModePolicy clamps the desired mode to the allowed set.
 func (dc *DroneController) applyModePolicy(desired Mode) Mode {
 	if len(dc.Cfg.AllowedModes) == 0 {
@@ -182,23 +256,24 @@ func (dc *DroneController) commandForMode(mode Mode, st AnchorState, dt float64)
 
 // commandTrackLike computes PD steering for TRACK/APPROACH behaviors.
 func (dc *DroneController) commandTrackLike(mode Mode, base ModeCommandConfig, st AnchorState) BodyCommand {
-	cx := st.CX + st.VX*dc.Cfg.TLead
-	cy := st.CY + st.VY*dc.Cfg.TLead
+	x, y, vx, vy := dc.errorInputs(st)
+	cx := x + vx*dc.Cfg.TLead
+	cy := y + vy*dc.Cfg.TLead
 	ex := -cx
 	ey := -cy
 
-	yaw := base.Yaw + dc.Cfg.KpX*ex + dc.Cfg.KdX*(-st.VX)
-	vertical := base.Vertical + dc.Cfg.KpY*ey + dc.Cfg.KdY*(-st.VY)
+	yaw := base.Yaw + dc.Cfg.KpX*ex + dc.Cfg.KdX*(-vx)
+	vertical := base.Vertical + dc.Cfg.KpY*ey + dc.Cfg.KdY*(-vy)
 
 	yaw = clamp(yaw, -1, 1)
 	vertical = clamp(vertical, -1, 1)
 
-	centeredNow := math.Abs(st.CX) < dc.Cfg.XTol && math.Abs(st.CY) < dc.Cfg.YTol
+	centeredNow := math.Abs(x) < dc.Cfg.XTol && math.Abs(y) < dc.Cfg.YTol
 	var forward float64
 	if centeredNow {
 		forward = dc.Cfg.MaxForward
 	} else {
-		gate := math.Max(0, 1-math.Abs(st.CX)/dc.Cfg.XGate) * math.Max(0, 1-math.Abs(st.CY)/dc.Cfg.YGate)
+		gate := math.Max(0, 1-math.Abs(x)/dc.Cfg.XGate) * math.Max(0, 1-math.Abs(y)/dc.Cfg.YGate)
 		if mode == ModeTrack {
 			forward = 0.10 * gate
 		} else {
@@ -214,9 +289,10 @@ func (dc *DroneController) commandTrackLike(mode Mode, base ModeCommandConfig, s
 
 // commandLateralOnly outputs yaw corrections without forward movement.
 func (dc *DroneController) commandLateralOnly(st AnchorState) BodyCommand {
-	cx := st.CX + st.VX*dc.Cfg.TLead
+	x, _, vx, _ := dc.errorInputs(st)
+	cx := x + vx*dc.Cfg.TLead
 	ex := -cx
-	yaw := clamp(dc.Cfg.KpX*ex+dc.Cfg.KdX*(-st.VX), -1, 1)
+	yaw := clamp(dc.Cfg.KpX*ex+dc.Cfg.KdX*(-vx), -1, 1)
 	forward := 0.0
 	modeOut := ModeStop
 	if !st.Valid {
@@ -0,0 +1,508 @@
+package nad_nav
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Binary command packet layout, version 1 (little endian):
+//
+//	offset  size  field
+//	0       2     magic "NC"
+//	2       1     version (1)
+//	3       1     mode
+//	4       8     command time in seconds (float64)
+//	12      4     yaw (float32)
+//	16      4     vertical (float32)
+//	20      4     forward (float32)
+//	24      4     CRC32 (IEEE) of all preceding bytes
+//
+// Version 2 adds sequence, heartbeat, flags and optional target and sample
+// sections:
+//
+//	offset  size  field
+//	0       2     magic "NC"
+//	2       1     version (2)
+//	3       1     mode
+//	4       1     flags (bit 0: armed, bit 1: target present, bit 2: target
+//	              valid, bit 3: sample present)
+//	5       4     sequence (uint32, wraps)
+//	9       4     heartbeat (uint32, wraps)
+//	13      8     command time in seconds (float64)
+//	21      4     yaw (float32)
+//	25      4     vertical (float32)
+//	29      4     forward (float32)
+//	33      16    target confidence, cx, cy, size (float32 each), if present
+//	33/49   4     sample offset in seconds (float32), if present
+//	33..53  4     CRC32 (IEEE) of all preceding bytes
+const (
+	CommandProtocolVersion = 1
+	OutputProtocolVersion  = 2
+
+	commandPacketLen   = 28
+	outputHeaderLen    = 33
+	outputTargetLen    = 16
+	outputSampleLen    = 4
+	outputFlagArmed    = 0x01
+	outputFlagTarget   = 0x02
+	outputFlagValid    = 0x04
+	outputFlagSample   = 0x08
+	outputCSVPrefix    = "v2"
+	outputCSVFields    = 9
+	outputCSVTargetLen = 5
+)
+
+var commandMagic = [2]byte{'N', 'C'}
+
+// OutputTarget is the target state carried by version 2 output packets.
+type OutputTarget struct {
+	Valid      bool
+	Confidence float64
+	CX         float64
+	CY         float64
+	Size       float64
+}
+
+// OutputPacket is one command with the context carried by versioned output
+// formats. Armed is false during the shutdown burst and, with a vehicle
+// link, while the vehicle is disarmed or its link is lost, so bridges can
+// neutralize actuators. STOP commands from an armed sink still carry the
+// controller's yaw and vertical and must be applied.
+//
+// Seq counts packets a sink sends. Heartbeat counts commands the control
+// loop handed the sink, including rate-limited ones, so it only advances
+// while the loop runs; keepalive repeats carry the same value.
+//
+// Sinks with an output schedule set HasSample. Command.T is then still the
+// time of the loop command a packet was resampled from, and SampleOffset is
+// the offset in seconds of the sent values from it: negative for linear
+// interpolation, positive for extrapolation and 0 for held commands.
+type OutputPacket struct {
+	Version      int
+	Seq          uint32
+	Heartbeat    uint32
+	Command      BodyCommand
+	Armed        bool
+	HasTarget    bool
+	Target       OutputTarget
+	HasSample    bool
+	SampleOffset float64
+}
+
+// newOutputPacket builds the packet for cmd; st may be nil when the target
+// state is unknown.
+func newOutputPacket(seq uint32, cmd BodyCommand, st *AnchorState, armed bool) OutputPacket {
+	p := OutputPacket{
+		Version: OutputProtocolVersion,
+		Seq:     seq,
+		Command: cmd,
+		Armed:   armed,
+	}
+	if st != nil {
+		p.HasTarget = true
+		p.Target = OutputTarget{
+			Valid:      st.Valid,
+			Confidence: st.Confidence,
+			CX:         st.CX,
+			CY:         st.CY,
+			Size:       st.Size,
+		}
+	}
+	return p
+}
+
+// EncodeCommandPacket serializes a command in the version 1 binary format.
+func EncodeCommandPacket(cmd BodyCommand) []byte {
+	buf := make([]byte, commandPacketLen)
+	copy(buf[0:2], commandMagic[:])
+	buf[2] = CommandProtocolVersion
+	buf[3] = byte(cmd.Mode)
+	binary.LittleEndian.PutUint64(buf[4:12], math.Float64bits(cmd.T))
+	binary.LittleEndian.PutUint32(buf[12:16], math.Float32bits(float32(cmd.Yaw)))
+	binary.LittleEndian.PutUint32(buf[16:20], math.Float32bits(float32(cmd.Vertical)))
+	binary.LittleEndian.PutUint32(buf[20:24], math.Float32bits(float32(cmd.Forward)))
+	binary.LittleEndian.PutUint32(buf[24:28], crc32.ChecksumIEEE(buf[:24]))
+	return buf
+}
+
+// DecodeCommandPacket parses a version 1 binary command packet.
+func DecodeCommandPacket(data []byte) (BodyCommand, error) {
+	if len(data) < commandPacketLen {
+		return BodyCommand{}, errPacketTruncated
+	}
+	if data[0] != commandMagic[0] || data[1] != commandMagic[1] {
+		return BodyCommand{}, fmt.Errorf("bad magic %q", data[0:2])
+	}
+	if data[2] != CommandProtocolVersion {
+		return BodyCommand{}, errPacketVersion
+	}
+	if binary.LittleEndian.Uint32(data[24:28]) != crc32.ChecksumIEEE(data[:24]) {
+		return BodyCommand{}, errPacketCRC
+	}
+	return BodyCommand{
+		T:        math.Float64frombits(binary.LittleEndian.Uint64(data[4:12])),
+		Mode:     Mode(data[3]),
+		Yaw:      float64(math.Float32frombits(binary.LittleEndian.Uint32(data[12:16]))),
+		Vertical: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[16:20]))),
+		Forward:  float64(math.Float32frombits(binary.LittleEndian.Uint32(data[20:24]))),
+	}, nil
+}
+
+// EncodeOutputPacket serializes p in the version 2 binary format; the target
+// and sample sections are present when p.HasTarget and p.HasSample are set.
+func EncodeOutputPacket(p OutputPacket) []byte {
+	n := outputHeaderLen + 4
+	if p.HasTarget {
+		n += outputTargetLen
+	}
+	if p.HasSample {
+		n += outputSampleLen
+	}
+	buf := make([]byte, n)
+	copy(buf[0:2], commandMagic[:])
+	buf[2] = OutputProtocolVersion
+	buf[3] = byte(p.Command.Mode)
+	if p.Armed {
+		buf[4] |= outputFlagArmed
+	}
+	binary.LittleEndian.PutUint32(buf[5:9], p.Seq)
+	binary.LittleEndian.PutUint32(buf[9:13], p.Heartbeat)
+	binary.LittleEndian.PutUint64(buf[13:21], math.Float64bits(p.Command.T))
+	binary.LittleEndian.PutUint32(buf[21:25], math.Float32bits(float32(p.Command.Yaw)))
+	binary.LittleEndian.PutUint32(buf[25:29], math.Float32bits(float32(p.Command.Vertical)))
+	binary.LittleEndian.PutUint32(buf[29:33], math.Float32bits(float32(p.Command.Forward)))
+	off := outputHeaderLen
+	if p.HasTarget {
+		buf[4] |= outputFlagTarget
+		if p.Target.Valid {
+			buf[4] |= outputFlagValid
+		}
+		binary.LittleEndian.PutUint32(buf[off:], math.Float32bits(float32(p.Target.Confidence)))
+		binary.LittleEndian.PutUint32(buf[off+4:], math.Float32bits(float32(p.Target.CX)))
+		binary.LittleEndian.PutUint32(buf[off+8:], math.Float32bits(float32(p.Target.CY)))
+		binary.LittleEndian.PutUint32(buf[off+12:], math.Float32bits(float32(p.Target.Size)))
+		off += outputTargetLen
+	}
+	if p.HasSample {
+		buf[4] |= outputFlagSample
+		binary.LittleEndian.PutUint32(buf[off:], math.Float32bits(float32(p.SampleOffset)))
+		off += outputSampleLen
+	}
+	binary.LittleEndian.PutUint32(buf[off:], crc32.ChecksumIEEE(buf[:off]))
+	return buf
+}
+
+// DecodeOutputPacket parses a binary command packet of either version.
+// Version 1 packets carry no armed flag and decode with a zero sequence and
+// Armed set.
+func DecodeOutputPacket(data []byte) (OutputPacket, error) {
+	if len(data) >= 3 && data[2] == CommandProtocolVersion {
+		cmd, err := DecodeCommandPacket(data)
+		if err != nil {
+			return OutputPacket{}, err
+		}
+		return OutputPacket{Version: CommandProtocolVersion, Command: cmd, Armed: true}, nil
+	}
+	if len(data) < outputHeaderLen+4 {
+		return OutputPacket{}, errPacketTruncated
+	}
+	if data[0] != commandMagic[0] || data[1] != commandMagic[1] {
+		return OutputPacket{}, fmt.Errorf("bad magic %q", data[0:2])
+	}
+	if data[2] != OutputProtocolVersion {
+		return OutputPacket{}, fmt.Errorf("%w %d", errPacketVersion, data[2])
+	}
+	flags := data[4]
+	n := outputHeaderLen + 4
+	if flags&outputFlagTarget != 0 {
+		n += outputTargetLen
+	}
+	if flags&outputFlagSample != 0 {
+		n += outputSampleLen
+	}
+	if len(data) < n {
+		return OutputPacket{}, errPacketTruncated
+	}
+	if binary.LittleEndian.Uint32(data[n-4:n]) != crc32.ChecksumIEEE(data[:n-4]) {
+		return OutputPacket{}, errPacketCRC
+	}
+	p := OutputPacket{
+		Version:   OutputProtocolVersion,
+		Seq:       binary.LittleEndian.Uint32(data[5:9]),
+		Heartbeat: binary.LittleEndian.Uint32(data[9:13]),
+		Armed:     flags&outputFlagArmed != 0,
+		Command: BodyCommand{
+			T:        math.Float64frombits(binary.LittleEndian.Uint64(data[13:21])),
+			Mode:     Mode(data[3]),
+			Yaw:      float64(math.Float32frombits(binary.LittleEndian.Uint32(data[21:25]))),
+			Vertical: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[25:29]))),
+			Forward:  float64(math.Float32frombits(binary.LittleEndian.Uint32(data[29:33]))),
+		},
+	}
+	off := outputHeaderLen
+	if flags&outputFlagTarget != 0 {
+		p.HasTarget = true
+		p.Target = OutputTarget{
+			Valid:      flags&outputFlagValid != 0,
+			Confidence: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off:]))),
+			CX:         float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off+4:]))),
+			CY:         float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off+8:]))),
+			Size:       float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off+12:]))),
+		}
+		off += outputTargetLen
+	}
+	if flags&outputFlagSample != 0 {
+		p.HasSample = true
+		p.SampleOffset = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off:])))
+	}
+	return p, nil
+}
+
+// FormatOutputCSV formats p as a version 2 CSV record:
+//
+//	v2,seq,heartbeat,t,mode,yaw,vertical,forward,armed[,valid,confidence,cx,cy,size][,sample_offset]
+//
+// mode is the numeric mode code; armed and valid are 0 or 1.
+func FormatOutputCSV(p OutputPacket) string {
+	out := fmt.Sprintf("%s,%d,%d,%.4f,%d,%.4f,%.4f,%.4f,%d",
+		outputCSVPrefix, p.Seq, p.Heartbeat, p.Command.T, int(p.Command.Mode),
+		p.Command.Yaw, p.Command.Vertical, p.Command.Forward, boolDigit(p.Armed))
+	if p.HasTarget {
+		out += fmt.Sprintf(",%d,%.3f,%.4f,%.4f,%.4f",
+			boolDigit(p.Target.Valid), p.Target.Confidence, p.Target.CX, p.Target.CY, p.Target.Size)
+	}
+	if p.HasSample {
+		out += fmt.Sprintf(",%.4f", p.SampleOffset)
+	}
+	return out
+}
+
+// ParseOutputCSV parses a version 2 record or a legacy
+// "yaw,vertical,forward,mode" record, which decodes as version 1.
+func ParseOutputCSV(line string) (OutputPacket, error) {
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if fields[0] != outputCSVPrefix {
+		if len(fields) != 4 {
+			return OutputPacket{}, fmt.Errorf("expected 4 fields, got %d", len(fields))
+		}
+		values, err := parseFloats(fields[:3])
+		if err != nil {
+			return OutputPacket{}, err
+		}
+		mode, err := ParseMode(fields[3])
+		if err != nil {
+			return OutputPacket{}, err
+		}
+		cmd := BodyCommand{Mode: mode, Yaw: values[0], Vertical: values[1], Forward: values[2]}
+		return OutputPacket{Version: CommandProtocolVersion, Command: cmd, Armed: true}, nil
+	}
+
+	// The optional sections are told apart by the field count.
+	n := len(fields)
+	hasSample := n == outputCSVFields+1 || n == outputCSVFields+outputCSVTargetLen+1
+	if hasSample {
+		n--
+	}
+	if n != outputCSVFields && n != outputCSVFields+outputCSVTargetLen {
+		return OutputPacket{}, fmt.Errorf("expected %d to %d fields, got %d",
+			outputCSVFields, outputCSVFields+outputCSVTargetLen+1, len(fields))
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return OutputPacket{}, fmt.Errorf("seq: %w", err)
+	}
+	beat, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return OutputPacket{}, fmt.Errorf("heartbeat: %w", err)
+	}
+	mode, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return OutputPacket{}, fmt.Errorf("mode: %w", err)
+	}
+	values, err := parseFloats(append([]string{fields[3]}, fields[5:8]...))
+	if err != nil {
+		return OutputPacket{}, err
+	}
+	p := OutputPacket{
+		Version:   OutputProtocolVersion,
+		Seq:       uint32(seq),
+		Heartbeat: uint32(beat),
+		Armed:     fields[8] == "1",
+		Command:   BodyCommand{T: values[0], Mode: Mode(mode), Yaw: values[1], Vertical: values[2], Forward: values[3]},
+	}
+	if hasSample {
+		offset, err := strconv.ParseFloat(strings.TrimSpace(fields[n]), 64)
+		if err != nil {
+			return OutputPacket{}, fmt.Errorf("sample_offset: %w", err)
+		}
+		p.HasSample = true
+		p.SampleOffset = offset
+	}
+	if n > outputCSVFields {
+		target, err := parseFloats(fields[outputCSVFields+1 : n])
+		if err != nil {
+			return OutputPacket{}, err
+		}
+		p.HasTarget = true
+		p.Target = OutputTarget{
+			Valid:      fields[outputCSVFields] == "1",
+			Confidence: target[0],
+			CX:         target[1],
+			CY:         target[2],
+			Size:       target[3],
+		}
+	}
+	return p, nil
+}
+
+// parseFloats parses every field as a float64.
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// boolDigit returns 1 for true and 0 for false.
+func boolDigit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// commandEncoder turns an output packet into one payload.
+type commandEncoder interface {
+	Encode(p OutputPacket) []byte
+	// Text reports whether records need newline framing on stream transports.
+	Text() bool
+}
+
+// newCommandEncoder returns the encoder for format (csv, json or binary) and
+// version (1, the default, or 2). target includes the target state in
+// version 2 packets.
+func newCommandEncoder(format string, version int, target bool) (commandEncoder, error) {
+	switch version {
+	case 0, CommandProtocolVersion:
+		if target {
+			return nil, fmt.Errorf("target requires version %d", OutputProtocolVersion)
+		}
+		version = CommandProtocolVersion
+	case OutputProtocolVersion:
+	default:
+		return nil, fmt.Errorf("unknown version %d", version)
+	}
+	switch format {
+	case "", "csv":
+		return csvEncoder{version: version, target: target}, nil
+	case "json":
+		return jsonEncoder{version: version, target: target}, nil
+	case "binary":
+		return binaryEncoder{version: version, target: target}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// csvEncoder writes legacy "yaw,vertical,forward,mode" records or version 2
+// records.
+type csvEncoder struct {
+	version int
+	target  bool
+}
+
+func (e csvEncoder) Encode(p OutputPacket) []byte {
+	if e.version == CommandProtocolVersion {
+		cmd := p.Command
+		return []byte(fmt.Sprintf("%.4f,%.4f,%.4f,%s", cmd.Yaw, cmd.Vertical, cmd.Forward, cmd.Mode.String()))
+	}
+	p.HasTarget = p.HasTarget && e.target
+	return []byte(FormatOutputCSV(p))
+}
+
+func (csvEncoder) Text() bool { return true }
+
+// jsonEncoder writes one JSON object per command.
+type jsonEncoder struct {
+	version int
+	target  bool
+}
+
+type jsonCommand struct {
+	Version  int         `json:"v,omitempty"`
+	Seq      *uint32     `json:"seq,omitempty"`
+	Beat     *uint32     `json:"heartbeat,omitempty"`
+	T        float64     `json:"t"`
+	Mode     string      `json:"mode"`
+	ModeCode *int        `json:"mode_code,omitempty"`
+	Yaw      float64     `json:"yaw"`
+	Vertical float64     `json:"vertical"`
+	Forward  float64     `json:"forward"`
+	Armed    *bool       `json:"armed,omitempty"`
+	Target   *jsonTarget `json:"target,omitempty"`
+	Sample   *float64    `json:"sample_offset,omitempty"`
+}
+
+type jsonTarget struct {
+	Valid      bool    `json:"valid"`
+	Confidence float64 `json:"confidence"`
+	CX         float64 `json:"cx"`
+	CY         float64 `json:"cy"`
+	Size       float64 `json:"size"`
+}
+
+func (e jsonEncoder) Encode(p OutputPacket) []byte {
+	cmd := p.Command
+	out := jsonCommand{
+		T:        cmd.T,
+		Mode:     cmd.Mode.String(),
+		Yaw:      cmd.Yaw,
+		Vertical: cmd.Vertical,
+		Forward:  cmd.Forward,
+	}
+	if e.version == OutputProtocolVersion {
+		code := int(cmd.Mode)
+		out.Version = OutputProtocolVersion
+		out.Seq = &p.Seq
+		out.Beat = &p.Heartbeat
+		out.ModeCode = &code
+		out.Armed = &p.Armed
+		if e.target && p.HasTarget {
+			target := jsonTarget(p.Target)
+			out.Target = &target
+		}
+		if p.HasSample {
+			out.Sample = &p.SampleOffset
+		}
+	}
+	data, _ := json.Marshal(out)
+	return data
+}
+
+func (jsonEncoder) Text() bool { return true }
+
+// binaryEncoder writes binary command packets.
+type binaryEncoder struct {
+	version int
+	target  bool
+}
+
+func (e binaryEncoder) Encode(p OutputPacket) []byte {
+	if e.version == CommandProtocolVersion {
+		return EncodeCommandPacket(p.Command)
+	}
+	p.HasTarget = p.HasTarget && e.target
+	return EncodeOutputPacket(p)
+}
+
+func (binaryEncoder) Text() bool { return false }
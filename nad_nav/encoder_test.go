@@ -0,0 +1,185 @@
+package nad_nav
+
+import (
+	"errors"
+	"testing"
+)
+
+// testOutputPacket returns a v2 packet with values exact in float32 and in
+// the CSV precision.
+func testOutputPacket(target, sample bool) OutputPacket {
+	p := OutputPacket{
+		Version:   OutputProtocolVersion,
+		Seq:       4000000000,
+		Heartbeat: 17,
+		Command:   BodyCommand{T: 12.5, Mode: ModeApproach, Yaw: 0.25, Vertical: -0.5, Forward: 0.125},
+		Armed:     true,
+	}
+	if target {
+		p.HasTarget = true
+		p.Target = OutputTarget{Valid: true, Confidence: 0.75, CX: 0.0625, CY: -0.25, Size: 0.5}
+	}
+	if sample {
+		p.HasSample = true
+		p.SampleOffset = -0.0625
+	}
+	return p
+}
+
+func TestOutputCSVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		p      OutputPacket
+		fields int
+	}{
+		{"base", testOutputPacket(false, false), 9},
+		{"sample", testOutputPacket(false, true), 10},
+		{"target", testOutputPacket(true, false), 14},
+		{"target and sample", testOutputPacket(true, true), 15},
+	}
+	for _, tt := range tests {
+		line := FormatOutputCSV(tt.p)
+		if n := len(splitCSV(line)); n != tt.fields {
+			t.Errorf("%s: %q has %d fields, want %d", tt.name, line, n, tt.fields)
+		}
+		got, err := ParseOutputCSV(line + "\n")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.p {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.p)
+		}
+	}
+
+	disarmed := testOutputPacket(true, false)
+	disarmed.Armed, disarmed.Target.Valid = false, false
+	if got, err := ParseOutputCSV(FormatOutputCSV(disarmed)); err != nil || got != disarmed {
+		t.Errorf("disarmed: got %+v %v", got, err)
+	}
+}
+
+func splitCSV(line string) []string {
+	var fields []string
+	start := 0
+	for i := 0; i <= len(line); i++ {
+		if i == len(line) || line[i] == ',' {
+			fields = append(fields, line[start:i])
+			start = i + 1
+		}
+	}
+	return fields
+}
+
+func TestOutputCSVVersion1(t *testing.T) {
+	enc, err := newCommandEncoder("csv", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testOutputPacket(false, false)
+	line := string(enc.Encode(p))
+	if line != "0.2500,-0.5000,0.1250,APPROACH" {
+		t.Errorf("v1 record %q", line)
+	}
+	got, err := ParseOutputCSV(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OutputPacket{Version: CommandProtocolVersion, Armed: true,
+		Command: BodyCommand{Mode: ModeApproach, Yaw: 0.25, Vertical: -0.5, Forward: 0.125}}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseOutputCSVErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"0.1,0.2,0.3",
+		"0.1,0.2,x,TRACK",
+		"0.1,0.2,0.3,HOVER",
+		"v2,1,1,0.0,2,0.1,0.2,0.3",
+		"v2,1,1,0.0,2,0.1,0.2,0.3,1,1,0.5",
+		"v2,1,1,0.0,2,0.1,0.2,0.3,1,1,0.5,0.1,0.2,0.3,0.4,0.5",
+		"v2,-1,1,0.0,2,0.1,0.2,0.3,1",
+		"v2,1,4294967296,0.0,2,0.1,0.2,0.3,1",
+		"v2,1,1,0.0,TRACK,0.1,0.2,0.3,1",
+		"v2,1,1,now,2,0.1,0.2,0.3,1",
+		"v2,1,1,0.0,2,0.1,0.2,0.3,1,x",
+		"v2,1,1,0.0,2,0.1,0.2,0.3,1,1,0.5,x,0.2,0.3",
+	} {
+		if p, err := ParseOutputCSV(line); err == nil {
+			t.Errorf("%q: parsed as %+v, want an error", line, p)
+		}
+	}
+}
+
+func TestOutputBinaryRoundTrip(t *testing.T) {
+	for _, p := range []OutputPacket{
+		testOutputPacket(false, false),
+		testOutputPacket(false, true),
+		testOutputPacket(true, false),
+		testOutputPacket(true, true),
+	} {
+		b := EncodeOutputPacket(p)
+		want := outputHeaderLen + 4
+		if p.HasTarget {
+			want += outputTargetLen
+		}
+		if p.HasSample {
+			want += outputSampleLen
+		}
+		if len(b) != want {
+			t.Errorf("packet is %d bytes, want %d", len(b), want)
+		}
+		got, err := DecodeOutputPacket(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != p {
+			t.Errorf("\n got %+v\nwant %+v", got, p)
+		}
+	}
+
+	cmd := BodyCommand{T: 3.5, Mode: ModeStop, Yaw: -0.25, Vertical: 0.5, Forward: 0}
+	got, err := DecodeOutputPacket(EncodeCommandPacket(cmd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (OutputPacket{Version: CommandProtocolVersion, Command: cmd, Armed: true}); got != want {
+		t.Errorf("v1: got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeOutputPacketErrors(t *testing.T) {
+	v1 := EncodeCommandPacket(BodyCommand{T: 1, Mode: ModeTrack, Yaw: 0.5})
+	v2 := EncodeOutputPacket(testOutputPacket(true, true))
+	flip := func(b []byte, i int, mask byte) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= mask
+		return b
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"v1 truncated", v1[:commandPacketLen-1], errPacketTruncated},
+		{"v1 corrupt yaw", flip(v1, 13, 0x01), errPacketCRC},
+		{"v1 corrupt crc", flip(v1, commandPacketLen-1, 0xFF), errPacketCRC},
+		{"v2 truncated header", v2[:outputHeaderLen], errPacketTruncated},
+		{"v2 truncated target", v2[:outputHeaderLen+10], errPacketTruncated},
+		{"v2 corrupt seq", flip(v2, 6, 0x80), errPacketCRC},
+		{"v2 corrupt target", flip(v2, outputHeaderLen+5, 0x01), errPacketCRC},
+		// Clearing the sample flag moves where the CRC is read from.
+		{"v2 corrupt flags", flip(v2, 4, outputFlagSample), errPacketCRC},
+		{"unknown version", flip(v2, 2, 0x04), errPacketVersion},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeOutputPacket(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := DecodeOutputPacket(flip(v2, 0, 0xFF)); err == nil {
+		t.Error("bad magic should be rejected")
+	}
+}
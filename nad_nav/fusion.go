@@ -0,0 +1,331 @@
+package nad_nav
+
+import (
+	"fmt"
+	"math"
+)
+
+// InputConfig declares one named camera input with its own transport and optics.
+type InputConfig struct {
+	Name   string       `json:"name"`
+	Live   LiveConfig   `json:"live"`
+	Camera CameraConfig `json:"camera"`
+}
+
+// FusionConfig controls how per-camera states are combined.
+//
+// Mode "pick" (default) makes one camera authoritative; "blend" averages all
+// valid cameras' body-frame bearing, elevation and range weighted by score,
+// and so needs a camera model on every input. A camera's score is its confidence scaled
+// by exp(-age/fresh_seconds). Prefer maps a controller mode name to the camera
+// that should be authoritative in that mode whenever it sees the target.
+type FusionConfig struct {
+	Mode         string            `json:"mode"`
+	FreshSeconds float64           `json:"fresh_seconds"`
+	Hysteresis   float64           `json:"hysteresis"`
+	Prefer       map[string]string `json:"prefer"`
+}
+
+// Fusion selects or blends the per-camera states each tick.
+type Fusion struct {
+	cfg     FusionConfig
+	prefer  map[Mode]string
+	cameras map[string]*CameraModel
+	current string
+}
+
+// NewFusion validates the fusion configuration against the input names and
+// their camera models.
+func NewFusion(cfg FusionConfig, names []string, cameras map[string]*CameraModel) (*Fusion, error) {
+	switch cfg.Mode {
+	case "", "pick":
+	case "blend":
+		// Image coordinates from cameras with different optics or mounts are
+		// in different frames; only body-frame angles can be averaged.
+		if len(names) > 1 {
+			for _, name := range names {
+				if cameras[name] == nil {
+					return nil, fmt.Errorf("input %q: fusion.mode=blend requires camera.hfov_deg", name)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown fusion.mode %q", cfg.Mode)
+	}
+	if cfg.FreshSeconds <= 0 {
+		cfg.FreshSeconds = 0.2
+	}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	prefer := map[Mode]string{}
+	for modeName, camera := range cfg.Prefer {
+		mode, err := ParseMode(modeName)
+		if err != nil {
+			return nil, fmt.Errorf("fusion.prefer: %w", err)
+		}
+		if !known[camera] {
+			return nil, fmt.Errorf("fusion.prefer: unknown input %q", camera)
+		}
+		prefer[mode] = camera
+	}
+	f := &Fusion{cfg: cfg, prefer: prefer, cameras: cameras}
+	if len(names) > 0 {
+		f.current = names[0]
+	}
+	return f, nil
+}
+
+// Select combines the per-camera states for the current controller mode.
+//
+// The returned state's Camera names the authoritative input.
+func (f *Fusion) Select(states []AnchorState, mode Mode) AnchorState {
+	if len(states) == 1 {
+		f.current = states[0].Camera
+		return states[0]
+	}
+
+	if name, ok := f.prefer[mode]; ok {
+		for _, st := range states {
+			if st.Camera == name && st.Valid {
+				f.current = name
+				return f.finish(states, st)
+			}
+		}
+	}
+
+	best := -1
+	bestScore := 0.0
+	currentScore := -1.0
+	for i, st := range states {
+		score := f.score(st)
+		if st.Camera == f.current {
+			currentScore = score
+		}
+		if best < 0 || score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	if bestScore <= 0 {
+		// Nobody sees the target: stay with the camera that saw it last.
+		freshest := 0
+		for i, st := range states {
+			if st.Age < states[freshest].Age {
+				freshest = i
+			}
+		}
+		f.current = states[freshest].Camera
+		return states[freshest]
+	}
+	if currentScore < 0 || bestScore > currentScore+f.cfg.Hysteresis {
+		f.current = states[best].Camera
+	}
+	for _, st := range states {
+		if st.Camera == f.current {
+			return f.finish(states, st)
+		}
+	}
+	return states[best]
+}
+
+// Current returns the name of the authoritative camera.
+func (f *Fusion) Current() string {
+	return f.current
+}
+
+// finish applies blending when configured, keeping the authoritative camera name.
+func (f *Fusion) finish(states []AnchorState, primary AnchorState) AnchorState {
+	if f.cfg.Mode != "blend" {
+		return primary
+	}
+	return f.blend(states, primary)
+}
+
+// score rates how much a camera's state should be trusted right now.
+func (f *Fusion) score(st AnchorState) float64 {
+	if !st.Valid {
+		return 0
+	}
+	return st.Confidence * math.Exp(-st.Age/f.cfg.FreshSeconds)
+}
+
+// blend averages all valid states' body-frame direction, angular rates and
+// range weighted by score.
+//
+// The image-plane fields are then rebuilt in the primary camera's frame, so
+// controllers working in normalized units see the blended direction too. Size
+// follows the blended range when the primary camera can convert it back, and
+// is otherwise the primary camera's own.
+func (f *Fusion) blend(states []AnchorState, primary AnchorState) AnchorState {
+	out := primary
+	var wSum, rangeW float64
+	var dir [3]float64
+	var vbearing, velevation, rng, vrng float64
+	for _, st := range states {
+		w := f.score(st)
+		if w <= 0 {
+			continue
+		}
+		wSum += w
+		// Sum unit vectors rather than angles so bearings either side of
+		// straight back do not cancel out.
+		d := direction(st.Bearing, st.Elevation)
+		for i := range dir {
+			dir[i] += w * d[i]
+		}
+		vbearing += w * st.VBearing
+		velevation += w * st.VElevation
+		if st.HasRange {
+			rangeW += w
+			rng += w * st.Range
+			vrng += w * st.VRange
+		}
+		out.Age = math.Min(out.Age, st.Age)
+		out.Confidence = math.Max(out.Confidence, st.Confidence)
+		out.T = math.Max(out.T, st.T)
+	}
+	if wSum <= 0 {
+		return primary
+	}
+	out.Bearing = math.Atan2(dir[1], dir[0])
+	out.Elevation = math.Atan2(dir[2], math.Hypot(dir[0], dir[1]))
+	out.VBearing, out.VElevation = vbearing/wSum, velevation/wSum
+	if rangeW > 0 {
+		out.Range, out.VRange, out.HasRange = rng/rangeW, vrng/rangeW, true
+	}
+
+	camera := f.cameras[primary.Camera]
+	if camera == nil {
+		return out
+	}
+	const h = 1e-3
+	cx, cy, ok := camera.project(direction(out.Bearing, out.Elevation))
+	cx2, cy2, ok2 := camera.project(direction(out.Bearing+out.VBearing*h, out.Elevation+out.VElevation*h))
+	if ok && ok2 {
+		out.CX, out.CY = cx, cy
+		out.VX, out.VY = (cx2-cx)/h, (cy2-cy)/h
+	}
+	if out.HasRange {
+		if size, ok := camera.size(out.Range); ok {
+			out.Size = size
+			if size2, ok := camera.size(out.Range + out.VRange*h); ok {
+				out.VSize = (size2 - size) / h
+			}
+		}
+	}
+	return out
+}
+
+// direction returns the body-frame unit vector (forward, right, up) for a
+// bearing and elevation.
+func direction(bearing, elevation float64) [3]float64 {
+	sb, cb := math.Sincos(bearing)
+	se, ce := math.Sincos(elevation)
+	return [3]float64{ce * cb, ce * sb, se}
+}
+
+// inputChannel is one named camera input with its own filtering pipeline.
+type inputChannel struct {
+	name    string
+	cfg     LiveConfig
+	source  ObservationSource
+	handle  PacketHandler
+	store   *liveStore
+	auth    *Authenticator
+	camera  *CameraModel
+	derot   *Derotator
+	latency *LatencyEstimator
+	tracker *AnchorTracker
+
+	attitudeDelay float64
+	lastSeq       uint64
+	lastEpoch     uint64
+	lastObs       AnchorObservation
+	lastAttT      float64
+
+	// Set by update: whether a new observation was used, and when it arrived.
+	fresh bool
+	recvT float64
+}
+
+// update feeds pending observations to the tracker and returns the filtered,
+// de-rotated, predicted and annotated state at controller time simT.
+func (in *inputChannel) update(simT, confMin float64) AnchorState {
+	in.fresh = false
+	var st AnchorState
+	for _, sample := range in.pending() {
+		obs, ok := in.prepare(sample, simT)
+		if !ok {
+			continue
+		}
+		in.fresh = true
+		in.recvT = sample.RecvT
+		in.lastObs = obs
+		st = in.tracker.Update(obs, confMin)
+	}
+	if !in.fresh {
+		in.lastObs = AnchorObservation{T: simT, Detected: false, Confidence: 0}
+		st = in.tracker.Update(in.lastObs, confMin)
+	}
+
+	st = in.derot.State(st, in.lastAttT)
+	st = in.tracker.Predict(st, simT, in.latency.MaxPredict())
+	st = in.camera.Annotate(st)
+	st.Camera = in.name
+	return st
+}
+
+// pending returns the observations to feed the tracker this step.
+//
+// In latest mode that is the newest unseen observation; in queue mode every
+// observation received since the previous step, in order.
+func (in *inputChannel) pending() []liveSample {
+	if in.store.queued {
+		return in.store.Drain()
+	}
+	sample, seq := in.store.Snapshot()
+	if seq == in.lastSeq {
+		return nil
+	}
+	in.lastSeq = seq
+	return []liveSample{sample}
+}
+
+// prepare converts a received sample into a de-rotated observation on the
+// controller clock. It returns false when the sample is too old to use.
+func (in *inputChannel) prepare(sample liveSample, simT float64) (AnchorObservation, bool) {
+	if sample.Epoch != in.lastEpoch {
+		in.lastEpoch = sample.Epoch
+		in.tracker.Reset()
+		in.latency.Reset()
+	}
+	obs := sample.Obs
+	captureT := sample.RecvT
+	attT := sample.RecvT - in.attitudeDelay
+	switch {
+	case !sample.HasT:
+		obs.T = sample.RecvT
+	case in.latency != nil:
+		in.latency.Observe(obs.T, sample.RecvT)
+		obs.T = in.latency.ToLocal(obs.T)
+		captureT = obs.T
+		attT = obs.T
+	}
+	if in.cfg.StaleSeconds > 0 && simT-captureT > in.cfg.StaleSeconds {
+		in.store.CountStale()
+		return AnchorObservation{}, false
+	}
+	in.lastAttT = attT
+	return in.derot.Observation(obs, attT), true
+}
+
+// inputConfigs returns the configured inputs, or a single "main" input built
+// from the top-level live and camera sections.
+func inputConfigs(cfg AppConfig) []InputConfig {
+	if len(cfg.Inputs) > 0 {
+		return cfg.Inputs
+	}
+	return []InputConfig{{Name: "main", Live: cfg.Live, Camera: cfg.Camera}}
+}
@@ -0,0 +1,72 @@
+package nad_nav
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFusionBlendDifferentMounts(t *testing.T) {
+	front, err := NewCameraModel(CameraConfig{HFOVDeg: 90, TargetDiameterM: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := NewCameraModel(CameraConfig{HFOVDeg: 60, MountYawDeg: 90, TargetDiameterM: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cameras := map[string]*CameraModel{"front": front, "right": right}
+	f, err := NewFusion(FusionConfig{Mode: "blend"}, []string{"front", "right"}, cameras)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both cameras see a target 45 degrees right and 10 m away, at opposite
+	// edges of their images.
+	target := direction(deg2rad(45), 0)
+	state := func(name string) AnchorState {
+		c := cameras[name]
+		cx, cy, ok := c.project(target)
+		if !ok {
+			t.Fatalf("%s camera cannot see the target", name)
+		}
+		size, _ := c.size(10)
+		return c.Annotate(AnchorState{Valid: true, Confidence: 0.9, CX: cx, CY: cy, Size: size, Camera: name})
+	}
+	fs, rs := state("front"), state("right")
+	if fs.CX <= 0 || rs.CX >= 0 {
+		t.Fatalf("test setup: front cx %v right cx %v", fs.CX, rs.CX)
+	}
+
+	st := f.Select([]AnchorState{fs, rs}, ModeTrack)
+	if st.Camera != "front" {
+		t.Fatalf("authoritative camera %q, want front", st.Camera)
+	}
+	if math.Abs(rad2deg(st.Bearing)-45) > 1e-6 || math.Abs(st.Elevation) > 1e-6 {
+		t.Errorf("bearing %v elevation %v, want 45 0", rad2deg(st.Bearing), rad2deg(st.Elevation))
+	}
+	if !st.HasRange || math.Abs(st.Range-10) > 1e-6 {
+		t.Errorf("range %v %v, want 10", st.HasRange, st.Range)
+	}
+	// The image-plane fields stay in the primary camera's frame.
+	if math.Abs(st.CX-fs.CX) > 1e-6 || math.Abs(st.CY-fs.CY) > 1e-6 || math.Abs(st.Size-fs.Size) > 1e-6 {
+		t.Errorf("cx %v cy %v size %v, want the front camera's %v %v %v", st.CX, st.CY, st.Size, fs.CX, fs.CY, fs.Size)
+	}
+}
+
+func TestFusionBlendRequiresCameraModels(t *testing.T) {
+	front, err := NewCameraModel(CameraConfig{HFOVDeg: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"front", "down"}
+	cameras := map[string]*CameraModel{"front": front, "down": nil}
+	if _, err := NewFusion(FusionConfig{Mode: "blend"}, names, cameras); err == nil {
+		t.Error("blend without a camera model on every input should be rejected")
+	}
+	if _, err := NewFusion(FusionConfig{Mode: "pick"}, names, cameras); err != nil {
+		t.Errorf("pick: %v", err)
+	}
+	if _, err := NewFusion(FusionConfig{Mode: "blend"}, []string{"main"}, map[string]*CameraModel{"main": nil}); err != nil {
+		t.Errorf("blend with a single input: %v", err)
+	}
+}
@@ -19,6 +19,7 @@ type AnchorObservation struct {
 // AnchorState is the filtered observation used by the controller.
 //
 // It adds smoothed values, velocities, and target age for dropout handling.
+// Angular and metric fields are filled only when a camera model is configured.
 type AnchorState struct {
 	T          float64
 	Valid      bool
@@ -30,6 +31,16 @@ type AnchorState struct {
 	Size       float64
 	VSize      float64
 	Age        float64
+
+	Bearing    float64 // rad, positive right
+	Elevation  float64 // rad, positive up
+	VBearing   float64 // rad/s
+	VElevation float64 // rad/s
+	Range      float64 // m
+	VRange     float64 // m/s
+	HasRange   bool
+
+	Camera string // input that produced the state, or the authoritative one after fusion
 }
 
 // Mode selects which controller policy produces outputs.
@@ -0,0 +1,135 @@
+package nad_nav
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// jsonObservation is the JSON form of an observation.
+//
+// Either the top-level cx/cy/size fields or a detections array may be used.
+type jsonObservation struct {
+	T          *float64        `json:"t"`
+	Detected   *looseBool      `json:"detected"`
+	Frame      *uint32         `json:"frame"`
+	Detections []jsonDetection `json:"detections"`
+	jsonDetection
+}
+
+// jsonDetection is one detector candidate in a JSON observation.
+type jsonDetection struct {
+	Confidence *float64 `json:"confidence"`
+	CX         *float64 `json:"cx"`
+	CY         *float64 `json:"cy"`
+	Size       *float64 `json:"size"`
+	Width      *float64 `json:"bbox_w"`
+	Height     *float64 `json:"bbox_h"`
+	Class      string   `json:"class"`
+}
+
+// knownJSONFields lists every field accepted at the top level of a JSON observation.
+var knownJSONFields = map[string]bool{
+	"t": true, "detected": true, "frame": true, "detections": true,
+	"confidence": true, "cx": true, "cy": true, "size": true,
+	"bbox_w": true, "bbox_h": true, "class": true,
+}
+
+// reportedJSONFields remembers unknown fields that were already logged.
+var reportedJSONFields sync.Map
+
+// parseJSONObservation parses one JSON object into a live sample.
+func parseJSONObservation(b []byte) (liveSample, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return liveSample{}, err
+	}
+	for name := range fields {
+		if knownJSONFields[name] {
+			continue
+		}
+		if _, seen := reportedJSONFields.LoadOrStore(name, true); !seen {
+			log.Printf("live input: ignoring unknown JSON field %q", name)
+		}
+	}
+
+	var raw jsonObservation
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return liveSample{}, err
+	}
+
+	var sample liveSample
+	if raw.T != nil {
+		sample.Obs.T = *raw.T
+		sample.HasT = true
+	}
+	if raw.Frame != nil {
+		sample.Seq = *raw.Frame
+		sample.HasSeq = true
+	}
+
+	detections := raw.Detections
+	if len(detections) == 0 && (raw.CX != nil || raw.CY != nil) {
+		detections = []jsonDetection{raw.jsonDetection}
+	}
+	if raw.Detected != nil && !bool(*raw.Detected) {
+		detections = nil
+	}
+
+	packet := ObservationPacket{T: sample.Obs.T}
+	for i, d := range detections {
+		det, err := d.detection()
+		if err != nil {
+			return liveSample{}, fmt.Errorf("detection %d: %w", i, err)
+		}
+		packet.Detections = append(packet.Detections, det)
+	}
+	sample.Obs = packet.Observation()
+	return sample, nil
+}
+
+// detection converts a JSON detection, deriving size from the bbox when absent.
+func (d jsonDetection) detection() (Detection, error) {
+	if d.CX == nil || d.CY == nil {
+		return Detection{}, fmt.Errorf("missing cx/cy")
+	}
+	det := Detection{CX: *d.CX, CY: *d.CY, Class: d.Class}
+	if d.Confidence != nil {
+		det.Confidence = *d.Confidence
+	}
+	if d.Width != nil {
+		det.Width = *d.Width
+	}
+	if d.Height != nil {
+		det.Height = *d.Height
+	}
+	switch {
+	case d.Size != nil:
+		det.Size = *d.Size
+	case d.Width != nil && d.Height != nil:
+		det.Size = det.Width * det.Height
+	}
+	return det, nil
+}
+
+// looseBool accepts JSON booleans, numbers and the string encodings of parseBoolLoose.
+type looseBool bool
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	s := string(bytes.Trim(bytes.TrimSpace(data), `"`))
+	v, err := parseBoolLoose(s)
+	if err != nil {
+		return fmt.Errorf("invalid detected value %s", data)
+	}
+	*b = looseBool(v)
+	return nil
+}
+
+// isJSONPayload reports whether a text payload looks like a JSON object.
+func isJSONPayload(b []byte) bool {
+	trimmed := bytes.TrimLeft(b, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
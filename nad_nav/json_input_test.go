@@ -0,0 +1,129 @@
+package nad_nav
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONObservation(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want liveSample
+	}{
+		{
+			name: "top-level fields",
+			in:   `{"t": 12.5, "detected": true, "confidence": 0.8, "cx": 0.25, "cy": -0.5, "size": 0.04}`,
+			want: liveSample{Obs: AnchorObservation{T: 12.5, Detected: true, Confidence: 0.8, CX: 0.25, CY: -0.5, Size: 0.04}, HasT: true},
+		},
+		{
+			name: "no timestamp",
+			in:   `{"confidence": 0.8, "cx": 0.25, "cy": -0.5, "size": 0.04}`,
+			want: liveSample{Obs: AnchorObservation{Detected: true, Confidence: 0.8, CX: 0.25, CY: -0.5, Size: 0.04}},
+		},
+		{
+			name: "frame number",
+			in:   `{"t": 1, "frame": 7, "cx": 0, "cy": 0}`,
+			want: liveSample{Obs: AnchorObservation{T: 1, Detected: true}, HasT: true, Seq: 7, HasSeq: true},
+		},
+		{
+			name: "size from bbox",
+			in:   `{"cx": 0.1, "cy": 0.2, "bbox_w": 0.5, "bbox_h": 0.25, "confidence": 1}`,
+			want: liveSample{Obs: AnchorObservation{Detected: true, Confidence: 1, CX: 0.1, CY: 0.2, Size: 0.125}},
+		},
+		{
+			name: "size wins over bbox",
+			in:   `{"cx": 0.1, "cy": 0.2, "bbox_w": 0.5, "bbox_h": 0.25, "size": 0.01}`,
+			want: liveSample{Obs: AnchorObservation{Detected: true, CX: 0.1, CY: 0.2, Size: 0.01}},
+		},
+		{
+			name: "best of detections",
+			in:   `{"t": 3, "detections": [{"confidence": 0.4, "cx": 0.1, "cy": 0.1, "size": 0.01}, {"confidence": 0.9, "cx": -0.3, "cy": 0.2, "size": 0.02, "class": "gate"}]}`,
+			want: liveSample{Obs: AnchorObservation{T: 3, Detected: true, Confidence: 0.9, CX: -0.3, CY: 0.2, Size: 0.02}, HasT: true},
+		},
+		{
+			name: "detected false",
+			in:   `{"t": 4, "detected": false, "confidence": 0.9, "cx": 0.1, "cy": 0.1}`,
+			want: liveSample{Obs: AnchorObservation{T: 4}, HasT: true},
+		},
+		{
+			name: "detected as string",
+			in:   `{"detected": "no", "cx": 0.1, "cy": 0.1}`,
+			want: liveSample{},
+		},
+		{
+			name: "detected as number",
+			in:   `{"detected": 1, "cx": 0.1, "cy": 0.1}`,
+			want: liveSample{Obs: AnchorObservation{Detected: true, CX: 0.1, CY: 0.1}},
+		},
+		{
+			name: "no detections",
+			in:   `{"t": 5, "detections": []}`,
+			want: liveSample{Obs: AnchorObservation{T: 5}, HasT: true},
+		},
+		{
+			name: "unknown fields ignored",
+			in:   `{"t": 6, "cx": 0, "cy": 0, "tracker_id": 3}`,
+			want: liveSample{Obs: AnchorObservation{T: 6, Detected: true}, HasT: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSONObservation([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONObservationErrors(t *testing.T) {
+	for _, in := range []string{
+		`{"t": 1, "cx": 0.1`,
+		`{"cx": 0.1}`,
+		`{"detections": [{"cx": 0.1, "cy": 0.1}, {"cy": 0.2}]}`,
+		`{"detected": "maybe", "cx": 0, "cy": 0}`,
+		`{"t": "soon", "cx": 0, "cy": 0}`,
+		`[1, 2]`,
+	} {
+		if got, err := parseJSONObservation([]byte(in)); err == nil {
+			t.Errorf("%s: parsed as %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestJSONLinesNextToCSV(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"t": 1, "cx": 0.1, "cy": 0.2, "size": 0.03, "confidence": 0.9}`,
+		`1.5,1,0.8,0.2,0.3,0.04`,
+		`  {"t": 2, "detected": false}`,
+	}, "\n") + "\n"
+	want := []liveSample{
+		{Obs: AnchorObservation{T: 1, Detected: true, Confidence: 0.9, CX: 0.1, CY: 0.2, Size: 0.03}, HasT: true},
+		{Obs: AnchorObservation{T: 1.5, Detected: true, Confidence: 0.8, CX: 0.2, CY: 0.3, Size: 0.04}, HasT: true},
+		{Obs: AnchorObservation{T: 2}, HasT: true},
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(stream))
+	scanner.Split(splitPackets)
+	var got []liveSample
+	for scanner.Scan() {
+		sample, err := decodeLivePayload(scanner.Bytes())
+		if err != nil {
+			t.Fatalf("%q: %v", scanner.Text(), err)
+		}
+		got = append(got, sample)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d: %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
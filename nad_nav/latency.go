@@ -0,0 +1,147 @@
+package nad_nav
+
+import (
+	"math"
+	"time"
+)
+
+// LatencyConfig controls online estimation of camera clock offset and pipeline latency.
+type LatencyConfig struct {
+	Enabled bool `json:"enabled"`
+	// CameraEpoch declares that the camera t field is Unix time on a synchronized
+	// clock, so the offset is known and latency is measured directly.
+	CameraEpoch bool `json:"camera_epoch"`
+	// MinLatency is the assumed fastest capture-to-receive time, used to anchor the
+	// offset when the camera clock is unrelated to ours.
+	MinLatency float64 `json:"min_latency"`
+	Window     int     `json:"window"`
+	Smoothing  float64 `json:"smoothing"`
+	MaxPredict float64 `json:"max_predict"`
+}
+
+// LatencyEstimator tracks the camera-to-controller clock offset and the latency of
+// each observation from capture to receive.
+//
+// For unrelated clocks every sample satisfies recv - cam = offset + latency, so
+// the offset is taken as the windowed minimum of that difference minus MinLatency.
+type LatencyEstimator struct {
+	cfg       LatencyConfig
+	t0Unix    float64
+	delays    []float64
+	next      int
+	count     int
+	offset    float64
+	latency   float64
+	lastRaw   float64
+	hasSample bool
+}
+
+// NewLatencyEstimator creates an estimator; t0 is the controller time origin.
+//
+// It returns nil when latency compensation is disabled.
+func NewLatencyEstimator(cfg LatencyConfig, t0 time.Time) *LatencyEstimator {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 150
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.1
+	}
+	if cfg.MaxPredict <= 0 {
+		cfg.MaxPredict = 0.25
+	}
+	return &LatencyEstimator{
+		cfg:    cfg,
+		t0Unix: float64(t0.UnixNano()) / 1e9,
+		delays: make([]float64, cfg.Window),
+	}
+}
+
+// Observe records one packet with camera time camT received at controller time recvT.
+func (e *LatencyEstimator) Observe(camT, recvT float64) {
+	if e == nil {
+		return
+	}
+	delay := recvT - camT
+
+	if e.cfg.CameraEpoch {
+		e.offset = -e.t0Unix
+	} else {
+		e.delays[e.next] = delay
+		e.next = (e.next + 1) % len(e.delays)
+		if e.count < len(e.delays) {
+			e.count++
+		}
+		minDelay := math.Inf(1)
+		for i := 0; i < e.count; i++ {
+			minDelay = math.Min(minDelay, e.delays[i])
+		}
+		e.offset = minDelay - e.cfg.MinLatency
+	}
+
+	raw := math.Max(0, delay-e.offset)
+	e.lastRaw = raw
+	if !e.hasSample {
+		e.latency = raw
+		e.hasSample = true
+		return
+	}
+	e.latency += e.cfg.Smoothing * (raw - e.latency)
+}
+
+// Reset forgets the offset history, e.g. after the camera clock restarted.
+func (e *LatencyEstimator) Reset() {
+	if e == nil {
+		return
+	}
+	e.next = 0
+	e.count = 0
+	e.hasSample = false
+}
+
+// ToLocal converts a camera timestamp into controller time.
+func (e *LatencyEstimator) ToLocal(camT float64) float64 {
+	if e == nil {
+		return camT
+	}
+	return camT + e.offset
+}
+
+// Latency returns the smoothed capture-to-receive latency in seconds.
+func (e *LatencyEstimator) Latency() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.latency
+}
+
+// LastLatency returns the latency of the most recent packet in seconds.
+func (e *LatencyEstimator) LastLatency() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.lastRaw
+}
+
+// Offset returns the camera-to-controller clock offset in seconds.
+func (e *LatencyEstimator) Offset() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.offset
+}
+
+// Ready reports whether at least one timestamped packet has been seen.
+func (e *LatencyEstimator) Ready() bool {
+	return e != nil && e.hasSample
+}
+
+// MaxPredict returns the forward-prediction horizon limit in seconds.
+func (e *LatencyEstimator) MaxPredict() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.cfg.MaxPredict
+}
@@ -0,0 +1,210 @@
+package nad_nav
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LinkStatsSnapshot summarizes input link quality.
+type LinkStatsSnapshot struct {
+	Packets     uint64
+	Rate        float64 // packets per second over the last second
+	JitterMs    float64
+	Lost        uint64
+	LossRatio   float64
+	ReadErrors  uint64
+	ParseErrors map[string]uint64
+	LastError   string
+	LastErrorT  float64
+}
+
+// String formats the snapshot for the console log.
+func (s LinkStatsSnapshot) String() string {
+	var errs []string
+	for reason, n := range s.ParseErrors {
+		errs = append(errs, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(errs)
+	out := fmt.Sprintf(
+		"link rate=%.1f/s jitter=%.1fms lost=%d (%.1f%%) read_err=%d parse_err={%s}",
+		s.Rate, s.JitterMs, s.Lost, 100*s.LossRatio, s.ReadErrors, strings.Join(errs, " "),
+	)
+	if s.LastError != "" {
+		out += fmt.Sprintf(" last_err=%q", s.LastError)
+	}
+	return out
+}
+
+// LinkStats maintains rolling statistics for one input link.
+type LinkStats struct {
+	mu sync.Mutex
+
+	packets  uint64
+	arrivals []float64 // receive times within the last second
+
+	hasLast   bool
+	lastRecvT float64
+	lastT     float64
+	lastHasT  bool
+	meanIA    float64
+	jitter    float64
+
+	hasSeq  bool
+	highSeq uint32
+	lost    uint64
+	seqSeen uint64
+
+	readErrors  uint64
+	parseErrors map[string]uint64
+	lastError   string
+	lastErrorT  float64
+}
+
+// NewLinkStats creates empty link statistics.
+func NewLinkStats() *LinkStats {
+	return &LinkStats{parseErrors: map[string]uint64{}}
+}
+
+// RecordPacket records a successfully decoded packet.
+func (s *LinkStats) RecordPacket(sample liveSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := sample.RecvT
+	s.packets++
+	s.arrivals = append(s.arrivals, now)
+	s.trim(now)
+
+	// RFC 3550 style interarrival jitter: transit variation when the camera
+	// timestamps packets, otherwise variation of the arrival interval.
+	if s.hasLast {
+		ia := now - s.lastRecvT
+		var d float64
+		if sample.HasT && s.lastHasT {
+			d = ia - (sample.Obs.T - s.lastT)
+		} else {
+			if s.meanIA == 0 {
+				s.meanIA = ia
+			}
+			d = ia - s.meanIA
+			s.meanIA += (ia - s.meanIA) / 16
+		}
+		s.jitter += (math.Abs(d) - s.jitter) / 16
+	}
+	s.hasLast = true
+	s.lastRecvT = now
+	s.lastT = sample.Obs.T
+	s.lastHasT = sample.HasT
+
+	if sample.HasSeq {
+		s.recordSeq(sample.Seq)
+	}
+}
+
+// recordSeq counts sequence gaps as loss; late arrivals give a lost packet back.
+func (s *LinkStats) recordSeq(seq uint32) {
+	s.seqSeen++
+	if !s.hasSeq {
+		s.hasSeq = true
+		s.highSeq = seq
+		return
+	}
+	d := seqDelta(seq, s.highSeq)
+	switch {
+	case d > 0 && d <= seqResetWindow:
+		s.lost += uint64(d - 1)
+		s.highSeq = seq
+	case d > seqResetWindow || seqRestart(seq, s.highSeq):
+		// Camera restarted or jumped; start counting afresh.
+		s.highSeq = seq
+	case d < 0 && s.lost > 0:
+		s.lost--
+	}
+}
+
+// RecordReadError records a transport-level failure.
+func (s *LinkStats) RecordReadError(err error, now float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readErrors++
+	s.lastError = err.Error()
+	s.lastErrorT = now
+}
+
+// RecordParseError records a payload that could not be decoded.
+func (s *LinkStats) RecordParseError(err error, now float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parseErrors[parseErrorReason(err)]++
+	s.lastError = err.Error()
+	s.lastErrorT = now
+}
+
+// Snapshot returns the current statistics as of controller time now.
+func (s *LinkStats) Snapshot(now float64) LinkStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trim(now)
+
+	snap := LinkStatsSnapshot{
+		Packets:     s.packets,
+		Rate:        float64(len(s.arrivals)),
+		JitterMs:    s.jitter * 1000,
+		Lost:        s.lost,
+		ReadErrors:  s.readErrors,
+		ParseErrors: make(map[string]uint64, len(s.parseErrors)),
+		LastError:   s.lastError,
+		LastErrorT:  s.lastErrorT,
+	}
+	if expected := s.seqSeen + s.lost; expected > 0 {
+		snap.LossRatio = float64(s.lost) / float64(expected)
+	}
+	for k, v := range s.parseErrors {
+		snap.ParseErrors[k] = v
+	}
+	return snap
+}
+
+// trim drops arrivals older than one second. Callers must hold the lock.
+func (s *LinkStats) trim(now float64) {
+	i := 0
+	for i < len(s.arrivals) && now-s.arrivals[i] > 1.0 {
+		i++
+	}
+	if i > 0 {
+		s.arrivals = append(s.arrivals[:0], s.arrivals[i:]...)
+	}
+}
+
+// parseErrorReason buckets decode errors into short, stable reason names.
+func parseErrorReason(err error) string {
+	var numErr *strconv.NumError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errEmptyPayload):
+		return "empty"
+	case errors.Is(err, errFieldCount):
+		return "field_count"
+	case errors.Is(err, errPacketCRC):
+		return "crc"
+	case errors.Is(err, errPacketTruncated):
+		return "truncated"
+	case errors.Is(err, errPacketVersion):
+		return "version"
+	case errors.Is(err, errPacketValue):
+		return "value"
+	case errors.As(err, &numErr):
+		return "number"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return "json"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,140 @@
+package nad_nav
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLinkStatsRate(t *testing.T) {
+	s := NewLinkStats()
+	for i := 0; i < 50; i++ {
+		recv := float64(i) / 20
+		s.RecordPacket(liveSample{Obs: AnchorObservation{T: recv}, HasT: true, RecvT: recv})
+	}
+	snap := s.Snapshot(49.0 / 20)
+	if snap.Packets != 50 {
+		t.Errorf("packets %d, want 50", snap.Packets)
+	}
+	if snap.Rate < 20 || snap.Rate > 21 {
+		t.Errorf("rate %v, want about 20/s", snap.Rate)
+	}
+	if snap = s.Snapshot(10); snap.Rate != 0 || snap.Packets != 50 {
+		t.Errorf("after the link went quiet: rate %v packets %d, want 0 and 50", snap.Rate, snap.Packets)
+	}
+}
+
+func TestLinkStatsJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasT   bool
+		delay  func(i int) float64 // extra receive delay of packet i
+		wantMs float64
+	}{
+		{name: "steady with camera time", hasT: true, delay: func(int) float64 { return 0.05 }, wantMs: 0},
+		{name: "steady without camera time", delay: func(int) float64 { return 0.05 }, wantMs: 0},
+		// Every transit time differs from the previous one by 10 ms.
+		{name: "alternating delay", hasT: true, delay: func(i int) float64 { return 0.01 * float64(i%2) }, wantMs: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLinkStats()
+			for i := 0; i < 300; i++ {
+				capture := float64(i) / 30
+				s.RecordPacket(liveSample{Obs: AnchorObservation{T: capture}, HasT: tt.hasT, RecvT: capture + tt.delay(i)})
+			}
+			if got := s.Snapshot(10).JitterMs; math.Abs(got-tt.wantMs) > 0.1 {
+				t.Errorf("jitter %vms, want %vms", got, tt.wantMs)
+			}
+		})
+	}
+}
+
+func TestLinkStatsLoss(t *testing.T) {
+	tests := []struct {
+		name      string
+		seqs      []uint32
+		wantLost  uint64
+		wantRatio float64
+	}{
+		{name: "in order", seqs: []uint32{1, 2, 3, 4}, wantLost: 0, wantRatio: 0},
+		{name: "gaps", seqs: []uint32{1, 2, 3, 5, 6, 10}, wantLost: 4, wantRatio: 0.4},
+		{name: "late arrival", seqs: []uint32{1, 2, 3, 5, 6, 10, 4}, wantLost: 3, wantRatio: 0.3},
+		{name: "wraparound", seqs: []uint32{math.MaxUint32 - 1, math.MaxUint32, 1}, wantLost: 1, wantRatio: 0.25},
+		{name: "jump", seqs: []uint32{1, 2, 1000, 1001}, wantLost: 0, wantRatio: 0},
+		{name: "camera restart", seqs: []uint32{500, 501, 0, 1}, wantLost: 0, wantRatio: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLinkStats()
+			for i, seq := range tt.seqs {
+				s.RecordPacket(liveSample{Seq: seq, HasSeq: true, RecvT: float64(i) * 0.1})
+			}
+			snap := s.Snapshot(1)
+			if snap.Lost != tt.wantLost || math.Abs(snap.LossRatio-tt.wantRatio) > 1e-9 {
+				t.Errorf("lost %d ratio %v, want %d %v", snap.Lost, snap.LossRatio, tt.wantLost, tt.wantRatio)
+			}
+		})
+	}
+}
+
+func TestParseErrorReason(t *testing.T) {
+	_, _, emptyErr := parseLiveObservation([]byte(" "))
+	_, _, countErr := parseLiveObservation([]byte("1,2"))
+	_, _, numberErr := parseLiveObservation([]byte("1,0.9,x,0,0.1"))
+	_, jsonErr := parseJSONObservation([]byte(`{"cx": `))
+	_, typeErr := parseJSONObservation([]byte(`{"cx": "left", "cy": 0}`))
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{emptyErr, "empty"},
+		{countErr, "field_count"},
+		{numberErr, "number"},
+		{jsonErr, "json"},
+		{typeErr, "json"},
+		{fmt.Errorf("decode: %w", errPacketCRC), "crc"},
+		{errPacketTruncated, "truncated"},
+		{errPacketVersion, "version"},
+		{errPacketValue, "value"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("want %s: test input parsed without error", tt.want)
+			continue
+		}
+		if got := parseErrorReason(tt.err); got != tt.want {
+			t.Errorf("parseErrorReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestLinkStatsErrors(t *testing.T) {
+	s := NewLinkStats()
+	s.RecordParseError(errPacketCRC, 1)
+	s.RecordParseError(fmt.Errorf("wrapped: %w", errPacketCRC), 2)
+	s.RecordParseError(errEmptyPayload, 3)
+	s.RecordReadError(errors.New("connection reset"), 4)
+
+	snap := s.Snapshot(5)
+	if snap.ParseErrors["crc"] != 2 || snap.ParseErrors["empty"] != 1 || len(snap.ParseErrors) != 2 {
+		t.Errorf("parse errors %v, want crc=2 empty=1", snap.ParseErrors)
+	}
+	if snap.ReadErrors != 1 || snap.LastError != "connection reset" || snap.LastErrorT != 4 {
+		t.Errorf("read errors %d last %q at %v", snap.ReadErrors, snap.LastError, snap.LastErrorT)
+	}
+	line := snap.String()
+	for _, want := range []string{"read_err=1", "parse_err={crc=2 empty=1}", `last_err="connection reset"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("%q does not contain %q", line, want)
+		}
+	}
+	// The snapshot owns its map.
+	snap.ParseErrors["crc"] = 100
+	if s.Snapshot(5).ParseErrors["crc"] != 2 {
+		t.Error("snapshot map aliases the live counters")
+	}
+}
@@ -1,13 +1,13 @@
 package nad_nav
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 // LiveRunConfig wraps configuration for the live controller.
@@ -15,164 +15,216 @@ type LiveRunConfig struct {
 	App AppConfig
 }
 
-// RunLive starts the UDP-to-UDP control loop.
-func RunLive(cfg AppConfig) error {
-	if cfg.Hz <= 0 {
-		return fmt.Errorf("hz must be > 0")
+// RunLive runs the observation-to-UDP control loop until ctx is cancelled or
+// the process receives SIGINT/SIGTERM.
+//
+// On shutdown it sends the safe command burst, closes the listeners and the
+// viz server, flushes the console log and returns a summary of the run.
+func RunLive(ctx context.Context, cfg AppConfig) (RunSummary, error) {
+	ctx, stop := withShutdownSignals(ctx)
+	defer stop()
+
+	runner, err := NewRunner(cfg)
+	if err != nil {
+		return RunSummary{}, err
 	}
-	if cfg.Live.UDPAddr == "" {
-		return fmt.Errorf("live.udp_addr must be set")
+	if err := runner.Start(ctx); err != nil {
+		runner.Stop()
+		return RunSummary{}, err
 	}
+	<-runner.Done()
+	return runner.Stop(), nil
+}
 
-	store := &liveStore{}
-	if err := startUDPListener(cfg.Live, store); err != nil {
-		return err
-	}
+// liveSample is one received observation with its receive metadata.
+//
+// Seq is the camera frame sequence when the payload format carries one. Epoch
+// advances whenever the camera clock is detected to have reset.
+type liveSample struct {
+	Obs    AnchorObservation
+	HasT   bool
+	Seq    uint32
+	HasSeq bool
+	RecvT  float64
+	Epoch  uint64
+}
 
-	tracker := NewAnchorTracker(cfg.Tracker)
-	controller := NewDroneController(cfg.Controller)
-	sender, err := NewOutputSender(cfg.Output.UDPAddr)
-	if err != nil {
-		return err
-	}
-	viz, err := StartViz(cfg.Viz)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = sender.Close()
-	}()
-
-	dtTarget := 1.0 / cfg.Hz
-	t0 := time.Now()
-	lastWall := time.Now()
-	var lastSeq uint64
-	var lastObs AnchorObservation
-
-	for {
-		now := time.Now()
-		simT := now.Sub(t0).Seconds()
-
-		obs, hasT, seq := store.Snapshot()
-		if seq != lastSeq {
-			lastSeq = seq
-			lastObs = obs
-			if !hasT {
-				lastObs.T = simT
-			}
-		} else {
-			lastObs = AnchorObservation{T: simT, Detected: false, Confidence: 0}
-		}
-		if viz != nil {
-			viz.UpdateInput(lastObs)
-		}
+type liveStore struct {
+	mu     sync.RWMutex
+	last   liveSample
+	seq    uint64
+	epoch  uint64
+	guard  timelineGuard
+	stats  *LinkStats
+	notify chan struct{}
 
-		st := tracker.Update(lastObs, controller.Cfg.ConfMin)
+	// Queue ingestion keeps every accepted sample until the loop drains it.
+	queued     bool
+	queue      []liveSample
+	queueSize  int
+	dropNewest bool
+	queueStats QueueStats
+}
 
-		dtReal := mathMax(1e-3, now.Sub(lastWall).Seconds())
-		lastWall = now
+// QueueStats describes the ingestion queue used by live.ingest=queue.
+type QueueStats struct {
+	Depth     int
+	HighWater int
+	Dropped   uint64
+}
 
-		cmd := controller.Step(st, dtReal)
-		sender.Send(cmd)
-		if viz != nil {
-			viz.UpdateOutput(cmd)
-		}
+// newLiveStore creates a store that drops packets violating the input timeline.
+//
+// notify is signalled after each accepted observation; several stores may
+// share one channel so the loop wakes on any input.
+func newLiveStore(cfg LiveConfig, notify chan struct{}) *liveStore {
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 64
+	}
+	return &liveStore{
+		guard:      newTimelineGuard(cfg.ClockResetSeconds),
+		stats:      NewLinkStats(),
+		notify:     notify,
+		queued:     cfg.Ingest == "queue",
+		queueSize:  queueSize,
+		dropNewest: cfg.Overflow == "drop_newest",
+	}
+}
 
-		if cfg.Log.Enabled {
-			fmt.Printf(
-				"%8.3f mode=%-14s obs(cx=%+.3f cy=%+.3f size=%.3f det=%t conf=%.2f) "+
-					"state(cx=%+.3f cy=%+.3f age=%.2f valid=%t) "+
-					"cmd(yaw=%+.3f vert=%+.3f fwd=%+.3f)\n",
-				cmd.T,
-				cmd.Mode.String(),
-				lastObs.CX,
-				lastObs.CY,
-				lastObs.Size,
-				lastObs.Detected,
-				lastObs.Confidence,
-				st.CX,
-				st.CY,
-				st.Age,
-				st.Valid,
-				cmd.Yaw,
-				cmd.Vertical,
-				cmd.Forward,
-			)
+// Update stores the latest observation and advances the sequence counter.
+//
+// Reordered and duplicated packets are dropped; a clock reset starts a new epoch.
+func (s *liveStore) Update(sample liveSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.guard.Check(sample) {
+	case guardDrop:
+		return
+	case guardReset:
+		s.epoch++
+	}
+	sample.Epoch = s.epoch
+	s.last = sample
+	s.seq++
+	if s.queued {
+		s.enqueue(sample)
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue appends a sample, applying the overflow policy. Callers must hold the lock.
+func (s *liveStore) enqueue(sample liveSample) {
+	if len(s.queue) >= s.queueSize {
+		s.queueStats.Dropped++
+		if s.dropNewest {
+			return
 		}
+		s.queue = append(s.queue[:0], s.queue[1:]...)
+	}
+	s.queue = append(s.queue, sample)
+	if len(s.queue) > s.queueStats.HighWater {
+		s.queueStats.HighWater = len(s.queue)
+	}
+}
 
-		elapsed := time.Since(now).Seconds()
-		sleep := mathMax(0, dtTarget-elapsed)
-		time.Sleep(time.Duration(sleep * float64(time.Second)))
+// Drain returns all queued samples in arrival order and empties the queue.
+func (s *liveStore) Drain() []liveSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return nil
 	}
+	out := make([]liveSample, len(s.queue))
+	copy(out, s.queue)
+	s.queue = s.queue[:0]
+	return out
 }
 
-type liveStore struct {
-	mu       sync.RWMutex
-	last     AnchorObservation
-	lastHasT bool
-	seq      uint64
+// QueueStats returns the ingestion queue counters.
+func (s *liveStore) QueueStats() QueueStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := s.queueStats
+	stats.Depth = len(s.queue)
+	return stats
 }
 
-// Update stores the latest observation and advances the sequence counter.
-func (s *liveStore) Update(obs AnchorObservation, hasT bool) {
+// CountStale records a packet that was too old to use.
+func (s *liveStore) CountStale() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.last = obs
-	s.lastHasT = hasT
-	s.seq++
+	s.guard.counts.Stale++
 }
 
-// Snapshot returns the most recent observation and metadata.
-func (s *liveStore) Snapshot() (AnchorObservation, bool, uint64) {
+// Anomalies returns a copy of the input anomaly counters.
+func (s *liveStore) Anomalies() InputAnomalies {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.last, s.lastHasT, s.seq
+	return s.guard.counts
 }
 
-// startUDPListener spawns a goroutine that listens for observation packets.
-func startUDPListener(cfg LiveConfig, store *liveStore) error {
-	addr, err := net.ResolveUDPAddr("udp", cfg.UDPAddr)
-	if err != nil {
-		return err
-	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return err
-	}
-
-	bufSize := cfg.ReadBuffer
-	if bufSize <= 0 {
-		bufSize = 2048
-	}
+// Snapshot returns the most recent observation and its sequence counter.
+func (s *liveStore) Snapshot() (liveSample, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last, s.seq
+}
 
-	go func() {
-		buf := make([]byte, bufSize)
-		for {
-			n, _, err := conn.ReadFromUDP(buf)
-			if err != nil {
-				continue
-			}
-			obs, hasT, err := parseLiveObservation(buf[:n])
-			if err != nil {
+// handler returns a PacketHandler that parses payloads into the store.
+//
+// now stamps each packet with controller receive time. Text datagrams may hold
+// several newline-separated payloads (JSON lines or CSV rows).
+func (s *liveStore) handler(now func() float64) PacketHandler {
+	return func(payload []byte, err error) {
+		recvT := now()
+		if err != nil {
+			s.stats.RecordReadError(err, recvT)
+			return
+		}
+		if isBinaryObservation(payload) {
+			s.ingest(payload, recvT)
+			return
+		}
+		for _, line := range bytes.Split(payload, []byte{'\n'}) {
+			if len(bytes.TrimSpace(line)) == 0 {
 				continue
 			}
-			store.Update(obs, hasT)
+			s.ingest(line, recvT)
 		}
-	}()
+	}
+}
 
-	return nil
+// ingest decodes one payload and stores it.
+func (s *liveStore) ingest(payload []byte, recvT float64) {
+	sample, err := decodeLivePayload(payload)
+	if err != nil {
+		s.stats.RecordParseError(err, recvT)
+		return
+	}
+	sample.RecvT = recvT
+	s.stats.RecordPacket(sample)
+	s.Update(sample)
 }
 
+var (
+	errEmptyPayload = errors.New("empty payload")
+	errFieldCount   = errors.New("wrong field count")
+)
+
 // parseLiveObservation parses CSV payloads into AnchorObservation objects.
 func parseLiveObservation(b []byte) (AnchorObservation, bool, error) {
 	s := strings.TrimSpace(string(b))
 	if s == "" {
-		return AnchorObservation{}, false, errors.New("empty payload")
+		return AnchorObservation{}, false, errEmptyPayload
 	}
 
 	parts := strings.Split(s, ",")
 	if len(parts) != 5 && len(parts) != 6 {
-		return AnchorObservation{}, false, fmt.Errorf("expected 5 or 6 fields, got %d", len(parts))
+		return AnchorObservation{}, false, fmt.Errorf("%w: expected 5 or 6, got %d", errFieldCount, len(parts))
 	}
 
 	var idx int
@@ -232,11 +284,3 @@ func parseBoolLoose(value string) (bool, error) {
 		return f != 0, nil
 	}
 }
-
-// mathMax returns the larger of a or b.
-func mathMax(a, b float64) float64 {
-	if a > b {
-		return a
-	}
-	return b
-}
@@ -0,0 +1,118 @@
+package nad_nav
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLiveStoreQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		wantT    []float64
+	}{
+		{name: "drop oldest by default", wantT: []float64{3, 4, 5}},
+		{name: "drop_oldest", overflow: "drop_oldest", wantT: []float64{3, 4, 5}},
+		{name: "drop_newest", overflow: "drop_newest", wantT: []float64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notify := make(chan struct{}, 1)
+			s := newLiveStore(LiveConfig{Ingest: "queue", QueueSize: 3, Overflow: tt.overflow}, notify)
+			for i := 1; i <= 5; i++ {
+				s.Update(liveSample{Obs: AnchorObservation{T: float64(i), Detected: true}, HasT: true, RecvT: float64(i)})
+			}
+			select {
+			case <-notify:
+			default:
+				t.Error("loop not notified")
+			}
+			if q := s.QueueStats(); q.Depth != 3 || q.HighWater != 3 || q.Dropped != 2 {
+				t.Errorf("queue stats %+v, want depth 3 high 3 dropped 2", q)
+			}
+
+			got := s.Drain()
+			var gotT []float64
+			for _, sample := range got {
+				gotT = append(gotT, sample.Obs.T)
+			}
+			if fmt.Sprint(gotT) != fmt.Sprint(tt.wantT) {
+				t.Errorf("drained %v, want %v", gotT, tt.wantT)
+			}
+			// The newest accepted sample is still the latest, even when the
+			// queue dropped it.
+			if last, seq := s.Snapshot(); last.Obs.T != 5 || seq != 5 {
+				t.Errorf("latest %v seq %d, want 5 5", last.Obs.T, seq)
+			}
+			if q := s.QueueStats(); q.Depth != 0 || q.HighWater != 3 || q.Dropped != 2 {
+				t.Errorf("after drain %+v, want depth 0 and the counters kept", q)
+			}
+			if s.Drain() != nil {
+				t.Error("second drain returned samples")
+			}
+		})
+	}
+}
+
+func TestLiveStoreLatestModeDoesNotQueue(t *testing.T) {
+	s := newLiveStore(LiveConfig{}, make(chan struct{}, 1))
+	for i := 1; i <= 3; i++ {
+		s.Update(liveSample{Obs: AnchorObservation{T: float64(i)}, HasT: true, RecvT: float64(i)})
+	}
+	if got := s.Drain(); got != nil {
+		t.Errorf("latest mode queued %d samples", len(got))
+	}
+	if last, _ := s.Snapshot(); last.Obs.T != 3 {
+		t.Errorf("latest %v, want 3", last.Obs.T)
+	}
+}
+
+func TestLiveStoreDefaultQueueSize(t *testing.T) {
+	s := newLiveStore(LiveConfig{Ingest: "queue"}, make(chan struct{}, 1))
+	for i := 1; i <= 70; i++ {
+		s.Update(liveSample{Obs: AnchorObservation{T: float64(i)}, HasT: true, RecvT: float64(i)})
+	}
+	if q := s.QueueStats(); q.Depth != 64 || q.Dropped != 6 {
+		t.Errorf("queue stats %+v, want depth 64 dropped 6", q)
+	}
+}
+
+func TestQueueIngestFeedsEveryObservation(t *testing.T) {
+	// Three packets arrive between steps, moving right at 1 cx/s.
+	for _, tt := range []struct {
+		ingest string
+		wantVX float64
+	}{
+		{ingest: "latest", wantVX: 0},
+		{ingest: "queue", wantVX: 1},
+	} {
+		t.Run(tt.ingest, func(t *testing.T) {
+			var cfg AppConfig
+			cfg.Hz = 10
+			cfg.Live.Ingest = tt.ingest
+			cfg.Tracker.HoldSeconds = 1
+			src := &pushSource{}
+			clock := NewSimClock(time.Unix(1000, 0))
+			r, err := NewRunner(cfg, WithSource(src), WithOutput(&recordSink{}), WithClock(clock), WithTelemetry(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Stop()
+			if _, err := r.Step(); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []string{"0.1,1,0.9,0.1,0,0.05", "0.2,1,0.9,0.2,0,0.05", "0.3,1,0.9,0.3,0,0.05"} {
+				src.push(p)
+			}
+			if _, err := r.Step(); err != nil {
+				t.Fatal(err)
+			}
+			st := r.Snapshot().State
+			if !st.Valid || math.Abs(st.VX-tt.wantVX) > 1e-9 {
+				t.Errorf("state valid %v vx %v, want vx %v", st.Valid, st.VX, tt.wantVX)
+			}
+		})
+	}
+}
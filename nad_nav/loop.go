@@ -0,0 +1,323 @@
+package nad_nav
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LoopConfig selects how control steps are scheduled.
+//
+// Mode "fixed" (default) steps at hz on absolute deadlines and uses the newest
+// observation. Mode "event" steps as soon as an observation arrives and falls
+// back to fallback_hz (default hz) during dropouts so commands keep flowing.
+//
+// Overrun chooses what a fixed-rate tick that runs past the next deadline
+// does: "skip" (default) drops the missed deadlines, "catch_up" runs them
+// back to back unless more than max_catch_up (default 3) are missed.
+type LoopConfig struct {
+	Mode       string  `json:"mode"`
+	FallbackHz float64 `json:"fallback_hz"`
+	Overrun    string  `json:"overrun"`
+	MaxCatchUp int     `json:"max_catch_up"`
+}
+
+// liveLoop holds the pipeline state shared by all scheduling modes.
+type liveLoop struct {
+	cfg        AppConfig
+	clock      Clock
+	t0         time.Time
+	inputs     []*inputChannel
+	fusion     *Fusion
+	notify     chan struct{}
+	controller *DroneController
+	sender     CommandSink
+	vehicle    *VehicleLink
+	viz        *VizMetrics
+	timing     *loopTiming
+
+	// mu serializes steps and guards the snapshot fields.
+	mu       sync.Mutex
+	snapshot RunnerSnapshot
+
+	lastWall    time.Time
+	lastLinkLog float64
+	steps       uint64
+	modeSteps   map[Mode]uint64
+
+	// Loop latency is measured from packet receive to command send.
+	loopLatency     float64
+	lastLoopLatency float64
+	hasLoopLatency  bool
+}
+
+// runFixed steps at cfg.Hz on absolute deadlines until ctx is done, so
+// scheduling error does not accumulate.
+func (l *liveLoop) runFixed(ctx context.Context) {
+	period := time.Duration(float64(time.Second) / l.cfg.Hz)
+	maxCatchUp := l.cfg.Loop.MaxCatchUp
+	if maxCatchUp <= 0 {
+		maxCatchUp = 3
+	}
+
+	deadline := l.clock.Now()
+	timer := l.clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		start := l.clock.Now()
+		l.step(start)
+		end := l.clock.Now()
+		l.timing.recordTick(deadline, start, end.Sub(start))
+
+		next, skipped := nextDeadline(deadline, end, period, l.cfg.Loop.Overrun, maxCatchUp)
+		l.timing.recordDeadline(end.Sub(start), period, skipped)
+		deadline = next
+		timer.Reset(deadline.Sub(l.clock.Now()))
+	}
+}
+
+// runEvent steps on every new observation, with a fallback tick during
+// dropouts, until ctx is done.
+func (l *liveLoop) runEvent(ctx context.Context) {
+	fallbackHz := l.cfg.Loop.FallbackHz
+	if fallbackHz <= 0 {
+		fallbackHz = l.cfg.Hz
+	}
+	fallback := time.Duration(float64(time.Second) / fallbackHz)
+
+	timer := l.clock.NewTimer(fallback)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.notify:
+			if !timer.Stop() {
+				<-timer.C()
+			}
+		case <-timer.C():
+		}
+		start := l.clock.Now()
+		l.step(start)
+		l.timing.recordTick(time.Time{}, start, l.clock.Now().Sub(start))
+		timer.Reset(fallback)
+	}
+}
+
+// step runs one tracker and controller update at wall time now.
+func (l *liveLoop) step(now time.Time) BodyCommand {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg := l.cfg
+	simT := now.Sub(l.t0).Seconds()
+
+	fresh := false
+	var recvT float64
+	states := make([]AnchorState, len(l.inputs))
+	for i, in := range l.inputs {
+		states[i] = in.update(simT, l.controller.Cfg.ConfMin)
+		if in.fresh {
+			fresh = true
+			recvT = mathMax(recvT, in.recvT)
+		}
+	}
+	st := l.fusion.Select(states, l.controller.Mode())
+	primary := l.input(st.Camera)
+	lastObs := primary.lastObs
+
+	if l.viz != nil {
+		l.viz.UpdateInput(lastObs)
+		if primary.latency.Ready() {
+			l.viz.UpdateLatency(primary.latency.Latency(), primary.latency.LastLatency(), primary.latency.Offset())
+		}
+		l.viz.UpdateState(st)
+		l.viz.UpdateAnomalies(primary.store.Anomalies(), primary.tracker.Resets())
+		l.viz.UpdateLink(primary.store.stats.Snapshot(simT))
+		if primary.store.queued {
+			l.viz.UpdateQueue(primary.store.QueueStats())
+		}
+		if att, ok := primary.derot.Latest(); ok {
+			l.viz.UpdateAttitude(att)
+		}
+		for _, in := range l.inputs {
+			if in.auth != nil {
+				l.viz.UpdateAuth(in.name, in.auth.Stats())
+			}
+		}
+		if stats, ok := primary.derot.AuthStats(); ok {
+			l.viz.UpdateAuth("attitude", stats)
+		}
+		if len(l.inputs) > 1 {
+			for i, in := range l.inputs {
+				l.viz.UpdateCamera(i, in.name, states[i], l.fusion.score(states[i]), in.name == st.Camera)
+			}
+		}
+	}
+
+	dtReal := mathMax(1e-3, now.Sub(l.lastWall).Seconds())
+	l.lastWall = now
+
+	var vehicle VehicleState
+	if l.vehicle != nil {
+		vehicle = l.vehicle.State(simT)
+		l.controller.SetVehicle(vehicle)
+		if sink, ok := l.sender.(ArmedSink); ok {
+			sink.SetArmed(vehicle.Connected && vehicle.Armed)
+		}
+	}
+	cmd := l.controller.Step(st, dtReal)
+	if sink, ok := l.sender.(StateSink); ok {
+		sink.SendState(cmd, st)
+	} else {
+		l.sender.Send(cmd)
+	}
+	l.steps++
+	l.modeSteps[cmd.Mode]++
+	l.snapshot = RunnerSnapshot{
+		Steps:       l.steps,
+		Camera:      st.Camera,
+		Observation: lastObs,
+		State:       st,
+		Command:     cmd,
+		Vehicle:     vehicle,
+	}
+	if fresh {
+		l.recordLoopLatency(l.clock.Now().Sub(l.t0).Seconds() - recvT)
+	}
+	timing := l.timing.Stats()
+	sinks := l.sinkStats()
+	if l.viz != nil {
+		l.viz.UpdateOutput(cmd)
+		l.viz.UpdateSinks(sinks)
+		if l.vehicle != nil {
+			l.viz.UpdateVehicle(vehicle, l.vehicle.Stats(), l.controller.GuardReason())
+		}
+		l.viz.UpdateLoopTiming(timing)
+		if l.hasLoopLatency {
+			l.viz.UpdateLoopLatency(l.loopLatency, l.lastLoopLatency)
+		}
+	}
+
+	if cfg.Log.Enabled {
+		camera := ""
+		if len(l.inputs) > 1 {
+			camera = " cam=" + st.Camera
+		}
+		fmt.Printf(
+			"%8.3f mode=%-14s%s obs(cx=%+.3f cy=%+.3f size=%.3f det=%t conf=%.2f) "+
+				"state(cx=%+.3f cy=%+.3f age=%.2f valid=%t) "+
+				"cmd(yaw=%+.3f vert=%+.3f fwd=%+.3f)\n",
+			cmd.T,
+			cmd.Mode.String(),
+			camera,
+			lastObs.CX,
+			lastObs.CY,
+			lastObs.Size,
+			lastObs.Detected,
+			lastObs.Confidence,
+			st.CX,
+			st.CY,
+			st.Age,
+			st.Valid,
+			cmd.Yaw,
+			cmd.Vertical,
+			cmd.Forward,
+		)
+	}
+
+	if cfg.Log.Enabled && simT-l.lastLinkLog >= 1.0 {
+		l.lastLinkLog = simT
+		for _, in := range l.inputs {
+			prefix := ""
+			if len(l.inputs) > 1 {
+				prefix = in.name + " "
+			}
+			fmt.Printf("%8.3f %s%s\n", simT, prefix, in.store.stats.Snapshot(simT))
+			if in.store.queued {
+				q := in.store.QueueStats()
+				fmt.Printf("%8.3f %squeue depth=%d high=%d dropped=%d\n", simT, prefix, q.Depth, q.HighWater, q.Dropped)
+			}
+			if in.auth != nil {
+				fmt.Printf("%8.3f %s%s\n", simT, prefix, in.auth.Stats())
+			}
+		}
+		if stats, ok := l.inputs[0].derot.AuthStats(); ok {
+			fmt.Printf("%8.3f attitude %s\n", simT, stats)
+		}
+		if l.hasLoopLatency {
+			fmt.Printf("%8.3f loop mode=%s latency=%.2fms last=%.2fms\n",
+				simT, l.loopMode(), l.loopLatency*1000, l.lastLoopLatency*1000)
+		}
+		fmt.Printf("%8.3f %s\n", simT, timing)
+		if l.vehicle != nil {
+			guard := ""
+			if reason := l.controller.GuardReason(); reason != "" {
+				guard = " guard=" + reason
+			}
+			fmt.Printf("%8.3f %s%s\n", simT, vehicle, guard)
+		}
+		for _, s := range sinks {
+			fmt.Printf("%8.3f %s\n", simT, s)
+		}
+	}
+	return cmd
+}
+
+// getSnapshot returns the most recent step's values.
+func (l *liveLoop) getSnapshot() RunnerSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshot
+}
+
+// sinkStats returns per-sink statistics when the command sink is an
+// OutputSender.
+func (l *liveLoop) sinkStats() []SinkStats {
+	if sender, ok := l.sender.(*OutputSender); ok {
+		return sender.Stats()
+	}
+	return nil
+}
+
+// input returns the named input, or the first input when the name is unknown.
+func (l *liveLoop) input(name string) *inputChannel {
+	for _, in := range l.inputs {
+		if in.name == name {
+			return in
+		}
+	}
+	return l.inputs[0]
+}
+
+// recordLoopLatency updates the receive-to-send latency estimate.
+func (l *liveLoop) recordLoopLatency(latency float64) {
+	l.lastLoopLatency = latency
+	if !l.hasLoopLatency {
+		l.loopLatency = latency
+		l.hasLoopLatency = true
+		return
+	}
+	l.loopLatency += 0.1 * (latency - l.loopLatency)
+}
+
+// loopMode returns the configured scheduling mode name.
+func (l *liveLoop) loopMode() string {
+	if l.cfg.Loop.Mode == "" {
+		return "fixed"
+	}
+	return l.cfg.Loop.Mode
+}
+
+// mathMax returns the larger of a or b.
+func mathMax(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
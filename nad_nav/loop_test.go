@@ -0,0 +1,139 @@
+package nad_nav
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushSource hands the test its PacketHandler so packets arrive on demand.
+type pushSource struct {
+	mu     sync.Mutex
+	handle PacketHandler
+}
+
+func (s *pushSource) Start(handle PacketHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+	return nil
+}
+
+func (s *pushSource) Close() error { return nil }
+
+func (s *pushSource) push(payload string) {
+	s.mu.Lock()
+	handle := s.handle
+	s.mu.Unlock()
+	handle([]byte(payload), nil)
+}
+
+// waitSteps waits until the runner has taken n steps.
+func waitSteps(t *testing.T, r *Runner, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Snapshot().Steps < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("took %d steps, want %d", r.Snapshot().Steps, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitTimerIn waits until the loop has armed its timer and returns how far
+// ahead it is.
+func waitTimerIn(t *testing.T, clock *SimClock) time.Duration {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if next, ok := clock.NextDeadline(); ok {
+			return next.Sub(clock.Now())
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("loop is not waiting on the clock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventLoopStepsOnPacketsWithFallback(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 10
+	cfg.Loop = LoopConfig{Mode: "event", FallbackHz: 5}
+	clock := NewSimClock(time.Unix(1000, 0))
+	src := &pushSource{}
+	r, err := NewRunner(cfg, WithSource(src), WithOutput(&recordSink{}), WithClock(clock), WithTelemetry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	// Nothing arrives: the loop waits one fallback period, then steps.
+	if d := waitTimerIn(t, clock); d != 200*time.Millisecond {
+		t.Fatalf("fallback timer in %v, want 200ms", d)
+	}
+	clock.Advance(200 * time.Millisecond)
+	waitSteps(t, r, 1)
+	if snap := r.Snapshot(); snap.Observation.Detected {
+		t.Errorf("fallback step saw observation %+v", snap.Observation)
+	}
+
+	// A packet steps straight away without the clock moving.
+	waitTimerIn(t, clock)
+	clock.Advance(50 * time.Millisecond)
+	src.push("1,0.9,0.25,-0.1,0.05")
+	waitSteps(t, r, 2)
+	if snap := r.Snapshot(); !snap.Observation.Detected || snap.Observation.CX != 0.25 {
+		t.Errorf("event step observation %+v, want the pushed packet", snap.Observation)
+	}
+
+	// The packet restarted the fallback period.
+	if d := waitTimerIn(t, clock); d != 200*time.Millisecond {
+		t.Errorf("fallback timer in %v after a packet, want 200ms", d)
+	}
+	r.loop.mu.Lock()
+	latency, hasLatency := r.loop.loopLatency, r.loop.hasLoopLatency
+	r.loop.mu.Unlock()
+	if !hasLatency || latency != 0 {
+		t.Errorf("loop latency %v %v, want 0 on a clock that did not move", latency, hasLatency)
+	}
+
+	clock.Advance(199 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if steps := r.Snapshot().Steps; steps != 2 {
+		t.Errorf("%d steps before the fallback period ended, want 2", steps)
+	}
+	clock.Advance(time.Millisecond)
+	waitSteps(t, r, 3)
+}
+
+func TestEventLoopFallbackDefaultsToHz(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 20
+	cfg.Loop = LoopConfig{Mode: "event"}
+	clock := NewSimClock(time.Unix(1000, 0))
+	r, err := NewRunner(cfg, WithSource(idleSource{}), WithOutput(&recordSink{}), WithClock(clock), WithTelemetry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	if d := waitTimerIn(t, clock); d != 50*time.Millisecond {
+		t.Errorf("fallback timer in %v, want 50ms", d)
+	}
+}
+
+func TestRunnerRejectsUnknownLoopMode(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 10
+	cfg.Loop.Mode = "burst"
+	if _, err := NewRunner(cfg, WithSource(idleSource{}), WithOutput(&recordSink{}), WithTelemetry(nil)); err == nil {
+		t.Error("unknown loop.mode accepted")
+	}
+}
@@ -0,0 +1,574 @@
+package nad_nav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MAVLink v2 frame layout:
+//
+//	offset  size  field
+//	0       1     magic 0xFD
+//	1       1     payload length
+//	2       1     incompatibility flags (bit 0: signed)
+//	3       1     compatibility flags
+//	4       1     sequence
+//	5       1     system id
+//	6       1     component id
+//	7       3     message id (little endian)
+//	10      len   payload, trailing zero bytes truncated
+//	10+len  2     CRC-16/MCRF4XX of bytes 1..10+len, then the message's CRC extra
+//	12+len  13    signature, when signed (not verified)
+const (
+	mavlinkMagic        = 0xFD
+	mavlinkHeaderLen    = 10
+	mavlinkChecksumLen  = 2
+	mavlinkSignatureLen = 13
+	mavlinkFlagSigned   = 0x01
+)
+
+// MAVLink message ids handled by nad.
+const (
+	MAVLinkMsgHeartbeat          = 0
+	MAVLinkMsgSysStatus          = 1
+	MAVLinkMsgAttitude           = 30
+	MAVLinkMsgRCChannels         = 65
+	MAVLinkMsgRCChannelsOverride = 70
+	MAVLinkMsgCommandLong        = 76
+	MAVLinkMsgSetAttitudeTarget  = 82
+	MAVLinkMsgBatteryStatus      = 147
+)
+
+// MAVLink enum values used by nad.
+const (
+	mavTypeGCS             = 6
+	mavAutopilotInvalid    = 8
+	mavModeFlagSafetyArmed = 0x80
+	mavStateActive         = 4
+	mavCmdDoSetServo       = 183
+	mavlinkProtocolVersion = 3
+)
+
+// mavlinkMessageInfo is the CRC extra and full payload length of a message.
+type mavlinkMessageInfo struct {
+	name     string
+	crcExtra byte
+	length   int
+}
+
+var mavlinkMessages = map[uint32]mavlinkMessageInfo{
+	MAVLinkMsgHeartbeat:          {"HEARTBEAT", 50, 9},
+	MAVLinkMsgSysStatus:          {"SYS_STATUS", 124, 31},
+	MAVLinkMsgAttitude:           {"ATTITUDE", 39, 28},
+	MAVLinkMsgRCChannels:         {"RC_CHANNELS", 118, 42},
+	MAVLinkMsgRCChannelsOverride: {"RC_CHANNELS_OVERRIDE", 124, 38},
+	MAVLinkMsgCommandLong:        {"COMMAND_LONG", 152, 33},
+	MAVLinkMsgSetAttitudeTarget:  {"SET_ATTITUDE_TARGET", 49, 39},
+	MAVLinkMsgBatteryStatus:      {"BATTERY_STATUS", 154, 36},
+}
+
+var (
+	errMAVLinkUnknown  = errors.New("unknown mavlink message")
+	errMAVLinkChecksum = errors.New("mavlink checksum mismatch")
+)
+
+// MAVLinkMessageName returns the MAVLink name of a message id.
+func MAVLinkMessageName(id uint32) string {
+	if info, ok := mavlinkMessages[id]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("MSG_%d", id)
+}
+
+// MAVLinkFrame is one MAVLink v2 frame. Decoded payloads are zero padded to
+// the message's full length.
+type MAVLinkFrame struct {
+	Seq         uint8
+	SystemID    uint8
+	ComponentID uint8
+	MessageID   uint32
+	Payload     []byte
+}
+
+// EncodeMAVLinkFrame serializes f as an unsigned MAVLink v2 frame.
+func EncodeMAVLinkFrame(f MAVLinkFrame) ([]byte, error) {
+	info, ok := mavlinkMessages[f.MessageID]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", errMAVLinkUnknown, f.MessageID)
+	}
+	payload := f.Payload
+	for len(payload) > 1 && payload[len(payload)-1] == 0 {
+		payload = payload[:len(payload)-1]
+	}
+	buf := make([]byte, mavlinkHeaderLen+len(payload)+mavlinkChecksumLen)
+	buf[0] = mavlinkMagic
+	buf[1] = byte(len(payload))
+	buf[4] = f.Seq
+	buf[5] = f.SystemID
+	buf[6] = f.ComponentID
+	buf[7] = byte(f.MessageID)
+	buf[8] = byte(f.MessageID >> 8)
+	buf[9] = byte(f.MessageID >> 16)
+	copy(buf[mavlinkHeaderLen:], payload)
+	n := mavlinkHeaderLen + len(payload)
+	binary.LittleEndian.PutUint16(buf[n:], mavlinkChecksum(buf[1:n], info.crcExtra))
+	return buf, nil
+}
+
+// ParseMAVLinkFrame parses the frame at the start of data and returns the
+// number of bytes it used. Frames of unknown messages are consumed but
+// returned with errMAVLinkUnknown, since their checksum cannot be verified.
+func ParseMAVLinkFrame(data []byte) (MAVLinkFrame, int, error) {
+	if len(data) < mavlinkHeaderLen+mavlinkChecksumLen {
+		return MAVLinkFrame{}, 0, errPacketTruncated
+	}
+	if data[0] != mavlinkMagic {
+		return MAVLinkFrame{}, 0, fmt.Errorf("bad magic 0x%02x", data[0])
+	}
+	length := int(data[1])
+	n := mavlinkHeaderLen + length + mavlinkChecksumLen
+	if data[2]&mavlinkFlagSigned != 0 {
+		n += mavlinkSignatureLen
+	}
+	if len(data) < n {
+		return MAVLinkFrame{}, 0, errPacketTruncated
+	}
+	f := MAVLinkFrame{
+		Seq:         data[4],
+		SystemID:    data[5],
+		ComponentID: data[6],
+		MessageID:   uint32(data[7]) | uint32(data[8])<<8 | uint32(data[9])<<16,
+	}
+	info, ok := mavlinkMessages[f.MessageID]
+	if !ok {
+		return f, n, fmt.Errorf("%w %d", errMAVLinkUnknown, f.MessageID)
+	}
+	end := mavlinkHeaderLen + length
+	if binary.LittleEndian.Uint16(data[end:]) != mavlinkChecksum(data[1:end], info.crcExtra) {
+		return f, n, errMAVLinkChecksum
+	}
+	f.Payload = make([]byte, max(length, info.length))
+	copy(f.Payload, data[mavlinkHeaderLen:end])
+	return f, n, nil
+}
+
+// mavlinkChecksum is CRC-16/MCRF4XX over data followed by crcExtra.
+func mavlinkChecksum(data []byte, crcExtra byte) uint16 {
+	crc := uint16(0xFFFF)
+	accumulate := func(b byte) {
+		tmp := b ^ byte(crc)
+		tmp ^= tmp << 4
+		crc = crc>>8 ^ uint16(tmp)<<8 ^ uint16(tmp)<<3 ^ uint16(tmp)>>4
+	}
+	for _, b := range data {
+		accumulate(b)
+	}
+	accumulate(crcExtra)
+	return crc
+}
+
+// MAVLinkParser splits a byte stream or datagrams into frames, resyncing on
+// the magic byte after garbage or corrupt frames.
+type MAVLinkParser struct {
+	buf []byte
+
+	Frames  uint64
+	Errors  uint64 // checksum failures and skipped garbage runs
+	Unknown uint64 // frames of messages nad does not decode
+}
+
+// Parse consumes data and returns every complete frame. Incomplete trailing
+// bytes are kept for the next call.
+func (p *MAVLinkParser) Parse(data []byte) []MAVLinkFrame {
+	p.buf = append(p.buf, data...)
+	var frames []MAVLinkFrame
+	for len(p.buf) > 0 {
+		if p.buf[0] != mavlinkMagic {
+			next := 1
+			for next < len(p.buf) && p.buf[next] != mavlinkMagic {
+				next++
+			}
+			p.buf = p.buf[next:]
+			p.Errors++
+			continue
+		}
+		f, n, err := ParseMAVLinkFrame(p.buf)
+		switch {
+		case errors.Is(err, errPacketTruncated):
+			p.buf = append([]byte(nil), p.buf...)
+			return frames
+		case errors.Is(err, errMAVLinkUnknown):
+			p.Unknown++
+			p.buf = p.buf[n:]
+		case err != nil:
+			// Corrupt frame: skip the magic byte and resync.
+			p.Errors++
+			p.buf = p.buf[1:]
+		default:
+			p.Frames++
+			frames = append(frames, f)
+			p.buf = p.buf[n:]
+		}
+	}
+	return frames
+}
+
+// MAVLinkMessage is a typed MAVLink message.
+type MAVLinkMessage interface {
+	MessageID() uint32
+	marshal() []byte
+}
+
+// DecodeMAVLinkMessage decodes a frame's payload into its typed message.
+func DecodeMAVLinkMessage(f MAVLinkFrame) (MAVLinkMessage, error) {
+	info, ok := mavlinkMessages[f.MessageID]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", errMAVLinkUnknown, f.MessageID)
+	}
+	if len(f.Payload) < info.length {
+		return nil, errPacketTruncated
+	}
+	p := f.Payload
+	switch f.MessageID {
+	case MAVLinkMsgHeartbeat:
+		return MAVLinkHeartbeat{
+			CustomMode:     binary.LittleEndian.Uint32(p[0:]),
+			Type:           p[4],
+			Autopilot:      p[5],
+			BaseMode:       p[6],
+			SystemStatus:   p[7],
+			MAVLinkVersion: p[8],
+		}, nil
+	case MAVLinkMsgCommandLong:
+		m := MAVLinkCommandLong{
+			Command:         binary.LittleEndian.Uint16(p[28:]),
+			TargetSystem:    p[30],
+			TargetComponent: p[31],
+			Confirmation:    p[32],
+		}
+		for i := range m.Params {
+			m.Params[i] = getFloat32(p[4*i:])
+		}
+		return m, nil
+	case MAVLinkMsgRCChannelsOverride:
+		m := MAVLinkRCChannelsOverride{TargetSystem: p[16], TargetComponent: p[17]}
+		for i := 0; i < 8; i++ {
+			m.Channels[i] = binary.LittleEndian.Uint16(p[2*i:])
+		}
+		for i := 8; i < 18; i++ {
+			m.Channels[i] = binary.LittleEndian.Uint16(p[18+2*(i-8):])
+		}
+		return m, nil
+	case MAVLinkMsgSetAttitudeTarget:
+		m := MAVLinkSetAttitudeTarget{
+			TimeBootMs:      binary.LittleEndian.Uint32(p[0:]),
+			BodyRollRate:    getFloat32(p[20:]),
+			BodyPitchRate:   getFloat32(p[24:]),
+			BodyYawRate:     getFloat32(p[28:]),
+			Thrust:          getFloat32(p[32:]),
+			TargetSystem:    p[36],
+			TargetComponent: p[37],
+			TypeMask:        p[38],
+		}
+		for i := range m.Q {
+			m.Q[i] = getFloat32(p[4+4*i:])
+		}
+		return m, nil
+	case MAVLinkMsgSysStatus:
+		return MAVLinkSysStatus{
+			SensorsPresent: binary.LittleEndian.Uint32(p[0:]),
+			SensorsEnabled: binary.LittleEndian.Uint32(p[4:]),
+			SensorsHealth:  binary.LittleEndian.Uint32(p[8:]),
+			Load:           binary.LittleEndian.Uint16(p[12:]),
+			VoltageBattery: binary.LittleEndian.Uint16(p[14:]),
+			CurrentBattery: int16(binary.LittleEndian.Uint16(p[16:])),
+			DropRateComm:   binary.LittleEndian.Uint16(p[18:]),
+			ErrorsComm:     binary.LittleEndian.Uint16(p[20:]),
+			BatteryRemain:  int8(p[30]),
+		}, nil
+	case MAVLinkMsgAttitude:
+		return MAVLinkAttitude{
+			TimeBootMs: binary.LittleEndian.Uint32(p[0:]),
+			Roll:       getFloat32(p[4:]),
+			Pitch:      getFloat32(p[8:]),
+			Yaw:        getFloat32(p[12:]),
+			RollSpeed:  getFloat32(p[16:]),
+			PitchSpeed: getFloat32(p[20:]),
+			YawSpeed:   getFloat32(p[24:]),
+		}, nil
+	case MAVLinkMsgRCChannels:
+		m := MAVLinkRCChannels{
+			TimeBootMs: binary.LittleEndian.Uint32(p[0:]),
+			ChanCount:  p[40],
+			RSSI:       p[41],
+		}
+		for i := range m.Channels {
+			m.Channels[i] = binary.LittleEndian.Uint16(p[4+2*i:])
+		}
+		return m, nil
+	case MAVLinkMsgBatteryStatus:
+		m := MAVLinkBatteryStatus{
+			CurrentConsumed: int32(binary.LittleEndian.Uint32(p[0:])),
+			EnergyConsumed:  int32(binary.LittleEndian.Uint32(p[4:])),
+			Temperature:     int16(binary.LittleEndian.Uint16(p[8:])),
+			CurrentBattery:  int16(binary.LittleEndian.Uint16(p[30:])),
+			ID:              p[32],
+			BatteryFunction: p[33],
+			Type:            p[34],
+			BatteryRemain:   int8(p[35]),
+		}
+		for i := range m.Voltages {
+			m.Voltages[i] = binary.LittleEndian.Uint16(p[10+2*i:])
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%w %d", errMAVLinkUnknown, f.MessageID)
+}
+
+// MAVLinkHeartbeat is HEARTBEAT (#0).
+type MAVLinkHeartbeat struct {
+	Type           uint8
+	Autopilot      uint8
+	BaseMode       uint8
+	CustomMode     uint32
+	SystemStatus   uint8
+	MAVLinkVersion uint8
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkHeartbeat) MessageID() uint32 { return MAVLinkMsgHeartbeat }
+
+func (m MAVLinkHeartbeat) marshal() []byte {
+	p := make([]byte, 9)
+	binary.LittleEndian.PutUint32(p[0:], m.CustomMode)
+	p[4] = m.Type
+	p[5] = m.Autopilot
+	p[6] = m.BaseMode
+	p[7] = m.SystemStatus
+	p[8] = m.MAVLinkVersion
+	return p
+}
+
+// MAVLinkCommandLong is COMMAND_LONG (#76).
+type MAVLinkCommandLong struct {
+	TargetSystem    uint8
+	TargetComponent uint8
+	Command         uint16
+	Confirmation    uint8
+	Params          [7]float32
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkCommandLong) MessageID() uint32 { return MAVLinkMsgCommandLong }
+
+func (m MAVLinkCommandLong) marshal() []byte {
+	p := make([]byte, 33)
+	for i, v := range m.Params {
+		putFloat32(p[4*i:], v)
+	}
+	binary.LittleEndian.PutUint16(p[28:], m.Command)
+	p[30] = m.TargetSystem
+	p[31] = m.TargetComponent
+	p[32] = m.Confirmation
+	return p
+}
+
+// MAVLinkRCChannelsOverride is RC_CHANNELS_OVERRIDE (#70). Channel value 0
+// releases channels 1-8 to the radio and UINT16_MAX leaves a channel unchanged.
+type MAVLinkRCChannelsOverride struct {
+	TargetSystem    uint8
+	TargetComponent uint8
+	Channels        [18]uint16
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkRCChannelsOverride) MessageID() uint32 { return MAVLinkMsgRCChannelsOverride }
+
+func (m MAVLinkRCChannelsOverride) marshal() []byte {
+	p := make([]byte, 38)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint16(p[2*i:], m.Channels[i])
+	}
+	p[16] = m.TargetSystem
+	p[17] = m.TargetComponent
+	for i := 8; i < 18; i++ {
+		binary.LittleEndian.PutUint16(p[18+2*(i-8):], m.Channels[i])
+	}
+	return p
+}
+
+// SET_ATTITUDE_TARGET type_mask bits.
+const (
+	AttitudeTargetIgnoreRollRate  = 0x01
+	AttitudeTargetIgnorePitchRate = 0x02
+	AttitudeTargetIgnoreYawRate   = 0x04
+	AttitudeTargetIgnoreThrust    = 0x40
+	AttitudeTargetIgnoreAttitude  = 0x80
+)
+
+// MAVLinkSetAttitudeTarget is SET_ATTITUDE_TARGET (#82). Rates are rad/s in
+// the body frame (forward, right, down); thrust is 0..1.
+type MAVLinkSetAttitudeTarget struct {
+	TimeBootMs      uint32
+	TargetSystem    uint8
+	TargetComponent uint8
+	TypeMask        uint8
+	Q               [4]float32 // w, x, y, z
+	BodyRollRate    float32
+	BodyPitchRate   float32
+	BodyYawRate     float32
+	Thrust          float32
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkSetAttitudeTarget) MessageID() uint32 { return MAVLinkMsgSetAttitudeTarget }
+
+func (m MAVLinkSetAttitudeTarget) marshal() []byte {
+	p := make([]byte, 39)
+	binary.LittleEndian.PutUint32(p[0:], m.TimeBootMs)
+	for i, v := range m.Q {
+		putFloat32(p[4+4*i:], v)
+	}
+	putFloat32(p[20:], m.BodyRollRate)
+	putFloat32(p[24:], m.BodyPitchRate)
+	putFloat32(p[28:], m.BodyYawRate)
+	putFloat32(p[32:], m.Thrust)
+	p[36] = m.TargetSystem
+	p[37] = m.TargetComponent
+	p[38] = m.TypeMask
+	return p
+}
+
+// MAVLinkSysStatus is SYS_STATUS (#1). VoltageBattery is in mV (UINT16_MAX
+// unknown), CurrentBattery in cA (-1 unknown) and BatteryRemain in percent
+// (-1 unknown).
+type MAVLinkSysStatus struct {
+	SensorsPresent uint32
+	SensorsEnabled uint32
+	SensorsHealth  uint32
+	Load           uint16
+	VoltageBattery uint16
+	CurrentBattery int16
+	DropRateComm   uint16
+	ErrorsComm     uint16
+	BatteryRemain  int8
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkSysStatus) MessageID() uint32 { return MAVLinkMsgSysStatus }
+
+func (m MAVLinkSysStatus) marshal() []byte {
+	p := make([]byte, 31)
+	binary.LittleEndian.PutUint32(p[0:], m.SensorsPresent)
+	binary.LittleEndian.PutUint32(p[4:], m.SensorsEnabled)
+	binary.LittleEndian.PutUint32(p[8:], m.SensorsHealth)
+	binary.LittleEndian.PutUint16(p[12:], m.Load)
+	binary.LittleEndian.PutUint16(p[14:], m.VoltageBattery)
+	binary.LittleEndian.PutUint16(p[16:], uint16(m.CurrentBattery))
+	binary.LittleEndian.PutUint16(p[18:], m.DropRateComm)
+	binary.LittleEndian.PutUint16(p[20:], m.ErrorsComm)
+	p[30] = byte(m.BatteryRemain)
+	return p
+}
+
+// MAVLinkAttitude is ATTITUDE (#30), in radians and rad/s.
+type MAVLinkAttitude struct {
+	TimeBootMs uint32
+	Roll       float32
+	Pitch      float32
+	Yaw        float32
+	RollSpeed  float32
+	PitchSpeed float32
+	YawSpeed   float32
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkAttitude) MessageID() uint32 { return MAVLinkMsgAttitude }
+
+func (m MAVLinkAttitude) marshal() []byte {
+	p := make([]byte, 28)
+	binary.LittleEndian.PutUint32(p[0:], m.TimeBootMs)
+	for i, v := range []float32{m.Roll, m.Pitch, m.Yaw, m.RollSpeed, m.PitchSpeed, m.YawSpeed} {
+		putFloat32(p[4+4*i:], v)
+	}
+	return p
+}
+
+// MAVLinkRCChannels is RC_CHANNELS (#65). Unused channels read UINT16_MAX;
+// RSSI is 0..254, 255 when unknown.
+type MAVLinkRCChannels struct {
+	TimeBootMs uint32
+	ChanCount  uint8
+	Channels   [18]uint16
+	RSSI       uint8
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkRCChannels) MessageID() uint32 { return MAVLinkMsgRCChannels }
+
+func (m MAVLinkRCChannels) marshal() []byte {
+	p := make([]byte, 42)
+	binary.LittleEndian.PutUint32(p[0:], m.TimeBootMs)
+	for i, v := range m.Channels {
+		binary.LittleEndian.PutUint16(p[4+2*i:], v)
+	}
+	p[40] = m.ChanCount
+	p[41] = m.RSSI
+	return p
+}
+
+// MAVLinkBatteryStatus is BATTERY_STATUS (#147). Voltages are per cell in mV
+// (UINT16_MAX unused); CurrentBattery is in cA (-1 unknown).
+type MAVLinkBatteryStatus struct {
+	ID              uint8
+	BatteryFunction uint8
+	Type            uint8
+	Temperature     int16
+	Voltages        [10]uint16
+	CurrentBattery  int16
+	CurrentConsumed int32
+	EnergyConsumed  int32
+	BatteryRemain   int8
+}
+
+// MessageID implements MAVLinkMessage.
+func (MAVLinkBatteryStatus) MessageID() uint32 { return MAVLinkMsgBatteryStatus }
+
+func (m MAVLinkBatteryStatus) marshal() []byte {
+	p := make([]byte, 36)
+	binary.LittleEndian.PutUint32(p[0:], uint32(m.CurrentConsumed))
+	binary.LittleEndian.PutUint32(p[4:], uint32(m.EnergyConsumed))
+	binary.LittleEndian.PutUint16(p[8:], uint16(m.Temperature))
+	for i, v := range m.Voltages {
+		binary.LittleEndian.PutUint16(p[10+2*i:], v)
+	}
+	binary.LittleEndian.PutUint16(p[30:], uint16(m.CurrentBattery))
+	p[32] = m.ID
+	p[33] = m.BatteryFunction
+	p[34] = m.Type
+	p[35] = byte(m.BatteryRemain)
+	return p
+}
+
+// EncodeMAVLinkMessage serializes m as an unsigned MAVLink v2 frame.
+func EncodeMAVLinkMessage(m MAVLinkMessage, seq, systemID, componentID uint8) ([]byte, error) {
+	return EncodeMAVLinkFrame(MAVLinkFrame{
+		Seq:         seq,
+		SystemID:    systemID,
+		ComponentID: componentID,
+		MessageID:   m.MessageID(),
+		Payload:     m.marshal(),
+	})
+}
+
+// getFloat32 reads a little-endian float32.
+func getFloat32(b []byte) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+// putFloat32 writes a little-endian float32.
+func putFloat32(b []byte, v float32) {
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+}
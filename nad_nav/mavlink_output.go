@@ -0,0 +1,259 @@
+package nad_nav
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"nad-navigation/mixer"
+)
+
+// MAVLinkConfig configures the mavlink output format.
+//
+// Message picks how commands are sent: "servo" (default) sends one
+// MAV_CMD_DO_SET_SERVO COMMAND_LONG per mapped channel, "rc_override" one
+// RC_CHANNELS_OVERRIDE, and "attitude" one SET_ATTITUDE_TARGET with body
+// pitch and yaw rates from vertical and yaw (scaled by max_rate_dps, default
+// 90) and thrust from forward.
+//
+// Channels maps yaw, vertical and forward to 1-based servo or RC channels
+// (defaults 4, 2 and 3; a negative value leaves the axis unsent). Yaw and
+// vertical map linearly from [-1, 1] and forward from [0, 1] onto
+// pwm_min..pwm_max (default 1000..2000). While disarmed (see OutputPacket) yaw and
+// vertical are sent centred and forward at pwm_min.
+//
+// When the sink has a mixer, servo and rc_override send every mixed actuator
+// on its output channel instead, and channels, pwm_min and pwm_max are
+// unused; while disarmed the fins sit at their trims and throttle is off.
+//
+// Frames come from system_id/component_id (default 255/190, a ground
+// station) and address target_system/target_component (default 1/1). A
+// HEARTBEAT is sent heartbeat_hz times per second (default 1).
+type MAVLinkConfig struct {
+	Message         string          `json:"message"`
+	SystemID        int             `json:"system_id"`
+	ComponentID     int             `json:"component_id"`
+	TargetSystem    int             `json:"target_system"`
+	TargetComponent int             `json:"target_component"`
+	HeartbeatHz     float64         `json:"heartbeat_hz"`
+	Channels        MAVLinkChannels `json:"channels"`
+	PWMMin          int             `json:"pwm_min"`
+	PWMMax          int             `json:"pwm_max"`
+	MaxRateDps      float64         `json:"max_rate_dps"`
+}
+
+// MAVLinkChannels maps command axes to servo or RC channel numbers.
+type MAVLinkChannels struct {
+	Yaw      int `json:"yaw"`
+	Vertical int `json:"vertical"`
+	Forward  int `json:"forward"`
+}
+
+// mavlinkEncoder turns commands into MAVLink frames. Encode and Heartbeat
+// may run concurrently, so the frame sequence is locked.
+type mavlinkEncoder struct {
+	cfg      MAVLinkConfig
+	channels [3]int // yaw, vertical, forward; 0 disables
+	mixer    *mixer.Mixer
+	period   time.Duration
+
+	mu  sync.Mutex
+	seq uint8
+}
+
+// newMAVLinkEncoder validates cfg and fills in defaults. mix, if set, builds
+// the actuator mixer.
+func newMAVLinkEncoder(cfg MAVLinkConfig, mix *mixer.Config) (*mavlinkEncoder, error) {
+	switch cfg.Message {
+	case "":
+		cfg.Message = "servo"
+	case "servo", "rc_override", "attitude":
+	default:
+		return nil, fmt.Errorf("unknown mavlink.message %q", cfg.Message)
+	}
+	cfg.SystemID = defaultInt(cfg.SystemID, 255)
+	cfg.ComponentID = defaultInt(cfg.ComponentID, 190)
+	cfg.TargetSystem = defaultInt(cfg.TargetSystem, 1)
+	cfg.TargetComponent = defaultInt(cfg.TargetComponent, 1)
+	for _, id := range []int{cfg.SystemID, cfg.ComponentID, cfg.TargetSystem, cfg.TargetComponent} {
+		if id < 0 || id > math.MaxUint8 {
+			return nil, fmt.Errorf("mavlink id %d out of range", id)
+		}
+	}
+	cfg.PWMMin = defaultInt(cfg.PWMMin, 1000)
+	cfg.PWMMax = defaultInt(cfg.PWMMax, 2000)
+	if cfg.PWMMin >= cfg.PWMMax || cfg.PWMMax >= math.MaxUint16 {
+		return nil, fmt.Errorf("mavlink pwm range %d..%d is invalid", cfg.PWMMin, cfg.PWMMax)
+	}
+	if cfg.MaxRateDps <= 0 {
+		cfg.MaxRateDps = 90
+	}
+	if cfg.HeartbeatHz <= 0 {
+		cfg.HeartbeatHz = 1
+	}
+
+	e := &mavlinkEncoder{
+		cfg:    cfg,
+		period: time.Duration(float64(time.Second) / cfg.HeartbeatHz),
+	}
+	maxChannel := 18
+	if cfg.Message == "servo" {
+		maxChannel = 32
+	}
+	for i, ch := range []int{cfg.Channels.Yaw, cfg.Channels.Vertical, cfg.Channels.Forward} {
+		ch = defaultInt(ch, [3]int{4, 2, 3}[i])
+		if ch > maxChannel {
+			return nil, fmt.Errorf("mavlink channel %d out of range", ch)
+		}
+		e.channels[i] = max(ch, 0)
+	}
+	if mix != nil {
+		if cfg.Message == "attitude" {
+			return nil, fmt.Errorf("mixer does not apply to mavlink.message attitude")
+		}
+		m, err := mixer.New(*mix)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range m.Outputs() {
+			if out > maxChannel {
+				return nil, fmt.Errorf("mixer output %d out of range", out)
+			}
+		}
+		e.mixer = m
+	}
+	return e, nil
+}
+
+// Encode implements commandEncoder.
+func (e *mavlinkEncoder) Encode(p OutputPacket) []byte {
+	cmd := p.Command
+	axes := [3]float64{clamp(cmd.Yaw, -1, 1), clamp(cmd.Vertical, -1, 1), clamp(cmd.Forward, 0, 1)*2 - 1}
+	if !p.Armed {
+		axes = [3]float64{0, 0, -1}
+	}
+
+	var msgs []MAVLinkMessage
+	if e.mixer != nil {
+		return e.frames(e.mixed(p)...)
+	}
+	switch e.cfg.Message {
+	case "servo":
+		for i, ch := range e.channels {
+			if ch == 0 {
+				continue
+			}
+			msgs = append(msgs, MAVLinkCommandLong{
+				TargetSystem:    uint8(e.cfg.TargetSystem),
+				TargetComponent: uint8(e.cfg.TargetComponent),
+				Command:         mavCmdDoSetServo,
+				Params:          [7]float32{float32(ch), float32(e.pwm(axes[i]))},
+			})
+		}
+	case "rc_override":
+		m := MAVLinkRCChannelsOverride{
+			TargetSystem:    uint8(e.cfg.TargetSystem),
+			TargetComponent: uint8(e.cfg.TargetComponent),
+		}
+		for i := range m.Channels {
+			m.Channels[i] = math.MaxUint16
+		}
+		for i, ch := range e.channels {
+			if ch != 0 {
+				m.Channels[ch-1] = e.pwm(axes[i])
+			}
+		}
+		msgs = append(msgs, m)
+	case "attitude":
+		rate := e.cfg.MaxRateDps * math.Pi / 180
+		msgs = append(msgs, MAVLinkSetAttitudeTarget{
+			TimeBootMs:      uint32(math.Max(0, cmd.T*1000)),
+			TargetSystem:    uint8(e.cfg.TargetSystem),
+			TargetComponent: uint8(e.cfg.TargetComponent),
+			TypeMask:        AttitudeTargetIgnoreAttitude | AttitudeTargetIgnoreRollRate,
+			Q:               [4]float32{1, 0, 0, 0},
+			BodyPitchRate:   float32(axes[1] * rate),
+			BodyYawRate:     float32(axes[0] * rate),
+			Thrust:          float32((axes[2] + 1) / 2),
+		})
+	}
+	return e.frames(msgs...)
+}
+
+// mixed returns the messages that set every mixed actuator.
+func (e *mavlinkEncoder) mixed(p OutputPacket) []MAVLinkMessage {
+	pwm := mixCommand(e.mixer, p).PWM
+	outputs := e.mixer.Outputs()
+	if e.cfg.Message == "rc_override" {
+		m := MAVLinkRCChannelsOverride{
+			TargetSystem:    uint8(e.cfg.TargetSystem),
+			TargetComponent: uint8(e.cfg.TargetComponent),
+		}
+		for i := range m.Channels {
+			m.Channels[i] = math.MaxUint16
+		}
+		for i, ch := range outputs {
+			m.Channels[ch-1] = pwm[i]
+		}
+		return []MAVLinkMessage{m}
+	}
+	msgs := make([]MAVLinkMessage, len(pwm))
+	for i, ch := range outputs {
+		msgs[i] = MAVLinkCommandLong{
+			TargetSystem:    uint8(e.cfg.TargetSystem),
+			TargetComponent: uint8(e.cfg.TargetComponent),
+			Command:         mavCmdDoSetServo,
+			Params:          [7]float32{float32(ch), float32(pwm[i])},
+		}
+	}
+	return msgs
+}
+
+// Text implements commandEncoder.
+func (*mavlinkEncoder) Text() bool { return false }
+
+// Heartbeat returns a HEARTBEAT frame.
+func (e *mavlinkEncoder) Heartbeat() []byte {
+	return e.frames(MAVLinkHeartbeat{
+		Type:           mavTypeGCS,
+		Autopilot:      mavAutopilotInvalid,
+		SystemStatus:   mavStateActive,
+		MAVLinkVersion: mavlinkProtocolVersion,
+	})
+}
+
+// HeartbeatPeriod returns the interval between heartbeats.
+func (e *mavlinkEncoder) HeartbeatPeriod() time.Duration {
+	return e.period
+}
+
+// frames encodes msgs as consecutive frames.
+func (e *mavlinkEncoder) frames(msgs ...MAVLinkMessage) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []byte
+	for _, m := range msgs {
+		frame, err := EncodeMAVLinkMessage(m, e.seq, uint8(e.cfg.SystemID), uint8(e.cfg.ComponentID))
+		if err != nil {
+			continue
+		}
+		e.seq++
+		out = append(out, frame...)
+	}
+	return out
+}
+
+// pwm maps v in [-1, 1] onto the PWM range.
+func (e *mavlinkEncoder) pwm(v float64) uint16 {
+	span := float64(e.cfg.PWMMax - e.cfg.PWMMin)
+	return uint16(math.Round(float64(e.cfg.PWMMin) + (v+1)/2*span))
+}
+
+// defaultInt returns fallback when v is zero.
+func defaultInt(v, fallback int) int {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,126 @@
+package nad_nav
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// mavlinkReceiver collects the frames a MAVLink sink sends to a local UDP
+// socket, the way mavlink-standin does.
+type mavlinkReceiver struct {
+	conn   *net.UDPConn
+	parser MAVLinkParser
+}
+
+func newMAVLinkReceiver(t *testing.T) *mavlinkReceiver {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &mavlinkReceiver{conn: conn}
+}
+
+// next returns the next decoded message with id, skipping others such as
+// heartbeats, and checks the sender's ids.
+func (r *mavlinkReceiver) next(t *testing.T, id uint32) MAVLinkMessage {
+	t.Helper()
+	buf := make([]byte, 2048)
+	_ = r.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var pending []MAVLinkFrame
+	for {
+		for len(pending) > 0 {
+			f := pending[0]
+			pending = pending[1:]
+			if f.SystemID != 255 || f.ComponentID != 190 {
+				t.Errorf("frame from %d/%d, want 255/190", f.SystemID, f.ComponentID)
+			}
+			if f.MessageID != id {
+				continue
+			}
+			m, err := DecodeMAVLinkMessage(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return m
+		}
+		n, err := r.conn.Read(buf)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", MAVLinkMessageName(id), err)
+		}
+		pending = r.parser.Parse(buf[:n])
+	}
+}
+
+func newMAVLinkTestSink(t *testing.T, r *mavlinkReceiver, message string) OutputSink {
+	t.Helper()
+	sink, err := NewOutputSink(SinkConfig{
+		Name:    "fc",
+		Type:    "udp",
+		Addr:    r.conn.LocalAddr().String(),
+		Format:  "mavlink",
+		MAVLink: MAVLinkConfig{Message: message},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func TestMAVLinkSinkRCOverrideOverUDP(t *testing.T) {
+	r := newMAVLinkReceiver(t)
+	sink := newMAVLinkTestSink(t, r, "rc_override")
+
+	if hb := r.next(t, MAVLinkMsgHeartbeat).(MAVLinkHeartbeat); hb.Type != mavTypeGCS || hb.Autopilot != mavAutopilotInvalid {
+		t.Errorf("heartbeat %+v", hb)
+	}
+
+	sink.Send(BodyCommand{T: 1, Mode: ModeTrack, Yaw: 0.5, Vertical: -0.5, Forward: 0.25})
+	m := r.next(t, MAVLinkMsgRCChannelsOverride).(MAVLinkRCChannelsOverride)
+	if m.TargetSystem != 1 || m.TargetComponent != 1 {
+		t.Errorf("target %d/%d, want 1/1", m.TargetSystem, m.TargetComponent)
+	}
+	// Default channels: yaw 4, vertical 2, forward 3; the rest released.
+	want := map[int]uint16{4: 1750, 2: 1250, 3: 1250}
+	for i, pwm := range m.Channels {
+		w, ok := want[i+1]
+		if !ok {
+			w = math.MaxUint16
+		}
+		if pwm != w {
+			t.Errorf("channel %d = %d, want %d", i+1, pwm, w)
+		}
+	}
+
+	sink.SetArmed(false)
+	sink.Send(BodyCommand{T: 1.1, Mode: ModeStop, Yaw: 0.5, Vertical: -0.5, Forward: 0.25})
+	m = r.next(t, MAVLinkMsgRCChannelsOverride).(MAVLinkRCChannelsOverride)
+	if m.Channels[3] != 1500 || m.Channels[1] != 1500 || m.Channels[2] != 1000 {
+		t.Errorf("disarmed channels %v, want yaw and vertical centred and forward at pwm_min", m.Channels[:4])
+	}
+}
+
+func TestMAVLinkSinkAttitudeOverUDP(t *testing.T) {
+	r := newMAVLinkReceiver(t)
+	sink := newMAVLinkTestSink(t, r, "attitude")
+
+	sink.Send(BodyCommand{T: 2.5, Mode: ModeTrack, Yaw: 0.5, Vertical: -0.5, Forward: 0.25})
+	m := r.next(t, MAVLinkMsgSetAttitudeTarget).(MAVLinkSetAttitudeTarget)
+	rate := math.Pi / 4 // half of the default 90 deg/s
+	if m.TimeBootMs != 2500 || m.TargetSystem != 1 || m.TargetComponent != 1 {
+		t.Errorf("header fields %+v", m)
+	}
+	if m.TypeMask != AttitudeTargetIgnoreAttitude|AttitudeTargetIgnoreRollRate || m.Q != [4]float32{1, 0, 0, 0} {
+		t.Errorf("type mask %#x q %v", m.TypeMask, m.Q)
+	}
+	if math.Abs(float64(m.BodyYawRate)-rate) > 1e-6 || math.Abs(float64(m.BodyPitchRate)+rate) > 1e-6 || m.BodyRollRate != 0 {
+		t.Errorf("rates roll %v pitch %v yaw %v", m.BodyRollRate, m.BodyPitchRate, m.BodyYawRate)
+	}
+	if math.Abs(float64(m.Thrust)-0.25) > 1e-6 {
+		t.Errorf("thrust %v, want 0.25", m.Thrust)
+	}
+}
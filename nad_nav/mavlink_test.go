@@ -0,0 +1,200 @@
+package nad_nav
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Reference MAVLink v2 frames from system 1, component 1, built from the
+// common.xml definitions independently of the encoder in this package.
+const (
+	// HEARTBEAT seq 0: fixed wing, ArduPilot, armed (base_mode 0x81),
+	// custom_mode 4, ACTIVE.
+	refHeartbeat = "fd0900000001010000000400000001038104037e5a"
+	// HEARTBEAT seq 7 from a ground station (255/190, autopilot INVALID).
+	refGCSHeartbeat = "fd09000007ffbe0000000000000006080004037efa"
+	// SYS_STATUS seq 1: 11.9 V, 12.5 A, 76 %.
+	refSysStatus = "fd1f00000101010100003f0000003f0000003f000000fa007c2ee2040000000000000000000000004cf61a"
+	// ATTITUDE seq 2: boot 5000 ms, roll 0.1, pitch -0.05, yaw 1.5 rad,
+	// rates 0.01, 0.02, -0.03 rad/s.
+	refAttitude = "fd1c00000201011e000088130000cdcccc3dcdcc4cbd0000c03f0ad7233c0ad7a33c8fc2f5bc1268"
+	// BATTERY_STATUS seq 3: id 0, cells 4000/4010/3990 mV, 15 A, 64 %.
+	refBatteryStatus = "fd240000030101930000f4010000ffffffffc409a00faa0f960fffffffffffffffffffffffffffffdc05000001405bcb"
+	// HEARTBEAT seq 4, signed, disarmed (base_mode 0x01).
+	refSignedHeartbeat = "fd09010004010100000004000000010301040395a70015cd5b070000a1b2c3d4e5f6"
+)
+
+// refFrames decodes hex frames and concatenates them.
+func refFrames(t *testing.T, frames ...string) []byte {
+	t.Helper()
+	var out []byte
+	for _, f := range frames {
+		b, err := hex.DecodeString(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
+
+// refStream is a recorded telemetry burst.
+func refStream(t *testing.T) []byte {
+	t.Helper()
+	return refFrames(t, refHeartbeat, refSysStatus, refAttitude, refBatteryStatus)
+}
+
+// frameIDs returns the message ids of frames.
+func frameIDs(frames []MAVLinkFrame) []uint32 {
+	ids := make([]uint32, len(frames))
+	for i, f := range frames {
+		ids[i] = f.MessageID
+	}
+	return ids
+}
+
+func equalIDs(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var refStreamIDs = []uint32{MAVLinkMsgHeartbeat, MAVLinkMsgSysStatus, MAVLinkMsgAttitude, MAVLinkMsgBatteryStatus}
+
+func TestMAVLinkParserDecodesReferenceFrames(t *testing.T) {
+	var p MAVLinkParser
+	frames := p.Parse(refStream(t))
+	if !equalIDs(frameIDs(frames), refStreamIDs) {
+		t.Fatalf("got ids %v, want %v", frameIDs(frames), refStreamIDs)
+	}
+	if p.Frames != 4 || p.Errors != 0 || p.Unknown != 0 {
+		t.Errorf("frames=%d errors=%d unknown=%d, want 4 0 0", p.Frames, p.Errors, p.Unknown)
+	}
+
+	msgs := make([]MAVLinkMessage, len(frames))
+	for i, f := range frames {
+		if f.Seq != uint8(i) || f.SystemID != 1 || f.ComponentID != 1 {
+			t.Errorf("frame %d: seq %d system %d component %d", i, f.Seq, f.SystemID, f.ComponentID)
+		}
+		m, err := DecodeMAVLinkMessage(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[i] = m
+	}
+	if hb := msgs[0].(MAVLinkHeartbeat); hb.BaseMode != 0x81 || hb.CustomMode != 4 || hb.Autopilot != 3 || hb.SystemStatus != mavStateActive {
+		t.Errorf("heartbeat %+v", hb)
+	}
+	if ss := msgs[1].(MAVLinkSysStatus); ss.VoltageBattery != 11900 || ss.CurrentBattery != 1250 || ss.BatteryRemain != 76 || ss.Load != 250 {
+		t.Errorf("sys_status %+v", ss)
+	}
+	if att := msgs[2].(MAVLinkAttitude); att.TimeBootMs != 5000 || att.Roll != 0.1 || att.Pitch != -0.05 || att.Yaw != 1.5 || att.YawSpeed != -0.03 {
+		t.Errorf("attitude %+v", att)
+	}
+	bs := msgs[3].(MAVLinkBatteryStatus)
+	if bs.Voltages[0] != 4000 || bs.Voltages[2] != 3990 || bs.Voltages[3] != 0xFFFF || bs.CurrentBattery != 1500 || bs.BatteryRemain != 64 || bs.Temperature != 2500 {
+		t.Errorf("battery_status %+v", bs)
+	}
+}
+
+func TestMAVLinkEncoderMatchesReference(t *testing.T) {
+	hb := MAVLinkHeartbeat{Type: 1, Autopilot: 3, BaseMode: 0x81, CustomMode: 4, SystemStatus: mavStateActive, MAVLinkVersion: mavlinkProtocolVersion}
+	got, err := EncodeMAVLinkMessage(hb, 0, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := refFrames(t, refHeartbeat); !bytes.Equal(got, want) {
+		t.Errorf("heartbeat\n got %x\nwant %x", got, want)
+	}
+	att := MAVLinkAttitude{TimeBootMs: 5000, Roll: 0.1, Pitch: -0.05, Yaw: 1.5, RollSpeed: 0.01, PitchSpeed: 0.02, YawSpeed: -0.03}
+	got, err = EncodeMAVLinkMessage(att, 2, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := refFrames(t, refAttitude); !bytes.Equal(got, want) {
+		t.Errorf("attitude\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestMAVLinkParserSplitFrames(t *testing.T) {
+	stream := refStream(t)
+	for _, chunk := range []int{1, 3, 7, 11, 64} {
+		var p MAVLinkParser
+		var frames []MAVLinkFrame
+		for i := 0; i < len(stream); i += chunk {
+			frames = append(frames, p.Parse(stream[i:min(i+chunk, len(stream))])...)
+		}
+		if !equalIDs(frameIDs(frames), refStreamIDs) || p.Errors != 0 {
+			t.Errorf("chunk %d: ids %v errors %d", chunk, frameIDs(frames), p.Errors)
+		}
+	}
+}
+
+func TestMAVLinkParserGarbage(t *testing.T) {
+	var stream []byte
+	stream = append(stream, "boot log\r\n"...)
+	stream = append(stream, refFrames(t, refHeartbeat, refSysStatus)...)
+	stream = append(stream, 0x00, 0x55, 0xAA)
+	// A lone magic byte followed by garbage looks like a frame header.
+	stream = append(stream, 0xFD, 0x03, 0x00, 0x00, 0x00, 0x01, 0x01, 0x1E, 0x00, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55)
+	stream = append(stream, refFrames(t, refAttitude, refBatteryStatus)...)
+
+	var p MAVLinkParser
+	frames := p.Parse(stream)
+	if !equalIDs(frameIDs(frames), refStreamIDs) {
+		t.Errorf("got ids %v, want %v", frameIDs(frames), refStreamIDs)
+	}
+	if p.Errors == 0 {
+		t.Error("garbage should count as errors")
+	}
+}
+
+func TestMAVLinkParserBadChecksum(t *testing.T) {
+	bad := refFrames(t, refAttitude)
+	bad[12] ^= 0x01
+	stream := append(refFrames(t, refHeartbeat), bad...)
+	stream = append(stream, refFrames(t, refSysStatus)...)
+
+	var p MAVLinkParser
+	frames := p.Parse(stream)
+	want := []uint32{MAVLinkMsgHeartbeat, MAVLinkMsgSysStatus}
+	if !equalIDs(frameIDs(frames), want) {
+		t.Errorf("got ids %v, want %v", frameIDs(frames), want)
+	}
+	if p.Errors == 0 {
+		t.Error("the corrupt frame should count as an error")
+	}
+	if _, _, err := ParseMAVLinkFrame(bad); err != errMAVLinkChecksum {
+		t.Errorf("ParseMAVLinkFrame: got %v, want a checksum error", err)
+	}
+}
+
+func TestMAVLinkParserSignedFrame(t *testing.T) {
+	stream := refFrames(t, refSignedHeartbeat, refAttitude)
+	f, n, err := ParseMAVLinkFrame(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(refSignedHeartbeat) / 2; n != want {
+		t.Errorf("signed frame used %d bytes, want %d", n, want)
+	}
+	if hb, err := DecodeMAVLinkMessage(f); err != nil || hb.(MAVLinkHeartbeat).BaseMode != 0x01 {
+		t.Errorf("signed heartbeat: %v %+v", err, hb)
+	}
+
+	// The signature must be skipped, not resynced through, when the frame
+	// arrives in pieces.
+	var p MAVLinkParser
+	frames := append(p.Parse(stream[:20]), p.Parse(stream[20:])...)
+	want := []uint32{MAVLinkMsgHeartbeat, MAVLinkMsgAttitude}
+	if !equalIDs(frameIDs(frames), want) || p.Errors != 0 {
+		t.Errorf("got ids %v errors %d, want %v", frameIDs(frames), p.Errors, want)
+	}
+}
@@ -0,0 +1,55 @@
+package nad_nav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nad-navigation/mixer"
+)
+
+// mixedCSVPrefix starts every mixed output record.
+const mixedCSVPrefix = "mix"
+
+// mixedEncoder writes per-actuator PWM values:
+//
+//	mix,seq,heartbeat,t,mode,armed,pwm1,...,pwmN
+//
+// mode is the numeric mode code and armed is 0 or 1. While disarmed
+// the fins sit at their trims and throttle is off.
+type mixedEncoder struct {
+	mixer *mixer.Mixer
+}
+
+// newMixedEncoder builds the mixer for the mixed format.
+func newMixedEncoder(cfg *mixer.Config) (mixedEncoder, error) {
+	if cfg == nil {
+		return mixedEncoder{}, fmt.Errorf("format mixed requires mixer")
+	}
+	m, err := mixer.New(*cfg)
+	if err != nil {
+		return mixedEncoder{}, err
+	}
+	return mixedEncoder{mixer: m}, nil
+}
+
+func (e mixedEncoder) Encode(p OutputPacket) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s,%d,%d,%.4f,%d,%d", mixedCSVPrefix, p.Seq, p.Heartbeat, p.Command.T, int(p.Command.Mode), boolDigit(p.Armed))
+	for _, pwm := range mixCommand(e.mixer, p).PWM {
+		b.WriteByte(',')
+		b.WriteString(strconv.Itoa(int(pwm)))
+	}
+	return []byte(b.String())
+}
+
+func (mixedEncoder) Text() bool { return true }
+
+// mixCommand mixes a packet's command, or returns the neutral output while
+// disarmed.
+func mixCommand(m *mixer.Mixer, p OutputPacket) mixer.Output {
+	if !p.Armed {
+		return m.Neutral()
+	}
+	return m.Mix(mixer.Input{Yaw: p.Command.Yaw, Vertical: p.Command.Vertical, Forward: p.Command.Forward})
+}
@@ -1,44 +1,109 @@
 package nad_nav
 
 import (
+	"errors"
 	"fmt"
-	"net"
 )
 
-// OutputSender sends controller commands over UDP as CSV.
+// OutputSender fans controller commands out to every configured sink.
 type OutputSender struct {
-	conn *net.UDPConn
+	sinks []OutputSink
 }
 
-// NewOutputSender creates a UDP sender for the given address.
-func NewOutputSender(addr string) (*OutputSender, error) {
-	if addr == "" {
-		return &OutputSender{}, nil
-	}
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return nil, err
+// NewOutputSender opens the sinks in cfg.Sinks, preceded by a CSV UDP sink
+// named "udp" when the legacy udp_addr is set.
+func NewOutputSender(cfg OutputConfig) (*OutputSender, error) {
+	return newOutputSender(cfg, RealClock{})
+}
+
+// newOutputSender is NewOutputSender with the sinks' timing on clock.
+func newOutputSender(cfg OutputConfig, clock Clock) (*OutputSender, error) {
+	configs := cfg.Sinks
+	if cfg.UDPAddr != "" {
+		legacy := SinkConfig{
+			Name:    "udp",
+			Type:    "udp",
+			Addr:    cfg.UDPAddr,
+			Version: cfg.Version,
+			Target:  cfg.Target,
+			KeyFile: cfg.KeyFile,
+			KeyID:   cfg.KeyID,
+		}
+		configs = append([]SinkConfig{legacy}, configs...)
 	}
-	conn, err := net.DialUDP("udp", nil, udpAddr)
-	if err != nil {
-		return nil, err
+
+	s := &OutputSender{}
+	seen := map[string]bool{}
+	for _, sinkCfg := range configs {
+		name := sinkCfg.Name
+		if name == "" {
+			name = sinkCfg.Type
+		}
+		if seen[name] {
+			_ = s.Close()
+			return nil, fmt.Errorf("output: duplicate sink %q; give each sink a name", name)
+		}
+		seen[name] = true
+		sink, err := newOutputSink(sinkCfg, clock)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("output: %w", err)
+		}
+		s.sinks = append(s.sinks, sink)
 	}
-	return &OutputSender{conn: conn}, nil
+	return s, nil
 }
 
-// Close releases the UDP socket.
+// Close flushes and closes every sink.
 func (s *OutputSender) Close() error {
-	if s == nil || s.conn == nil {
+	if s == nil {
 		return nil
 	}
-	return s.conn.Close()
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
 }
 
-// Send writes "yaw,vertical,forward,mode" as a CSV payload.
+// Send queues cmd on every sink; it never blocks on I/O.
 func (s *OutputSender) Send(cmd BodyCommand) {
-	if s == nil || s.conn == nil {
+	if s == nil {
 		return
 	}
-	payload := fmt.Sprintf("%.4f,%.4f,%.4f,%s", cmd.Yaw, cmd.Vertical, cmd.Forward, cmd.Mode.String())
-	_, _ = s.conn.Write([]byte(payload))
+	for _, sink := range s.sinks {
+		sink.Send(cmd)
+	}
+}
+
+// SetArmed sets the armed flag of every sink's packets.
+func (s *OutputSender) SetArmed(armed bool) {
+	if s == nil {
+		return
+	}
+	for _, sink := range s.sinks {
+		sink.SetArmed(armed)
+	}
+}
+
+// SendState queues cmd and its target state on every sink.
+func (s *OutputSender) SendState(cmd BodyCommand, st AnchorState) {
+	if s == nil {
+		return
+	}
+	for _, sink := range s.sinks {
+		sink.SendState(cmd, st)
+	}
+}
+
+// Stats returns every sink's statistics in configuration order.
+func (s *OutputSender) Stats() []SinkStats {
+	if s == nil {
+		return nil
+	}
+	stats := make([]SinkStats, len(s.sinks))
+	for i, sink := range s.sinks {
+		stats[i] = sink.Stats()
+	}
+	return stats
 }
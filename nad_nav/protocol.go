@@ -0,0 +1,233 @@
+package nad_nav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// Binary observation packet layout (little endian):
+//
+//	offset  size  field
+//	0       2     magic "ND"
+//	2       1     version (1)
+//	3       1     flags (bit 0: timestamp present)
+//	4       4     frame sequence (uint32, wraps)
+//	8       8     camera timestamp in seconds (float64)
+//	16      1     detection count N
+//	17      16*N  detections: confidence, cx, cy, size (float32 each)
+//	17+16N  4     CRC32 (IEEE) of all preceding bytes
+const (
+	ObservationProtocolVersion = 1
+
+	observationHeaderLen    = 17
+	observationDetectionLen = 16
+	observationTrailerLen   = 4
+	observationFlagHasT     = 0x01
+)
+
+var observationMagic = [2]byte{'N', 'D'}
+
+var (
+	errPacketTruncated = errors.New("truncated packet")
+	errPacketVersion   = errors.New("unsupported packet version")
+	errPacketCRC       = errors.New("packet crc mismatch")
+	errPacketValue     = errors.New("non-finite value in packet")
+)
+
+// Detection is one detector candidate in normalized image coordinates.
+//
+// Width, Height and Class are optional extras carried by the JSON format.
+type Detection struct {
+	Confidence float64
+	CX         float64
+	CY         float64
+	Size       float64
+	Width      float64
+	Height     float64
+	Class      string
+}
+
+// ObservationPacket is one frame of the binary observation protocol.
+type ObservationPacket struct {
+	Seq        uint32
+	T          float64
+	HasT       bool
+	Detections []Detection
+}
+
+// Observation returns the highest-confidence detection as an AnchorObservation.
+func (p ObservationPacket) Observation() AnchorObservation {
+	obs := AnchorObservation{T: p.T}
+	for i, det := range p.Detections {
+		if i == 0 || det.Confidence > obs.Confidence {
+			obs.Detected = true
+			obs.Confidence = det.Confidence
+			obs.CX = det.CX
+			obs.CY = det.CY
+			obs.Size = det.Size
+		}
+	}
+	return obs
+}
+
+// EncodeObservationPacket serializes a packet in the binary observation format.
+func EncodeObservationPacket(p ObservationPacket) ([]byte, error) {
+	if len(p.Detections) > math.MaxUint8 {
+		return nil, fmt.Errorf("too many detections: %d", len(p.Detections))
+	}
+	n := observationHeaderLen + len(p.Detections)*observationDetectionLen + observationTrailerLen
+	buf := make([]byte, n)
+
+	buf[0] = observationMagic[0]
+	buf[1] = observationMagic[1]
+	buf[2] = ObservationProtocolVersion
+	if p.HasT {
+		buf[3] |= observationFlagHasT
+	}
+	binary.LittleEndian.PutUint32(buf[4:], p.Seq)
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(p.T))
+	buf[16] = byte(len(p.Detections))
+
+	off := observationHeaderLen
+	for _, det := range p.Detections {
+		binary.LittleEndian.PutUint32(buf[off:], math.Float32bits(float32(det.Confidence)))
+		binary.LittleEndian.PutUint32(buf[off+4:], math.Float32bits(float32(det.CX)))
+		binary.LittleEndian.PutUint32(buf[off+8:], math.Float32bits(float32(det.CY)))
+		binary.LittleEndian.PutUint32(buf[off+12:], math.Float32bits(float32(det.Size)))
+		off += observationDetectionLen
+	}
+	binary.LittleEndian.PutUint32(buf[off:], crc32.ChecksumIEEE(buf[:off]))
+	return buf, nil
+}
+
+// DecodeObservationPacket parses and verifies a binary observation packet.
+func DecodeObservationPacket(b []byte) (ObservationPacket, error) {
+	if !isBinaryObservation(b) {
+		return ObservationPacket{}, errors.New("bad magic")
+	}
+	if len(b) < observationHeaderLen+observationTrailerLen {
+		return ObservationPacket{}, errPacketTruncated
+	}
+	if b[2] != ObservationProtocolVersion {
+		return ObservationPacket{}, fmt.Errorf("%w %d", errPacketVersion, b[2])
+	}
+	count := int(b[16])
+	n := observationHeaderLen + count*observationDetectionLen + observationTrailerLen
+	if len(b) < n {
+		return ObservationPacket{}, errPacketTruncated
+	}
+	if len(b) > n {
+		return ObservationPacket{}, fmt.Errorf("expected %d bytes, got %d", n, len(b))
+	}
+	body := b[:n-observationTrailerLen]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(b[n-observationTrailerLen:]) {
+		return ObservationPacket{}, errPacketCRC
+	}
+
+	p := ObservationPacket{
+		Seq:  binary.LittleEndian.Uint32(b[4:]),
+		T:    math.Float64frombits(binary.LittleEndian.Uint64(b[8:])),
+		HasT: b[3]&observationFlagHasT != 0,
+	}
+	if p.HasT && !isFinite(p.T) {
+		return ObservationPacket{}, errPacketValue
+	}
+	if !p.HasT {
+		p.T = 0
+	}
+	off := observationHeaderLen
+	for i := 0; i < count; i++ {
+		det := Detection{
+			Confidence: float64(math.Float32frombits(binary.LittleEndian.Uint32(b[off:]))),
+			CX:         float64(math.Float32frombits(binary.LittleEndian.Uint32(b[off+4:]))),
+			CY:         float64(math.Float32frombits(binary.LittleEndian.Uint32(b[off+8:]))),
+			Size:       float64(math.Float32frombits(binary.LittleEndian.Uint32(b[off+12:]))),
+		}
+		if !isFinite(det.Confidence) || !isFinite(det.CX) || !isFinite(det.CY) || !isFinite(det.Size) {
+			return ObservationPacket{}, errPacketValue
+		}
+		p.Detections = append(p.Detections, det)
+		off += observationDetectionLen
+	}
+	return p, nil
+}
+
+// decodeLivePayload auto-detects the payload format and returns a live sample.
+//
+// Binary packets are recognized by their magic and JSON objects by a leading
+// brace; anything else is parsed as CSV.
+func decodeLivePayload(b []byte) (liveSample, error) {
+	if isBinaryObservation(b) {
+		p, err := DecodeObservationPacket(b)
+		if err != nil {
+			return liveSample{}, err
+		}
+		return liveSample{Obs: p.Observation(), HasT: p.HasT, Seq: p.Seq, HasSeq: true}, nil
+	}
+	if isJSONPayload(b) {
+		return parseJSONObservation(b)
+	}
+	obs, hasT, err := parseLiveObservation(b)
+	if err != nil {
+		return liveSample{}, err
+	}
+	return liveSample{Obs: obs, HasT: hasT}, nil
+}
+
+// isBinaryObservation reports whether b starts with the binary packet magic.
+func isBinaryObservation(b []byte) bool {
+	return len(b) >= 2 && b[0] == observationMagic[0] && b[1] == observationMagic[1]
+}
+
+// splitPackets is a bufio.SplitFunc for stream transports carrying a mix of
+// binary packets, signed envelopes and newline-delimited text payloads.
+func splitPackets(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	if isSignedPacket(data) {
+		return splitFramed(data, atEOF, signedHeaderLen, signedPacketLen)
+	}
+	if !isBinaryObservation(data) {
+		if len(data) == 1 && data[0] == observationMagic[0] && !atEOF {
+			return 0, nil, nil
+		}
+		return bufio.ScanLines(data, atEOF)
+	}
+	return splitFramed(data, atEOF, observationHeaderLen, func(header []byte) int {
+		return observationHeaderLen + int(header[16])*observationDetectionLen + observationTrailerLen
+	})
+}
+
+// splitFramed splits one length-prefixed binary packet whose total length is
+// known once headerLen bytes are buffered.
+func splitFramed(data []byte, atEOF bool, headerLen int, packetLen func(header []byte) int) (int, []byte, error) {
+	if len(data) < headerLen {
+		if atEOF {
+			return len(data), nil, errPacketTruncated
+		}
+		return 0, nil, nil
+	}
+	n := packetLen(data)
+	if len(data) < n {
+		if atEOF {
+			return len(data), nil, errPacketTruncated
+		}
+		return 0, nil, nil
+	}
+	// Writers may terminate binary packets with a newline as well.
+	adv := n
+	if len(data) > n && data[n] == '\n' {
+		adv++
+	}
+	return adv, data[:n], nil
+}
+
+// isFinite reports whether v is neither NaN nor infinite.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
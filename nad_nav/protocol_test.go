@@ -0,0 +1,193 @@
+package nad_nav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"testing"
+)
+
+func testObservationPacket() ObservationPacket {
+	return ObservationPacket{
+		Seq:  42,
+		T:    1234.5,
+		HasT: true,
+		Detections: []Detection{
+			{Confidence: 0.5, CX: 0.25, CY: -0.5, Size: 0.0625},
+			{Confidence: 0.75, CX: -0.125, CY: 0.375, Size: 0.03125},
+		},
+	}
+}
+
+// recrc rewrites the trailing CRC after a test edits a packet.
+func recrc(b []byte) []byte {
+	n := len(b) - observationTrailerLen
+	binary.LittleEndian.PutUint32(b[n:], crc32.ChecksumIEEE(b[:n]))
+	return b
+}
+
+func TestObservationPacketRoundTrip(t *testing.T) {
+	for _, p := range []ObservationPacket{
+		testObservationPacket(),
+		{Seq: math.MaxUint32},
+	} {
+		b, err := EncodeObservationPacket(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := observationHeaderLen + len(p.Detections)*observationDetectionLen + observationTrailerLen; len(b) != want {
+			t.Errorf("packet is %d bytes, want %d", len(b), want)
+		}
+		got, err := DecodeObservationPacket(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Seq != p.Seq || got.T != p.T || got.HasT != p.HasT || len(got.Detections) != len(p.Detections) {
+			t.Fatalf("got %+v, want %+v", got, p)
+		}
+		for i := range p.Detections {
+			if got.Detections[i] != p.Detections[i] {
+				t.Errorf("detection %d: got %+v, want %+v", i, got.Detections[i], p.Detections[i])
+			}
+		}
+	}
+
+	sample, err := decodeLivePayload(mustEncode(t, testObservationPacket()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sample.HasSeq || sample.Seq != 42 || !sample.HasT || sample.Obs.Confidence != 0.75 || sample.Obs.CX != -0.125 {
+		t.Errorf("live sample %+v, want the highest-confidence detection", sample)
+	}
+
+	if _, err := EncodeObservationPacket(ObservationPacket{Detections: make([]Detection, 256)}); err == nil {
+		t.Error("256 detections should not encode")
+	}
+}
+
+func mustEncode(t *testing.T, p ObservationPacket) []byte {
+	t.Helper()
+	b, err := EncodeObservationPacket(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeObservationPacketErrors(t *testing.T) {
+	good := mustEncode(t, testObservationPacket())
+	edit := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	tests := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"crc mismatch", edit(func(b []byte) []byte { b[20] ^= 0x10; return b }), errPacketCRC},
+		{"corrupt crc", edit(func(b []byte) []byte { b[len(b)-1] ^= 0xFF; return b }), errPacketCRC},
+		{"truncated header", good[:10], errPacketTruncated},
+		{"truncated detections", good[:len(good)-5], errPacketTruncated},
+		{"wrong version", edit(func(b []byte) []byte { b[2] = 2; return recrc(b) }), errPacketVersion},
+		{"nan timestamp", edit(func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[8:], math.Float64bits(math.NaN()))
+			return recrc(b)
+		}), errPacketValue},
+		{"inf cx", edit(func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[observationHeaderLen+4:], math.Float32bits(float32(math.Inf(1))))
+			return recrc(b)
+		}), errPacketValue},
+		{"nan confidence", mustEncode(t, ObservationPacket{Detections: []Detection{{Confidence: math.NaN()}}}), errPacketValue},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeObservationPacket(tt.packet); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := DecodeObservationPacket(append(good, 0)); err == nil {
+		t.Error("trailing bytes should be rejected")
+	}
+
+	// Without the timestamp flag the time field is ignored, even if not finite.
+	noT := edit(func(b []byte) []byte {
+		b[3] = 0
+		binary.LittleEndian.PutUint64(b[8:], math.Float64bits(math.Inf(1)))
+		return recrc(b)
+	})
+	if p, err := DecodeObservationPacket(noT); err != nil || p.HasT || p.T != 0 {
+		t.Errorf("packet without t: %+v %v", p, err)
+	}
+}
+
+func TestSplitPacketsMixedStream(t *testing.T) {
+	binary1 := mustEncode(t, testObservationPacket())
+	binary2 := mustEncode(t, ObservationPacket{Seq: 43, Detections: []Detection{{Confidence: 0.9, CX: 0.1}}})
+	var stream bytes.Buffer
+	stream.WriteString("1.0,1,0.9,0.1,0.2,0.01\n")
+	stream.Write(binary1)
+	stream.WriteString(`{"t": 2.0, "frame": 7, "confidence": 0.8, "cx": 0.3, "cy": 0.4, "size": 0.02}` + "\n")
+	stream.Write(binary2)
+	stream.WriteString("\n") // binary packets may be newline-terminated
+	stream.WriteString("0,0.0,0,0,0\n")
+
+	// Feed the stream a few bytes at a time so packets straddle reads.
+	scanner := bufio.NewScanner(&chunkReader{data: stream.Bytes(), chunk: 3})
+	scanner.Split(splitPackets)
+	var samples []liveSample
+	for scanner.Scan() {
+		s, err := decodeLivePayload(scanner.Bytes())
+		if err != nil {
+			t.Fatalf("payload %d %q: %v", len(samples), scanner.Bytes(), err)
+		}
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 5 {
+		t.Fatalf("got %d payloads, want 5", len(samples))
+	}
+	if s := samples[0]; s.HasSeq || !s.HasT || s.Obs.T != 1 || s.Obs.CX != 0.1 {
+		t.Errorf("csv: %+v", s)
+	}
+	if s := samples[1]; !s.HasSeq || s.Seq != 42 {
+		t.Errorf("binary: %+v", s)
+	}
+	if s := samples[2]; !s.HasSeq || s.Seq != 7 || s.Obs.CX != 0.3 {
+		t.Errorf("json: %+v", s)
+	}
+	if s := samples[3]; !s.HasSeq || s.Seq != 43 || s.HasT {
+		t.Errorf("binary without t: %+v", s)
+	}
+	if s := samples[4]; s.HasT || s.Obs.Detected {
+		t.Errorf("csv without t: %+v", s)
+	}
+
+	// A packet cut off by EOF is an error, not a hang.
+	scanner = bufio.NewScanner(bytes.NewReader(binary1[:len(binary1)-3]))
+	scanner.Split(splitPackets)
+	for scanner.Scan() {
+	}
+	if !errors.Is(scanner.Err(), errPacketTruncated) {
+		t.Errorf("truncated stream: got %v", scanner.Err())
+	}
+}
+
+// chunkReader returns data at most chunk bytes per Read.
+type chunkReader struct {
+	data  []byte
+	chunk int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.chunk)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
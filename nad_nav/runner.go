@@ -0,0 +1,458 @@
+package nad_nav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CommandSink receives every controller command.
+type CommandSink interface {
+	Send(cmd BodyCommand)
+	Close() error
+}
+
+// StateSink is a CommandSink that also accepts the target state behind each
+// command. The loop calls SendState instead of Send when a sink implements it.
+type StateSink interface {
+	CommandSink
+	SendState(cmd BodyCommand, st AnchorState)
+}
+
+// ArmedSink is a CommandSink that reports whether actuators may move. The
+// loop disarms it for the shutdown burst and, with a vehicle link, follows
+// the vehicle's armed state. Sinks start armed.
+type ArmedSink interface {
+	CommandSink
+	SetArmed(armed bool)
+}
+
+// RunnerOption customizes a Runner built by NewRunner.
+type RunnerOption func(*runnerOptions)
+
+type runnerOptions struct {
+	sources      map[string]ObservationSource
+	trackers     map[string]*AnchorTracker
+	sink         CommandSink
+	clock        Clock
+	controller   *DroneController
+	telemetry    *VizMetrics
+	hasTelemetry bool
+}
+
+// WithSource replaces the first input's observation source.
+func WithSource(src ObservationSource) RunnerOption {
+	return WithInputSource("", src)
+}
+
+// WithInputSource replaces the observation source of the named input.
+func WithInputSource(name string, src ObservationSource) RunnerOption {
+	return func(o *runnerOptions) { o.sources[name] = src }
+}
+
+// WithTracker replaces the first input's tracker.
+func WithTracker(tracker *AnchorTracker) RunnerOption {
+	return WithInputTracker("", tracker)
+}
+
+// WithInputTracker replaces the tracker of the named input.
+func WithInputTracker(name string, tracker *AnchorTracker) RunnerOption {
+	return func(o *runnerOptions) { o.trackers[name] = tracker }
+}
+
+// WithOutput replaces the sinks built from cfg.Output.
+func WithOutput(sink CommandSink) RunnerOption {
+	return func(o *runnerOptions) { o.sink = sink }
+}
+
+// WithClock replaces the system clock for the loop, file replay pacing,
+// receive timestamps, controller timers and the output sinks' keepalives,
+// schedules and ack timing. The runner never advances a SimClock itself; on
+// one, Stop sends the safe command burst back to back instead of spacing it
+// by shutdown.interval_seconds.
+func WithClock(clock Clock) RunnerOption {
+	return func(o *runnerOptions) { o.clock = clock }
+}
+
+// WithController replaces the controller built from cfg.Controller. The
+// runner sets its clock.
+func WithController(controller *DroneController) RunnerOption {
+	return func(o *runnerOptions) { o.controller = controller }
+}
+
+// WithTelemetry publishes to the given metrics instead of starting the viz
+// server from cfg.Viz. A nil value disables telemetry.
+func WithTelemetry(viz *VizMetrics) RunnerOption {
+	return func(o *runnerOptions) {
+		o.telemetry = viz
+		o.hasTelemetry = true
+	}
+}
+
+// RunnerSnapshot is the most recent step of a Runner.
+type RunnerSnapshot struct {
+	Steps       uint64
+	Camera      string
+	Observation AnchorObservation
+	State       AnchorState
+	Command     BodyCommand
+	Vehicle     VehicleState
+}
+
+// Runner is the live control loop as an embeddable component.
+//
+// Sources start on the first Start or Step. Stop sends the safe command
+// burst and closes the sources, attitude listener, sink and any viz server the
+// runner started itself; injected sources and sinks are closed too.
+type Runner struct {
+	loop    *liveLoop
+	closers []func() error
+
+	mu             sync.Mutex
+	sourcesStarted bool
+	running        bool
+	cancel         context.CancelCauseFunc
+	done           chan struct{}
+	reason         string
+
+	stopOnce sync.Once
+	stopped  bool
+	summary  RunSummary
+}
+
+var (
+	errRunnerRunning = errors.New("runner is running")
+	errRunnerStopped = errors.New("runner is stopped")
+)
+
+// NewRunner builds the live pipeline described by cfg.
+func NewRunner(cfg AppConfig, opts ...RunnerOption) (*Runner, error) {
+	o := runnerOptions{
+		sources:  map[string]ObservationSource{},
+		trackers: map[string]*AnchorTracker{},
+		clock:    RealClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if cfg.Hz <= 0 {
+		return nil, fmt.Errorf("hz must be > 0")
+	}
+	switch cfg.Loop.Mode {
+	case "", "fixed", "event":
+	default:
+		return nil, fmt.Errorf("unknown loop.mode %q", cfg.Loop.Mode)
+	}
+	switch cfg.Loop.Overrun {
+	case "", "skip", "catch_up":
+	default:
+		return nil, fmt.Errorf("unknown loop.overrun %q", cfg.Loop.Overrun)
+	}
+	switch cfg.Controller.Units {
+	case "", "normalized", "angles":
+	default:
+		return nil, fmt.Errorf("unknown controller.units %q", cfg.Controller.Units)
+	}
+
+	r := &Runner{}
+	built := false
+	defer func() {
+		if !built {
+			r.close()
+		}
+	}()
+
+	t0 := o.clock.Now()
+	clock := sinceSeconds(o.clock, t0)
+	notify := make(chan struct{}, 1)
+
+	configs := inputConfigs(cfg)
+	var inputs []*inputChannel
+	var names []string
+	cameras := map[string]*CameraModel{}
+	seen := map[string]bool{}
+	for _, inCfg := range configs {
+		if inCfg.Name == "" {
+			return nil, fmt.Errorf("inputs: every input needs a name")
+		}
+		if seen[inCfg.Name] {
+			return nil, fmt.Errorf("inputs: duplicate input %q", inCfg.Name)
+		}
+		seen[inCfg.Name] = true
+		names = append(names, inCfg.Name)
+	}
+	for _, m := range []map[string]bool{keys(o.sources), keys(o.trackers)} {
+		for name := range m {
+			if name != "" && !seen[name] {
+				return nil, fmt.Errorf("runner option: unknown input %q", name)
+			}
+		}
+	}
+
+	for i, inCfg := range configs {
+		camera, err := NewCameraModel(inCfg.Camera)
+		if err != nil {
+			return nil, fmt.Errorf("input %q: %w", inCfg.Name, err)
+		}
+		cameras[inCfg.Name] = camera
+		if cfg.Controller.Units == "angles" && camera == nil {
+			return nil, fmt.Errorf("input %q: controller.units=angles requires camera.hfov_deg", inCfg.Name)
+		}
+		if (cfg.Attitude.UDPAddr != "" || cfg.Vehicle.Attitude) && camera == nil {
+			return nil, fmt.Errorf("input %q: attitude compensation requires camera.hfov_deg", inCfg.Name)
+		}
+
+		source := optionFor(o.sources, inCfg.Name, i)
+		if source == nil {
+			source, err = newObservationSource(inCfg.Live, o.clock)
+			if err != nil {
+				return nil, fmt.Errorf("input %q: %w", inCfg.Name, err)
+			}
+		}
+		r.closers = append(r.closers, source.Close)
+		auth, err := NewAuthenticator(inCfg.Live.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("input %q: live.auth: %w", inCfg.Name, err)
+		}
+		if auth.HasAllowlist() {
+			filterer, ok := source.(addrFilterer)
+			if !ok || inCfg.Live.Source == "unix" {
+				return nil, fmt.Errorf("input %q: live.auth.allow_from requires a udp or tcp source", inCfg.Name)
+			}
+			filterer.SetAllow(auth.AllowAddr)
+		}
+		tracker := optionFor(o.trackers, inCfg.Name, i)
+		if tracker == nil {
+			tracker = NewAnchorTracker(cfg.Tracker)
+		}
+		store := newLiveStore(inCfg.Live, notify)
+		inputs = append(inputs, &inputChannel{
+			name:          inCfg.Name,
+			cfg:           inCfg.Live,
+			source:        source,
+			handle:        auth.Handler(store.handler(clock)),
+			store:         store,
+			auth:          auth,
+			camera:        camera,
+			latency:       NewLatencyEstimator(cfg.Latency, t0),
+			tracker:       tracker,
+			attitudeDelay: cfg.Attitude.DelaySeconds,
+		})
+	}
+
+	fusion, err := NewFusion(cfg.Fusion, names, cameras)
+	if err != nil {
+		return nil, err
+	}
+
+	derot, err := StartDerotator(cfg.Attitude, inputs[0].camera, clock)
+	if err != nil {
+		return nil, err
+	}
+	r.closers = append(r.closers, derot.Close)
+	var onAttitude func(AttitudeSample)
+	if cfg.Vehicle.Attitude {
+		if derot == nil {
+			derot, err = NewDerotator(cfg.Attitude, inputs[0].camera)
+			if err != nil {
+				return nil, err
+			}
+		}
+		onAttitude = derot.Add
+	}
+	for _, in := range inputs {
+		in.derot = derot.ForCamera(in.camera)
+	}
+
+	vehicle, err := StartVehicleLink(cfg.Vehicle, o.clock, clock, onAttitude)
+	if err != nil {
+		return nil, err
+	}
+	r.closers = append(r.closers, vehicle.Close)
+
+	sink := o.sink
+	if sink == nil {
+		sender, err := newOutputSender(cfg.Output, o.clock)
+		if err != nil {
+			return nil, err
+		}
+		sink = sender
+	}
+	r.closers = append(r.closers, sink.Close)
+
+	viz := o.telemetry
+	if !o.hasTelemetry {
+		viz, err = StartViz(cfg.Viz)
+		if err != nil {
+			return nil, err
+		}
+		r.closers = append(r.closers, viz.Close)
+	}
+
+	controller := o.controller
+	if controller == nil {
+		controller = NewDroneController(cfg.Controller)
+	}
+	controller.SetClock(o.clock, t0)
+
+	r.loop = &liveLoop{
+		cfg:        cfg,
+		clock:      o.clock,
+		t0:         t0,
+		inputs:     inputs,
+		fusion:     fusion,
+		notify:     notify,
+		controller: controller,
+		sender:     sink,
+		vehicle:    vehicle,
+		viz:        viz,
+		timing:     newLoopTiming(),
+		lastWall:   t0,
+		modeSteps:  map[Mode]uint64{},
+	}
+	built = true
+	return r, nil
+}
+
+// Start starts the sources and runs the loop in the background until ctx is
+// done or Stop is called.
+func (r *Runner) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return errRunnerStopped
+	}
+	if r.running {
+		return errRunnerRunning
+	}
+	if err := r.startSources(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	r.running = true
+	go func() {
+		defer close(r.done)
+		if r.loop.cfg.Loop.Mode == "event" {
+			r.loop.runEvent(ctx)
+		} else {
+			r.loop.runFixed(ctx)
+		}
+		r.mu.Lock()
+		r.reason = context.Cause(ctx).Error()
+		r.mu.Unlock()
+	}()
+	return nil
+}
+
+// Done returns a channel closed when the background loop exits. It is nil
+// before Start.
+func (r *Runner) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// Stop ends the loop, sends the safe command burst, releases resources and
+// returns the run summary. Later calls return the same summary.
+func (r *Runner) Stop() RunSummary {
+	r.stopOnce.Do(func() {
+		r.mu.Lock()
+		cancel, done := r.cancel, r.done
+		r.stopped = true
+		r.mu.Unlock()
+
+		reason := "stopped"
+		if cancel != nil {
+			cancel(errors.New("stopped"))
+			<-done
+			r.mu.Lock()
+			reason = r.reason
+			r.mu.Unlock()
+		}
+		safe := r.loop.sendSafeBurst()
+		r.summary = r.loop.summary(reason, safe)
+		r.close()
+		// Sink counters are final once Close has flushed the queues.
+		r.summary.Outputs = r.loop.sinkStats()
+	})
+	return r.summary
+}
+
+// Step runs a single loop iteration at the current clock time. It is meant
+// for tests and tools driving the loop themselves, and fails while the
+// background loop is running.
+func (r *Runner) Step() (BodyCommand, error) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return BodyCommand{}, errRunnerStopped
+	}
+	if r.running {
+		r.mu.Unlock()
+		return BodyCommand{}, errRunnerRunning
+	}
+	err := r.startSources()
+	r.mu.Unlock()
+	if err != nil {
+		return BodyCommand{}, err
+	}
+	return r.loop.step(r.loop.clock.Now()), nil
+}
+
+// Snapshot returns the latest observation, state and command.
+func (r *Runner) Snapshot() RunnerSnapshot {
+	return r.loop.getSnapshot()
+}
+
+// startSources starts every input's source once. Callers must hold r.mu.
+func (r *Runner) startSources() error {
+	if r.sourcesStarted {
+		return nil
+	}
+	for _, in := range r.loop.inputs {
+		if err := in.source.Start(in.handle); err != nil {
+			return fmt.Errorf("input %q: %w", in.name, err)
+		}
+	}
+	r.sourcesStarted = true
+	return nil
+}
+
+// close releases everything the runner opened, newest first.
+func (r *Runner) close() {
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		_ = r.closers[i]()
+	}
+	r.closers = nil
+	_ = os.Stdout.Sync()
+}
+
+// optionFor returns the injected value for the named input; the "" key
+// addresses the first input.
+func optionFor[T any](m map[string]T, name string, index int) T {
+	if v, ok := m[name]; ok {
+		return v
+	}
+	var zero T
+	if index == 0 {
+		if v, ok := m[""]; ok {
+			return v
+		}
+	}
+	return zero
+}
+
+// keys returns the key set of m.
+func keys[T any](m map[string]T) map[string]bool {
+	out := make(map[string]bool, len(m))
+	for k := range m {
+		out[k] = true
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package nad_nav
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idleSource is an observation source that never delivers a packet.
+type idleSource struct{}
+
+func (idleSource) Start(PacketHandler) error { return nil }
+func (idleSource) Close() error              { return nil }
+
+// recordSink keeps every command it is sent.
+type recordSink struct {
+	mu   sync.Mutex
+	cmds []BodyCommand
+}
+
+func (s *recordSink) Send(cmd BodyCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = append(s.cmds, cmd)
+}
+
+func (s *recordSink) Close() error { return nil }
+
+func (s *recordSink) commands() []BodyCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]BodyCommand(nil), s.cmds...)
+}
+
+// newSimRunner builds a runner stepped on a SimClock with an idle source and
+// a recording sink.
+func newSimRunner(t *testing.T, cfg AppConfig) (*Runner, *SimClock, *recordSink) {
+	t.Helper()
+	clock := NewSimClock(time.Unix(1000, 0))
+	sink := &recordSink{}
+	r, err := NewRunner(cfg, WithSource(idleSource{}), WithOutput(sink), WithClock(clock), WithTelemetry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, clock, sink
+}
+
+func TestRunnerStepFlyStraightRamp(t *testing.T) {
+	override := ModeFlyStraight
+	var cfg AppConfig
+	cfg.Hz = 4
+	cfg.Controller = ControllerConfig{
+		ModeOverride:        &override,
+		FlyStraightSeconds:  2,
+		FlyStraightForward:  0.4,
+		FlyStraightYaw:      0.1,
+		FlyStraightVertical: -0.2,
+		FlyStraightAfter:    ModeStop,
+	}
+	r, clock, _ := newSimRunner(t, cfg)
+	defer r.Stop()
+
+	// Forward ramps up over the first half of fly_straight_seconds and back
+	// down over the second, then the after mode takes over.
+	want := []struct {
+		mode    Mode
+		forward float64
+	}{
+		{ModeFlyStraight, 0},
+		{ModeFlyStraight, 0.1},
+		{ModeFlyStraight, 0.2},
+		{ModeFlyStraight, 0.3},
+		{ModeFlyStraight, 0.4},
+		{ModeFlyStraight, 0.3},
+		{ModeFlyStraight, 0.2},
+		{ModeFlyStraight, 0.1},
+		{ModeFlyStraight, 0},
+		{ModeStop, 0},
+	}
+	for i, w := range want {
+		cmd, err := r.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantT := float64(i) * 0.25; math.Abs(cmd.T-wantT) > 1e-9 {
+			t.Errorf("step %d: t = %v, want %v", i, cmd.T, wantT)
+		}
+		if cmd.Mode != w.mode || math.Abs(cmd.Forward-w.forward) > 1e-9 {
+			t.Errorf("step %d: mode %s forward %v, want %s %v", i, cmd.Mode, cmd.Forward, w.mode, w.forward)
+		}
+		if w.mode == ModeFlyStraight && (cmd.Yaw != 0.1 || cmd.Vertical != -0.2) {
+			t.Errorf("step %d: yaw %v vertical %v, want 0.1 -0.2", i, cmd.Yaw, cmd.Vertical)
+		}
+		clock.Advance(250 * time.Millisecond)
+	}
+}
+
+func TestRunnerStopOnSimClock(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 10
+	cfg.Shutdown = ShutdownConfig{Count: 3, IntervalSeconds: 0.05}
+	r, clock, sink := newSimRunner(t, cfg)
+	if _, err := r.Step(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(250 * time.Millisecond)
+	before := clock.Now()
+
+	done := make(chan RunSummary)
+	go func() { done <- r.Stop() }()
+	var summary RunSummary
+	select {
+	case summary = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return on a SimClock")
+	}
+	if summary.SafeCommands != 3 {
+		t.Errorf("safe commands = %d, want 3", summary.SafeCommands)
+	}
+	cmds := sink.commands()
+	if len(cmds) != 4 {
+		t.Fatalf("got %d commands, want 4", len(cmds))
+	}
+	// The burst goes out back to back without moving the harness's clock.
+	for i, cmd := range cmds[1:] {
+		if cmd.Mode != ModeStop || math.Abs(cmd.T-0.25) > 1e-9 {
+			t.Errorf("safe command %d: mode %s t %v, want STOP 0.25", i, cmd.Mode, cmd.T)
+		}
+	}
+	if !clock.Now().Equal(before) {
+		t.Errorf("Stop moved the clock from %v to %v", before, clock.Now())
+	}
+}
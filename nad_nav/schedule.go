@@ -0,0 +1,106 @@
+package nad_nav
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScheduleConfig decouples a sink's send rate from the control loop. With hz
+// set, the sink sends on its own clock at hz and resamples the loop's
+// commands:
+//
+//   - hold (default) repeats the latest command;
+//   - linear interpolates between the two latest commands, rendering the
+//     command trajectory one loop period behind;
+//   - extrapolate projects the latest command along its slope for up to
+//     max_extrapolate_seconds (default 0.1), then holds.
+//
+// Packets keep the loop command's time and heartbeat; version 2 packets add
+// the sample offset from that time. Mode changes are sent at once and are
+// never interpolated across. Like keepalives, scheduled packets stop a
+// second after the loop's last command.
+type ScheduleConfig struct {
+	Hz                    float64 `json:"hz"`
+	Resample              string  `json:"resample"`
+	MaxExtrapolateSeconds float64 `json:"max_extrapolate_seconds"`
+}
+
+// resampler holds the latest loop commands of a scheduled sink. It is not
+// safe for concurrent use.
+type resampler struct {
+	method  string
+	horizon float64 // extrapolation limit, seconds
+	period  time.Duration
+
+	prev     BodyCommand
+	hasPrev  bool
+	latest   BodyCommand
+	latestAt time.Time
+}
+
+// newResampler validates cfg; it returns nil when scheduling is disabled.
+func newResampler(cfg ScheduleConfig) (*resampler, error) {
+	switch {
+	case cfg.Hz < 0:
+		return nil, fmt.Errorf("schedule hz must be >= 0")
+	case cfg.MaxExtrapolateSeconds < 0:
+		return nil, fmt.Errorf("schedule max_extrapolate_seconds must be >= 0")
+	case cfg.Hz == 0 && (cfg.Resample != "" || cfg.MaxExtrapolateSeconds != 0):
+		return nil, fmt.Errorf("schedule requires hz")
+	case cfg.Hz == 0:
+		return nil, nil
+	}
+	switch cfg.Resample {
+	case "":
+		cfg.Resample = "hold"
+	case "hold", "linear", "extrapolate":
+	default:
+		return nil, fmt.Errorf("unknown schedule resample %q", cfg.Resample)
+	}
+	r := &resampler{
+		method:  cfg.Resample,
+		horizon: cfg.MaxExtrapolateSeconds,
+		period:  time.Duration(float64(time.Second) / cfg.Hz),
+	}
+	if r.horizon == 0 {
+		r.horizon = 0.1
+	}
+	return r, nil
+}
+
+// add records a loop command received at. It reports whether cmd starts a
+// new mode, including the first command, and should be sent at once.
+func (r *resampler) add(cmd BodyCommand, at time.Time) bool {
+	changed := r.latestAt.IsZero() || cmd.Mode != r.latest.Mode
+	r.prev, r.hasPrev = r.latest, !changed
+	r.latest, r.latestAt = cmd, at
+	return changed
+}
+
+// sample returns the command to send at now and its offset in seconds from
+// the latest loop command's time. The command keeps that time and mode.
+func (r *resampler) sample(now time.Time) (BodyCommand, float64) {
+	dt := r.latest.T - r.prev.T
+	if r.method == "hold" || !r.hasPrev || dt <= 0 {
+		return r.latest, 0
+	}
+	elapsed := now.Sub(r.latestAt).Seconds()
+	switch r.method {
+	case "linear":
+		frac := clamp(elapsed/dt, 0, 1)
+		return lerpCommand(r.prev, r.latest, frac), (frac - 1) * dt
+	default:
+		h := clamp(elapsed, 0, r.horizon)
+		return lerpCommand(r.prev, r.latest, 1+h/dt), h
+	}
+}
+
+// lerpCommand blends a and b's controls by frac (beyond 1 extrapolates) and
+// clamps them to their ranges. The result keeps b's time and mode.
+func lerpCommand(a, b BodyCommand, frac float64) BodyCommand {
+	out := b
+	out.Yaw = clamp(a.Yaw+(b.Yaw-a.Yaw)*frac, -1, 1)
+	out.Vertical = clamp(a.Vertical+(b.Vertical-a.Vertical)*frac, -1, 1)
+	out.Forward = clamp(a.Forward+(b.Forward-a.Forward)*frac, 0, 1)
+	return out
+}
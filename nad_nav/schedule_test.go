@@ -0,0 +1,146 @@
+package nad_nav
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResamplerSample(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	tests := []struct {
+		method string
+		ms     int
+		yaw    float64
+		offset float64
+	}{
+		{"hold", 100, 0.5, 0},
+		{"hold", 150, 0.5, 0},
+		{"linear", 100, 0, -0.1},
+		{"linear", 125, 0.125, -0.075},
+		{"linear", 150, 0.25, -0.05},
+		{"linear", 200, 0.5, 0},
+		{"linear", 300, 0.5, 0},
+		{"extrapolate", 100, 0.5, 0},
+		{"extrapolate", 110, 0.55, 0.01},
+		{"extrapolate", 120, 0.6, 0.02},
+		{"extrapolate", 300, 0.6, 0.02},
+	}
+	for _, tt := range tests {
+		r, err := newResampler(ScheduleConfig{Hz: 50, Resample: tt.method, MaxExtrapolateSeconds: 0.02})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.add(BodyCommand{T: 1, Mode: ModeTrack, Yaw: 0, Forward: 0.3}, at(0))
+		r.add(BodyCommand{T: 1.1, Mode: ModeTrack, Yaw: 0.5, Forward: 0.3}, at(100))
+		cmd, offset := r.sample(at(tt.ms))
+		if math.Abs(cmd.Yaw-tt.yaw) > 1e-9 || math.Abs(offset-tt.offset) > 1e-9 {
+			t.Errorf("%s at %dms: yaw %v offset %v, want %v %v", tt.method, tt.ms, cmd.Yaw, offset, tt.yaw, tt.offset)
+		}
+		if cmd.T != 1.1 || cmd.Mode != ModeTrack || cmd.Forward != 0.3 {
+			t.Errorf("%s at %dms: got %+v, want the latest command's t, mode and forward", tt.method, tt.ms, cmd)
+		}
+	}
+}
+
+func TestResamplerModeChange(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	r, err := newResampler(ScheduleConfig{Hz: 50, Resample: "linear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.add(BodyCommand{T: 1, Mode: ModeTrack, Yaw: 1}, t0) {
+		t.Error("first command should be sent at once")
+	}
+	if r.add(BodyCommand{T: 1.1, Mode: ModeTrack, Yaw: 0.5}, t0.Add(100*time.Millisecond)) {
+		t.Error("same-mode command should wait for the schedule")
+	}
+	if !r.add(BodyCommand{T: 1.2, Mode: ModeStop, Yaw: -1}, t0.Add(200*time.Millisecond)) {
+		t.Error("mode change should be sent at once")
+	}
+	// Interpolating back across the mode change would bring the old mode's
+	// command back.
+	if cmd, offset := r.sample(t0.Add(210 * time.Millisecond)); cmd.Yaw != -1 || offset != 0 {
+		t.Errorf("after a mode change got yaw %v offset %v, want the held command", cmd.Yaw, offset)
+	}
+}
+
+func TestNewResamplerErrors(t *testing.T) {
+	for _, cfg := range []ScheduleConfig{
+		{Hz: -1},
+		{Resample: "linear"},
+		{Hz: 50, Resample: "cubic"},
+		{Hz: 50, MaxExtrapolateSeconds: -1},
+	} {
+		if _, err := newResampler(cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+	if r, err := newResampler(ScheduleConfig{}); r != nil || err != nil {
+		t.Errorf("zero config: got %v %v, want a nil resampler", r, err)
+	}
+}
+
+// waitScheduled waits until the sink has queued n scheduled packets.
+func waitScheduled(t *testing.T, sink OutputSink, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for sink.Stats().Scheduled < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduled %d packets, want %d", sink.Stats().Scheduled, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduledSinkOnSimClock(t *testing.T) {
+	clock := NewSimClock(time.Unix(1000, 0))
+	path := filepath.Join(t.TempDir(), "out.csv")
+	sink, err := newOutputSink(SinkConfig{
+		Name:     "fc",
+		Type:     "file",
+		Addr:     path,
+		Version:  OutputProtocolVersion,
+		Schedule: ScheduleConfig{Hz: 50, Resample: "linear"},
+	}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 25 Hz loop resampled at 50 Hz.
+	sink.Send(BodyCommand{T: 0, Mode: ModeTrack, Yaw: 0})
+	clock.Advance(20 * time.Millisecond)
+	waitScheduled(t, sink, 1)
+	clock.Advance(20 * time.Millisecond)
+	waitScheduled(t, sink, 2)
+	sink.Send(BodyCommand{T: 0.04, Mode: ModeTrack, Yaw: 0.4})
+	clock.Advance(20 * time.Millisecond)
+	waitScheduled(t, sink, 3)
+	clock.Advance(20 * time.Millisecond)
+	waitScheduled(t, sink, 4)
+	sink.Send(BodyCommand{T: 0.08, Mode: ModeStop, Yaw: 0.4})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"v2,1,1,0.0000,2,0.0000,0.0000,0.0000,1,0.0000",
+		"v2,2,1,0.0000,2,0.0000,0.0000,0.0000,1,0.0000",
+		"v2,3,1,0.0000,2,0.0000,0.0000,0.0000,1,0.0000",
+		"v2,4,2,0.0400,2,0.2000,0.0000,0.0000,1,-0.0200",
+		"v2,5,2,0.0400,2,0.4000,0.0000,0.0000,1,0.0000",
+		"v2,6,3,0.0800,7,0.4000,0.0000,0.0000,1,0.0000",
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package nad_nav
+
+import (
+	"errors"
+	"os"
+)
+
+// openSerial is only implemented on Linux.
+func openSerial(path string, baud int) (*os.File, error) {
+	return nil, errors.New("serial devices are only supported on linux")
+}
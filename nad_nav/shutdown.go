@@ -0,0 +1,165 @@
+package nad_nav
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ShutdownConfig controls the safe command burst sent when the live loop stops.
+//
+// count commands (default 5) are sent interval_seconds apart (default 0.02)
+// with zero forward, neutral yaw and vertical, and mode (default STOP).
+type ShutdownConfig struct {
+	Count           int     `json:"count"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	Mode            Mode    `json:"mode"`
+}
+
+// InputSummary reports traffic on one input over a run.
+type InputSummary struct {
+	Name         string
+	Packets      uint64
+	Lost         uint64
+	ParseErrors  uint64
+	AuthRejected uint64
+}
+
+// RunSummary reports what a live run did.
+type RunSummary struct {
+	Reason       string
+	Duration     time.Duration
+	Steps        uint64
+	ModeSteps    map[Mode]uint64
+	LastMode     Mode
+	SafeCommands int
+	Timing       LoopTimingStats
+	Inputs       []InputSummary
+	Outputs      []SinkStats
+}
+
+// String formats the summary for the console.
+func (s RunSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "run stopped (%s) after %s: steps=%d last_mode=%s safe_commands=%d",
+		s.Reason, s.Duration.Round(time.Millisecond), s.Steps, s.LastMode, s.SafeCommands)
+
+	modes := make([]Mode, 0, len(s.ModeSteps))
+	for mode := range s.ModeSteps {
+		modes = append(modes, mode)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+	var parts []string
+	for _, mode := range modes {
+		parts = append(parts, fmt.Sprintf("%s=%d", mode, s.ModeSteps[mode]))
+	}
+	fmt.Fprintf(&b, " modes={%s}", strings.Join(parts, " "))
+	fmt.Fprintf(&b, "\n  %s", s.Timing)
+
+	for _, in := range s.Inputs {
+		fmt.Fprintf(&b, "\n  input %s packets=%d lost=%d parse_errors=%d auth_rejected=%d",
+			in.Name, in.Packets, in.Lost, in.ParseErrors, in.AuthRejected)
+	}
+	for _, out := range s.Outputs {
+		fmt.Fprintf(&b, "\n  %s", out)
+	}
+	return b.String()
+}
+
+// withShutdownSignals returns a context cancelled on SIGINT/SIGTERM, with the
+// signal recorded as the cancellation cause.
+func withShutdownSignals(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			cancel(fmt.Errorf("received %v", sig))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel(nil)
+	}
+}
+
+// sendSafeBurst sends the configured safe command burst and returns how many
+// commands were sent. A SimClock only moves when its driver advances it, and
+// the driver has stopped stepping by now, so on a SimClock the burst is sent
+// back to back rather than sleeping on a clock that may never move.
+func (l *liveLoop) sendSafeBurst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg := l.cfg.Shutdown
+	count := cfg.Count
+	if count <= 0 {
+		count = 5
+	}
+	interval := cfg.IntervalSeconds
+	if interval <= 0 {
+		interval = 0.02
+	}
+	mode := cfg.Mode
+	if mode == 0 {
+		mode = ModeStop
+	}
+
+	if sink, ok := l.sender.(ArmedSink); ok {
+		sink.SetArmed(false)
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if _, ok := l.clock.(*SimClock); !ok {
+				l.clock.Sleep(time.Duration(interval * float64(time.Second)))
+			}
+		}
+		cmd := BodyCommand{T: l.clock.Now().Sub(l.t0).Seconds(), Mode: mode}
+		l.sender.Send(cmd)
+		l.viz.UpdateOutput(cmd)
+		if l.cfg.Log.Enabled {
+			fmt.Printf("%8.3f mode=%-14s safe command %d/%d\n", cmd.T, mode.String(), i+1, count)
+		}
+	}
+	return count
+}
+
+// summary collects the run summary after the loop has stopped.
+func (l *liveLoop) summary(reason string, safeCommands int) RunSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := RunSummary{
+		Reason:       reason,
+		Duration:     l.clock.Now().Sub(l.t0),
+		Steps:        l.steps,
+		ModeSteps:    l.modeSteps,
+		LastMode:     l.controller.Mode(),
+		SafeCommands: safeCommands,
+		Timing:       l.timing.Stats(),
+	}
+	now := l.clock.Now().Sub(l.t0).Seconds()
+	for _, in := range l.inputs {
+		link := in.store.stats.Snapshot(now)
+		var parseErrors, authRejected uint64
+		for _, n := range link.ParseErrors {
+			parseErrors += n
+		}
+		for _, n := range in.auth.Stats().Rejected {
+			authRejected += n
+		}
+		s.Inputs = append(s.Inputs, InputSummary{
+			Name:         in.name,
+			Packets:      link.Packets,
+			Lost:         link.Lost,
+			ParseErrors:  parseErrors,
+			AuthRejected: authRejected,
+		})
+	}
+	return s
+}
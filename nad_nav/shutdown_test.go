@@ -0,0 +1,179 @@
+package nad_nav
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// armedRecordSink records commands and the armed flag it was last given.
+type armedRecordSink struct {
+	recordSink
+	armed []bool
+}
+
+func (s *armedRecordSink) SetArmed(armed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.armed = append(s.armed, armed)
+}
+
+func TestRunnerSummaryAfterCancel(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 10
+	clock := NewSimClock(time.Unix(1000, 0))
+	src := &pushSource{}
+	sink := &recordSink{}
+	r, err := NewRunner(cfg, WithSource(src), WithOutput(sink), WithClock(clock), WithTelemetry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitSteps(t, r, 1)
+	src.push("1,0.9,0.1,0,0.05")
+	src.push("not,a,packet")
+	src.push("1,0.9,0.2,0,0.05\n\n")
+	for i := uint64(2); i <= 3; i++ {
+		waitTimerIn(t, clock)
+		clock.Advance(100 * time.Millisecond)
+		waitSteps(t, r, i)
+	}
+
+	cancel(errors.New("operator request"))
+	select {
+	case <-r.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("loop did not stop on cancel")
+	}
+	s := r.Stop()
+	if s.Reason != "operator request" {
+		t.Errorf("reason %q, want the cancel cause", s.Reason)
+	}
+	if s.Duration != 200*time.Millisecond || s.Steps != 3 || s.SafeCommands != 5 {
+		t.Errorf("duration %v steps %d safe %d, want 200ms 3 5", s.Duration, s.Steps, s.SafeCommands)
+	}
+	var modeSteps uint64
+	for _, n := range s.ModeSteps {
+		modeSteps += n
+	}
+	if modeSteps != s.Steps || s.LastMode != r.Snapshot().Command.Mode {
+		t.Errorf("mode steps %v last %s, want %d steps ending in %s", s.ModeSteps, s.LastMode, s.Steps, r.Snapshot().Command.Mode)
+	}
+	if len(s.Inputs) != 1 {
+		t.Fatalf("inputs %+v, want one", s.Inputs)
+	}
+	if in := s.Inputs[0]; in.Name != "main" || in.Packets != 2 || in.ParseErrors != 1 || in.Lost != 0 {
+		t.Errorf("input summary %+v, want main with 2 packets and 1 parse error", in)
+	}
+
+	cmds := sink.commands()
+	if len(cmds) != 8 {
+		t.Fatalf("got %d commands, want 3 steps and 5 safe commands", len(cmds))
+	}
+	for i, cmd := range cmds[3:] {
+		if cmd != (BodyCommand{T: 0.2, Mode: ModeStop}) {
+			t.Errorf("safe command %d: %+v, want a neutral STOP", i, cmd)
+		}
+	}
+
+	// Stop is idempotent and the runner cannot be reused.
+	if again := r.Stop(); again.Reason != s.Reason || again.Steps != s.Steps {
+		t.Errorf("second Stop returned %+v", again)
+	}
+	if _, err := r.Step(); !errors.Is(err, errRunnerStopped) {
+		t.Errorf("Step after Stop: %v", err)
+	}
+	if err := r.Start(context.Background()); !errors.Is(err, errRunnerStopped) {
+		t.Errorf("Start after Stop: %v", err)
+	}
+}
+
+func TestSafeBurstPacingAndDisarm(t *testing.T) {
+	var cfg AppConfig
+	cfg.Hz = 10
+	cfg.Shutdown = ShutdownConfig{Count: 3, IntervalSeconds: 0.02, Mode: ModeLateralOnly}
+	sink := &armedRecordSink{}
+	r, err := NewRunner(cfg, WithSource(idleSource{}), WithOutput(sink), WithTelemetry(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Step(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s := r.Stop()
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("burst took %v, want at least two 20ms intervals", elapsed)
+	}
+	if s.Reason != "stopped" || s.SafeCommands != 3 {
+		t.Errorf("reason %q safe commands %d, want stopped 3", s.Reason, s.SafeCommands)
+	}
+
+	cmds := sink.commands()
+	if len(cmds) != 4 {
+		t.Fatalf("got %d commands, want 4", len(cmds))
+	}
+	for i, cmd := range cmds[1:] {
+		if cmd.Mode != ModeLateralOnly || cmd.Forward != 0 || cmd.Yaw != 0 || cmd.Vertical != 0 {
+			t.Errorf("safe command %d: %+v, want neutral LATERAL_ONLY", i, cmd)
+		}
+		if i > 0 && cmd.T-cmds[i].T < 0.015 {
+			t.Errorf("safe command %d at %v, only %v after the previous one", i, cmd.T, cmd.T-cmds[i].T)
+		}
+	}
+	sink.mu.Lock()
+	armed := sink.armed
+	sink.mu.Unlock()
+	if len(armed) == 0 || armed[len(armed)-1] {
+		t.Errorf("armed calls %v, want the burst sent disarmed", armed)
+	}
+}
+
+func TestShutdownSignalCancels(t *testing.T) {
+	ctx, stop := withShutdownSignals(context.Background())
+	defer stop()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("cannot signal self: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled by SIGTERM")
+	}
+	if cause := context.Cause(ctx).Error(); !strings.Contains(cause, "terminated") {
+		t.Errorf("cause %q, want the signal", cause)
+	}
+}
+
+func TestRunSummaryString(t *testing.T) {
+	s := RunSummary{
+		Reason:       "received interrupt",
+		Duration:     1500 * time.Millisecond,
+		Steps:        30,
+		ModeSteps:    map[Mode]uint64{ModeTrack: 20, ModeSearch: 10},
+		LastMode:     ModeTrack,
+		SafeCommands: 5,
+		Inputs:       []InputSummary{{Name: "front", Packets: 28, Lost: 2, ParseErrors: 1, AuthRejected: 3}},
+	}
+	out := s.String()
+	for _, want := range []string{
+		"run stopped (received interrupt) after 1.5s: steps=30 last_mode=TRACK safe_commands=5",
+		"modes={SEARCH=10 TRACK=20}",
+		"input front packets=28 lost=2 parse_errors=1 auth_rejected=3",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary %q does not contain %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package nad_nav
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"nad-navigation/mixer"
+)
+
+// SinkConfig declares one command output destination.
+//
+// Type is udp, tcp, unix (stream socket), unixgram, file, stdout or serial;
+// addr is the network address or path. Serial devices are opened raw at baud
+// (default 57600). Format is csv (default), json, binary, mixed (per-actuator
+// PWM from mixer) or mavlink (see MAVLinkConfig, which also uses mixer when
+// set); text formats are newline terminated on stream transports.
+// Version 1 (default)
+// keeps the legacy payloads; version 2 adds a per-sink sequence number, mode
+// code and armed flag, plus the target state when target is set. Hz limits
+// the send rate (0 sends every command), but mode changes are always sent.
+// MinHz (0 disables, at most hz) keeps the sink alive through gaps in the
+// loop: when nothing was sent for 1/min_hz the latest command is repeated,
+// with its heartbeat unchanged, for up to a second after the loop's last
+// command, so a stalled loop goes silent. Schedule instead sends at a fixed
+// rate of its own, resampling the loop's commands (see ScheduleConfig); hz
+// and min_hz do not apply then.
+//
+// Commands are queued (queue_size, default 16, dropping the oldest) and
+// written by a background goroutine with a write_timeout_seconds deadline
+// (default 0.1), so a slow or broken sink never blocks the control loop.
+// Socket sinks reconnect after errors. When key_file is set each payload is
+// wrapped in a signed envelope using the key with id key_id. Ack enables the
+// bridge's acknowledgment channel (see AckConfig); acks are not signed.
+type SinkConfig struct {
+	Name                string  `json:"name"`
+	Type                string  `json:"type"`
+	Addr                string  `json:"addr"`
+	Format              string  `json:"format"`
+	Version             int     `json:"version"`
+	Target              bool    `json:"target"`
+	Baud                int     `json:"baud"`
+	Hz                  float64 `json:"hz"`
+	MinHz               float64 `json:"min_hz"`
+	QueueSize           int     `json:"queue_size"`
+	WriteTimeoutSeconds float64 `json:"write_timeout_seconds"`
+	KeyFile             string  `json:"key_file"`
+	KeyID               int     `json:"key_id"`
+
+	MAVLink  MAVLinkConfig  `json:"mavlink"`
+	Mixer    *mixer.Config  `json:"mixer"`
+	Ack      AckConfig      `json:"ack"`
+	Schedule ScheduleConfig `json:"schedule"`
+}
+
+// SinkStats counts what one sink did with the commands it was given.
+type SinkStats struct {
+	Name      string
+	Type      string
+	Format    string
+	Sent      uint64 // payloads written
+	Limited   uint64 // commands skipped by the rate limit
+	Repeated  uint64 // keepalive repeats of the latest command
+	Scheduled uint64 // packets sent by the output schedule
+	Dropped   uint64 // payloads dropped from a full queue
+	Errors    uint64 // failed connects and writes
+	Connected bool
+	LastError string
+	Ack       *AckStats // nil without an ack channel
+}
+
+// String formats the statistics for the console log.
+func (s SinkStats) String() string {
+	out := fmt.Sprintf("output %s type=%s format=%s sent=%d limited=%d repeated=%d scheduled=%d dropped=%d errors=%d connected=%t",
+		s.Name, s.Type, s.Format, s.Sent, s.Limited, s.Repeated, s.Scheduled, s.Dropped, s.Errors, s.Connected)
+	if s.LastError != "" {
+		out += " last_error=" + s.LastError
+	}
+	if s.Ack != nil {
+		out += " " + s.Ack.String()
+	}
+	return out
+}
+
+// OutputSink is one command destination. Send, SendState and SetArmed must
+// not block.
+type OutputSink interface {
+	StateSink
+	SetArmed(armed bool)
+	Stats() SinkStats
+}
+
+const (
+	sinkDialTimeout   = time.Second
+	sinkRedialBackoff = time.Second
+	sinkCloseTimeout  = time.Second
+	keepaliveMaxAge   = time.Second
+)
+
+// queuedSink is an OutputSink writing through a background goroutine.
+type queuedSink struct {
+	cfg     SinkConfig
+	encoder commandEncoder
+	sealer  *Sealer
+	dial    func() (io.WriteCloser, error)
+	redial  bool // reconnect after write errors
+	frame   bool // append a newline to each record
+	period  float64
+	minGap  time.Duration // keepalive interval, 0 disables
+	timeout time.Duration
+	clock   Clock       // paces keepalives, the schedule and ack timing
+	acks    *ackTracker // nil without an ack channel
+	sched   *resampler  // nil without a schedule; guarded by mu
+
+	queue chan []byte
+	done  chan struct{}
+	stop  chan struct{}
+
+	mu sync.Mutex
+	// Rate limit state.
+	next    float64
+	hasNext bool
+	mode    Mode
+	// Sequence, heartbeat and the latest command for keepalives.
+	seq         uint32
+	beat        uint32
+	latest      BodyCommand
+	latestState *AnchorState
+	latestAt    time.Time
+	queuedAt    time.Time
+	disarmed    bool
+
+	closed    bool
+	abandoned bool // Close stopped waiting for the writer
+	conn      io.WriteCloser
+	lastDial  time.Time
+	stats     SinkStats
+}
+
+// NewOutputSink opens the sink described by cfg.
+func NewOutputSink(cfg SinkConfig) (OutputSink, error) {
+	return newOutputSink(cfg, RealClock{})
+}
+
+// newOutputSink opens a sink whose keepalives, schedule and ack timing follow
+// clock. Connects and write deadlines always use the system clock.
+func newOutputSink(cfg SinkConfig, clock Clock) (OutputSink, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	var encoder commandEncoder
+	var err error
+	switch cfg.Format {
+	case "mavlink":
+		if cfg.Version != 0 || cfg.Target || cfg.KeyFile != "" {
+			return nil, fmt.Errorf("sink %q: version, target and key_file do not apply to mavlink", cfg.Name)
+		}
+		encoder, err = newMAVLinkEncoder(cfg.MAVLink, cfg.Mixer)
+	case "mixed":
+		if cfg.Version != 0 || cfg.Target {
+			return nil, fmt.Errorf("sink %q: version and target do not apply to mixed", cfg.Name)
+		}
+		encoder, err = newMixedEncoder(cfg.Mixer)
+	default:
+		if cfg.Mixer != nil && !cfg.Ack.Enabled {
+			return nil, fmt.Errorf("sink %q: mixer requires format mixed or mavlink, or ack", cfg.Name)
+		}
+		encoder, err = newCommandEncoder(cfg.Format, cfg.Version, cfg.Target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+	}
+	if cfg.Format == "" {
+		cfg.Format = "csv"
+	}
+	sealer, err := NewSealer(cfg.KeyFile, cfg.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+	}
+	if cfg.MinHz < 0 || (cfg.Hz > 0 && cfg.MinHz > cfg.Hz) {
+		return nil, fmt.Errorf("sink %q: min_hz must be between 0 and hz", cfg.Name)
+	}
+	if cfg.Hz < 0 {
+		return nil, fmt.Errorf("sink %q: hz must be >= 0", cfg.Name)
+	}
+	sched, err := newResampler(cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+	}
+	if sched != nil && (cfg.Hz > 0 || cfg.MinHz > 0) {
+		return nil, fmt.Errorf("sink %q: hz and min_hz do not apply with schedule", cfg.Name)
+	}
+	if cfg.Type != "stdout" && cfg.Addr == "" {
+		return nil, fmt.Errorf("sink %q: addr is required", cfg.Name)
+	}
+	var acks *ackTracker
+	if cfg.Ack.Enabled {
+		switch {
+		case cfg.Type != "udp" && cfg.Type != "tcp" && cfg.Type != "unix":
+			return nil, fmt.Errorf("sink %q: ack requires a udp, tcp or unix sink", cfg.Name)
+		case cfg.Format == "mavlink" || (cfg.Format != "mixed" && cfg.Version != OutputProtocolVersion):
+			return nil, fmt.Errorf("sink %q: ack requires version %d or format mixed", cfg.Name, OutputProtocolVersion)
+		}
+		var mix *mixer.Mixer
+		if cfg.Mixer != nil {
+			if mix, err = mixer.New(*cfg.Mixer); err != nil {
+				return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+			}
+		}
+		acks = newAckTracker(cfg.Name, cfg.Ack, mix, clock)
+	}
+
+	s := &queuedSink{
+		cfg:     cfg,
+		encoder: encoder,
+		sealer:  sealer,
+		timeout: 100 * time.Millisecond,
+		clock:   clock,
+		acks:    acks,
+		sched:   sched,
+		stats:   SinkStats{Name: cfg.Name, Type: cfg.Type, Format: cfg.Format},
+	}
+	if cfg.Hz > 0 {
+		s.period = 1 / cfg.Hz
+	}
+	if cfg.MinHz > 0 {
+		s.minGap = time.Duration(float64(time.Second) / cfg.MinHz)
+	}
+	if cfg.WriteTimeoutSeconds > 0 {
+		s.timeout = time.Duration(cfg.WriteTimeoutSeconds * float64(time.Second))
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 16
+	}
+
+	switch cfg.Type {
+	case "udp":
+		addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+		}
+		s.dial = func() (io.WriteCloser, error) { return net.DialUDP("udp", nil, addr) }
+	case "tcp", "unix", "unixgram":
+		s.dial = func() (io.WriteCloser, error) { return net.DialTimeout(cfg.Type, cfg.Addr, sinkDialTimeout) }
+		s.redial = true
+		s.frame = cfg.Type != "unixgram"
+	case "file":
+		s.dial = func() (io.WriteCloser, error) {
+			return os.OpenFile(cfg.Addr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		}
+		s.frame = true
+	case "stdout":
+		s.dial = func() (io.WriteCloser, error) { return nopWriteCloser{os.Stdout}, nil }
+		s.frame = true
+	case "serial":
+		baud := cfg.Baud
+		if baud == 0 {
+			baud = 57600
+		}
+		s.dial = func() (io.WriteCloser, error) { return openSerial(cfg.Addr, baud) }
+	default:
+		return nil, fmt.Errorf("sink %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+	s.frame = s.frame && encoder.Text()
+
+	// Sockets connect lazily so an absent peer does not stop startup; files
+	// and UDP sockets open now to surface configuration errors.
+	if !s.redial {
+		conn, err := s.dial()
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", cfg.Name, err)
+		}
+		s.conn = conn
+		s.stats.Connected = true
+		s.readAcks(conn)
+	}
+
+	s.queue = make(chan []byte, queueSize)
+	s.done = make(chan struct{})
+	s.stop = make(chan struct{})
+	// Timers start here so a simulated clock advanced right after the sink
+	// opens cannot overtake them.
+	go s.run()
+	if hb, ok := encoder.(heartbeater); ok {
+		go s.heartbeat(hb, clock.NewTimer(hb.HeartbeatPeriod()))
+	}
+	if s.minGap > 0 {
+		go s.keepalive(clock.NewTimer(s.minGap / 4))
+	}
+	if s.sched != nil {
+		go s.schedule(clock.NewTimer(s.sched.period))
+	}
+	return s, nil
+}
+
+// heartbeater is an encoder that also emits periodic frames of its own.
+type heartbeater interface {
+	Heartbeat() []byte
+	HeartbeatPeriod() time.Duration
+}
+
+// heartbeat queues hb's frames, starting immediately, until Close. timer
+// paces the frames after the first.
+func (s *queuedSink) heartbeat(hb heartbeater, timer Timer) {
+	defer timer.Stop()
+	for {
+		s.enqueue(hb.Heartbeat())
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C():
+		}
+		timer.Reset(hb.HeartbeatPeriod())
+	}
+}
+
+// Send encodes cmd and queues it without blocking.
+func (s *queuedSink) Send(cmd BodyCommand) {
+	s.send(cmd, nil)
+}
+
+// SetArmed sets the armed flag of later packets, repeats included.
+func (s *queuedSink) SetArmed(armed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disarmed = !armed
+}
+
+// SendState is Send with the target state behind cmd.
+func (s *queuedSink) SendState(cmd BodyCommand, st AnchorState) {
+	s.send(cmd, &st)
+}
+
+// send rate limits, encodes and queues one command. With a schedule only
+// mode changes are queued here; the schedule sends the rest.
+func (s *queuedSink) send(cmd BodyCommand, st *AnchorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beat++
+	s.latest, s.latestState, s.latestAt = cmd, st, s.clock.Now()
+	if s.sched != nil {
+		if s.sched.add(cmd, s.latestAt) {
+			s.queueLocked(cmd, st, 0)
+		}
+		return
+	}
+	if !s.due(cmd) {
+		s.stats.Limited++
+		return
+	}
+	s.queueLocked(cmd, st, 0)
+}
+
+// keepalive repeats the latest command whenever nothing was queued for
+// minGap, until Close. Repeats stop once the latest command is older than
+// keepaliveMaxAge, so a stalled loop goes silent. timer checks every
+// quarter of minGap.
+func (s *queuedSink) keepalive(timer Timer) {
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C():
+		}
+		timer.Reset(s.minGap / 4)
+		s.mu.Lock()
+		now := s.clock.Now()
+		if !s.latestAt.IsZero() && now.Sub(s.queuedAt) >= s.minGap && now.Sub(s.latestAt) < keepaliveMaxAge {
+			s.stats.Repeated++
+			s.queueLocked(s.latest, s.latestState, 0)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// schedule sends a resampled command each time timer fires, every schedule
+// period, until Close. A tick within half a period of the last queued
+// packet, such as a mode change, is skipped; ticks stop once the latest
+// command is older than keepaliveMaxAge, so a stalled loop goes silent.
+func (s *queuedSink) schedule(timer Timer) {
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C():
+		}
+		timer.Reset(s.sched.period)
+		s.mu.Lock()
+		now := s.clock.Now()
+		if !s.latestAt.IsZero() && now.Sub(s.queuedAt) >= s.sched.period/2 && now.Sub(s.latestAt) < keepaliveMaxAge {
+			cmd, offset := s.sched.sample(now)
+			s.stats.Scheduled++
+			s.queueLocked(cmd, s.latestState, offset)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// queueLocked encodes cmd with the next sequence number and the current
+// heartbeat and queues it. Packets of scheduled sinks carry offset, the
+// sample's offset from cmd.T. Callers must hold s.mu.
+func (s *queuedSink) queueLocked(cmd BodyCommand, st *AnchorState, offset float64) {
+	s.seq++
+	p := newOutputPacket(s.seq, cmd, st, !s.disarmed)
+	p.Heartbeat = s.beat
+	p.HasSample = s.sched != nil
+	p.SampleOffset = offset
+	now := s.clock.Now()
+	s.acks.sent(p, now)
+	payload := s.encoder.Encode(p)
+	if s.frame {
+		payload = append(payload, '\n')
+	}
+	s.enqueueLocked(s.sealer.Seal(payload))
+	s.queuedAt = now
+}
+
+// enqueue queues payload, dropping the oldest payload when the queue is full.
+func (s *queuedSink) enqueue(payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enqueueLocked(payload)
+}
+
+// enqueueLocked is enqueue for callers holding s.mu.
+func (s *queuedSink) enqueueLocked(payload []byte) {
+	if s.closed {
+		return
+	}
+	for {
+		select {
+		case s.queue <- payload:
+			return
+		default:
+		}
+		select {
+		case <-s.queue:
+			s.stats.Dropped++
+		default:
+		}
+	}
+}
+
+// due applies the rate limit. Commands up to a tenth of a period early count
+// as due so loop jitter does not halve the rate.
+func (s *queuedSink) due(cmd BodyCommand) bool {
+	if s.period <= 0 {
+		return true
+	}
+	if s.hasNext && cmd.Mode == s.mode && cmd.T < s.next-0.1*s.period {
+		return false
+	}
+	s.next += s.period
+	if !s.hasNext || s.next <= cmd.T {
+		s.next = cmd.T + s.period
+	}
+	s.hasNext = true
+	s.mode = cmd.Mode
+	return true
+}
+
+// Stats implements OutputSink.
+func (s *queuedSink) Stats() SinkStats {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+	if s.acks != nil {
+		acks := s.acks.Stats()
+		stats.Ack = &acks
+	}
+	return stats
+}
+
+// readAcks starts reading acks from a new connection, if the sink has an ack
+// channel. The reader ends when the connection is closed.
+func (s *queuedSink) readAcks(conn io.WriteCloser) {
+	if r, ok := conn.(io.Reader); ok && s.acks != nil {
+		go s.acks.read(r, s.cfg.Type != "udp")
+	}
+}
+
+// Close flushes queued payloads, waiting up to a second, and closes the
+// connection.
+func (s *queuedSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	close(s.queue)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(sinkCloseTimeout):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandoned = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.stats.Connected = false
+	return err
+}
+
+// run writes queued payloads until the queue is closed.
+func (s *queuedSink) run() {
+	defer close(s.done)
+	for payload := range s.queue {
+		s.write(payload)
+	}
+}
+
+// write sends one payload, connecting first when needed.
+func (s *queuedSink) write(payload []byte) {
+	s.mu.Lock()
+	conn := s.conn
+	if conn == nil {
+		if !s.lastDial.IsZero() && time.Since(s.lastDial) < sinkRedialBackoff {
+			// Keep the dial error as last_error while waiting to redial.
+			s.stats.Errors++
+			s.mu.Unlock()
+			return
+		}
+		s.lastDial = time.Now()
+	}
+	s.mu.Unlock()
+
+	if conn == nil {
+		var err error
+		conn, err = s.dial()
+		s.mu.Lock()
+		if err != nil {
+			s.failLocked(err)
+			s.mu.Unlock()
+			return
+		}
+		if s.abandoned {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conn = conn
+		s.stats.Connected = true
+		s.readAcks(conn)
+		s.mu.Unlock()
+	}
+
+	if d, ok := conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		_ = d.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	_, err := conn.Write(payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failLocked(err)
+		if s.redial && s.conn == conn {
+			_ = conn.Close()
+			s.conn = nil
+			s.stats.Connected = false
+		}
+		return
+	}
+	s.stats.Sent++
+}
+
+// failLocked records a connect or write error. Callers must hold s.mu.
+func (s *queuedSink) failLocked(err error) {
+	s.stats.Errors++
+	s.stats.LastError = err.Error()
+}
+
+// nopWriteCloser keeps Close from closing a shared writer such as stdout.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
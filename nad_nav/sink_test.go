@@ -0,0 +1,236 @@
+package nad_nav
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLines returns the non-empty lines of a sink's output file.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestOutputSenderFanOut(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	sender, err := newOutputSender(OutputConfig{Sinks: []SinkConfig{
+		{Name: "log", Type: "file", Addr: path("log.csv")},
+		{Name: "json", Type: "file", Addr: path("out.json"), Format: "json", Version: OutputProtocolVersion, Target: true},
+		{Name: "bin", Type: "file", Addr: path("out.bin"), Format: "binary", Version: OutputProtocolVersion},
+		{Name: "slow", Type: "file", Addr: path("slow.csv"), Version: OutputProtocolVersion, Hz: 5},
+	}}, NewSimClock(time.Unix(1000, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 10 Hz loop switching to APPROACH at 0.5 s.
+	st := AnchorState{Valid: true, Confidence: 0.75, CX: 0.25, CY: -0.125, Size: 0.0625}
+	for i := 0; i < 10; i++ {
+		cmd := BodyCommand{T: float64(i) / 10, Mode: ModeTrack, Yaw: 0.5, Forward: 0.25}
+		if i >= 5 {
+			cmd.Mode = ModeApproach
+		}
+		sender.SendState(cmd, st)
+	}
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := sender.Stats()
+	var names []string
+	for _, s := range stats {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "log,json,bin,slow" {
+		t.Fatalf("sinks %v, want configuration order", names)
+	}
+	for _, s := range stats[:3] {
+		if s.Sent != 10 || s.Limited != 0 || s.Errors != 0 {
+			t.Errorf("%s: %s, want all 10 sent", s.Name, s)
+		}
+	}
+	if s := stats[3]; s.Sent != 5 || s.Limited != 5 {
+		t.Errorf("slow: %s, want 5 sent and 5 limited", s)
+	}
+
+	// Version 1 CSV: the legacy record without sequence or target.
+	lines := readLines(t, path("log.csv"))
+	if len(lines) != 10 || lines[0] != "0.5000,0.0000,0.2500,TRACK" {
+		t.Errorf("csv v1 lines %q", lines)
+	}
+
+	lines = readLines(t, path("out.json"))
+	if len(lines) != 10 {
+		t.Fatalf("%d json lines, want 10", len(lines))
+	}
+	for i, line := range lines {
+		var got jsonCommand
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != OutputProtocolVersion || got.Seq == nil || *got.Seq != uint32(i+1) || got.Target == nil || got.Target.CX != 0.25 {
+			t.Errorf("json line %d: %s", i, line)
+		}
+	}
+
+	data, err := os.ReadFile(path("out.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := EncodeOutputPacket(newOutputPacket(1, BodyCommand{}, nil, true))
+	if len(data) != 10*len(one) {
+		t.Fatalf("binary output %d bytes, want 10 packets of %d", len(data), len(one))
+	}
+	last, err := DecodeOutputPacket(data[9*len(one):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Seq != 10 || last.Command.Mode != ModeApproach || last.Command.T != 0.9 || last.HasTarget {
+		t.Errorf("last binary packet %+v", last)
+	}
+
+	// The rate-limited sink keeps every fifth-of-a-second command and the
+	// mode change in between.
+	var slow []string
+	for _, line := range readLines(t, path("slow.csv")) {
+		p, err := ParseOutputCSV(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slow = append(slow, fmt.Sprintf("%s@%.1f", p.Command.Mode, p.Command.T))
+	}
+	if got := strings.Join(slow, " "); got != "TRACK@0.0 TRACK@0.2 TRACK@0.4 APPROACH@0.5 APPROACH@0.8" {
+		t.Errorf("slow sink sent %s", got)
+	}
+}
+
+func TestOutputSenderLegacyUDPAddr(t *testing.T) {
+	file := SinkConfig{Name: "log", Type: "file", Addr: filepath.Join(t.TempDir(), "log.csv")}
+	sender, err := NewOutputSender(OutputConfig{UDPAddr: "127.0.0.1:9", Version: OutputProtocolVersion, Sinks: []SinkConfig{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	stats := sender.Stats()
+	if len(stats) != 2 || stats[0].Name != "udp" || stats[0].Type != "udp" || stats[0].Format != "csv" || stats[1].Name != "log" {
+		t.Errorf("sinks %+v, want the legacy udp sink first", stats)
+	}
+
+	dup := SinkConfig{Name: "udp", Type: "file", Addr: file.Addr}
+	if _, err := NewOutputSender(OutputConfig{UDPAddr: "127.0.0.1:9", Sinks: []SinkConfig{dup}}); err == nil {
+		t.Error("a sink named like the legacy udp sink was accepted")
+	}
+	unnamed := SinkConfig{Type: "file", Addr: file.Addr}
+	if _, err := NewOutputSender(OutputConfig{Sinks: []SinkConfig{unnamed, unnamed}}); err == nil {
+		t.Error("two unnamed sinks of one type were accepted")
+	}
+}
+
+func TestOutputSinkConfigErrors(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "out")
+	tests := []struct {
+		name string
+		cfg  SinkConfig
+	}{
+		{name: "unknown type", cfg: SinkConfig{Type: "carrier", Addr: addr}},
+		{name: "missing addr", cfg: SinkConfig{Type: "file"}},
+		{name: "unknown format", cfg: SinkConfig{Type: "file", Addr: addr, Format: "xml"}},
+		{name: "unknown version", cfg: SinkConfig{Type: "file", Addr: addr, Version: 7}},
+		{name: "negative hz", cfg: SinkConfig{Type: "file", Addr: addr, Hz: -1}},
+		{name: "min_hz above hz", cfg: SinkConfig{Type: "file", Addr: addr, Hz: 5, MinHz: 10}},
+		{name: "negative min_hz", cfg: SinkConfig{Type: "file", Addr: addr, MinHz: -1}},
+		{name: "hz with schedule", cfg: SinkConfig{Type: "file", Addr: addr, Hz: 10, Schedule: ScheduleConfig{Hz: 50}}},
+		{name: "version on mavlink", cfg: SinkConfig{Type: "udp", Addr: "127.0.0.1:9", Format: "mavlink", Version: OutputProtocolVersion}},
+		{name: "missing key file", cfg: SinkConfig{Type: "file", Addr: addr, KeyFile: filepath.Join(t.TempDir(), "missing.key")}},
+		{name: "ack on a file", cfg: SinkConfig{Type: "file", Addr: addr, Version: OutputProtocolVersion, Ack: AckConfig{Enabled: true}}},
+		{name: "ack on version 1", cfg: SinkConfig{Type: "udp", Addr: "127.0.0.1:9", Ack: AckConfig{Enabled: true}}},
+		{name: "unwritable file", cfg: SinkConfig{Type: "file", Addr: filepath.Join(addr, "missing", "out.csv")}},
+	}
+	for _, tt := range tests {
+		sink, err := NewOutputSink(tt.cfg)
+		if err == nil {
+			sink.Close()
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestOutputSinkArmedFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	sender, err := NewOutputSender(OutputConfig{Sinks: []SinkConfig{{Name: "fc", Type: "file", Addr: path, Version: OutputProtocolVersion}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.Send(BodyCommand{T: 0, Mode: ModeTrack})
+	sender.SetArmed(false)
+	sender.Send(BodyCommand{T: 0.1, Mode: ModeTrack})
+	sender.SetArmed(true)
+	sender.Send(BodyCommand{T: 0.2, Mode: ModeTrack})
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var armed []bool
+	for _, line := range readLines(t, path) {
+		p, err := ParseOutputCSV(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		armed = append(armed, p.Armed)
+	}
+	if len(armed) != 3 || !armed[0] || armed[1] || !armed[2] {
+		t.Errorf("armed flags %v, want true false true", armed)
+	}
+}
+
+func TestOutputSinkKeepaliveStopsWhenLoopStalls(t *testing.T) {
+	clock := NewSimClock(time.Unix(1000, 0))
+	sink, err := newOutputSink(SinkConfig{Name: "fc", Type: "file", Addr: filepath.Join(t.TempDir(), "out.csv"), MinHz: 10}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// step advances the clock by d once the keepalive timer is armed.
+	step := func(d time.Duration) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if next, ok := clock.NextDeadline(); ok && next.After(clock.Now()) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("keepalive timer not armed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(d)
+	}
+
+	sink.Send(BodyCommand{T: 0, Mode: ModeTrack})
+	for i := 0; i < 60; i++ {
+		step(25 * time.Millisecond)
+	}
+	step(0)
+	repeated := sink.Stats().Repeated
+	// One repeat per 100 ms while the latest command is under a second old.
+	if repeated < 8 || repeated > 9 {
+		t.Errorf("repeated %d times, want about 9", repeated)
+	}
+	for i := 0; i < 20; i++ {
+		step(25 * time.Millisecond)
+	}
+	step(0)
+	if got := sink.Stats().Repeated; got != repeated {
+		t.Errorf("repeated %d times after the loop stalled, want it to stay at %d", got, repeated)
+	}
+}
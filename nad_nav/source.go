@@ -0,0 +1,379 @@
+package nad_nav
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// PacketHandler receives one raw payload, or a read error, from an ObservationSource.
+//
+// The payload is only valid for the duration of the call.
+type PacketHandler func(payload []byte, err error)
+
+// ObservationSource delivers raw observation payloads to the live loop.
+//
+// Start must not block; payloads are delivered from the source's own goroutine.
+type ObservationSource interface {
+	Start(handle PacketHandler) error
+	Close() error
+}
+
+// NewObservationSource builds the source selected by live.source.
+//
+// Supported sources: "udp" (default), "tcp", "unix", "stdin", "file" and "pipe".
+func NewObservationSource(cfg LiveConfig) (ObservationSource, error) {
+	return newObservationSource(cfg, RealClock{})
+}
+
+// newObservationSource builds a source whose file replay is paced by clock.
+func newObservationSource(cfg LiveConfig, clock Clock) (ObservationSource, error) {
+	switch cfg.Source {
+	case "", "udp":
+		if cfg.UDPAddr == "" {
+			return nil, fmt.Errorf("live.udp_addr must be set")
+		}
+		return &udpSource{cfg: cfg}, nil
+	case "tcp":
+		if cfg.TCPAddr == "" {
+			return nil, fmt.Errorf("live.tcp_addr must be set for source tcp")
+		}
+		return &streamSource{network: "tcp", addr: cfg.TCPAddr}, nil
+	case "unix":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("live.path must be set for source unix")
+		}
+		return &streamSource{network: "unix", addr: cfg.Path}, nil
+	case "stdin":
+		return &readerSource{r: os.Stdin}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("live.path must be set for source file")
+		}
+		speed := cfg.ReplaySpeed
+		if speed <= 0 {
+			speed = 1
+		}
+		return &fileSource{path: cfg.Path, speed: speed, loop: cfg.ReplayLoop, clock: clock}, nil
+	case "pipe":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("live.path must be set for source pipe")
+		}
+		return &pipeSource{path: cfg.Path}, nil
+	default:
+		return nil, fmt.Errorf("unknown live.source %q", cfg.Source)
+	}
+}
+
+// addrFilterer is implemented by sources that know each sender's address.
+type addrFilterer interface {
+	// SetAllow installs a predicate deciding which senders are accepted.
+	SetAllow(allow func(net.Addr) bool)
+}
+
+// udpSource reads one payload per datagram.
+type udpSource struct {
+	cfg   LiveConfig
+	conn  *net.UDPConn
+	allow func(net.Addr) bool
+}
+
+// SetAllow implements addrFilterer.
+func (s *udpSource) SetAllow(allow func(net.Addr) bool) {
+	s.allow = allow
+}
+
+// Start binds the UDP socket and spawns the reader goroutine.
+func (s *udpSource) Start(handle PacketHandler) error {
+	addr, err := net.ResolveUDPAddr("udp", s.cfg.UDPAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	bufSize := s.cfg.ReadBuffer
+	if bufSize <= 0 {
+		bufSize = 2048
+	}
+
+	go func() {
+		buf := make([]byte, bufSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				handle(nil, err)
+				continue
+			}
+			if s.allow != nil && !s.allow(from) {
+				continue
+			}
+			handle(buf[:n], nil)
+		}
+	}()
+
+	return nil
+}
+
+// Close releases the UDP socket.
+func (s *udpSource) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// streamSource accepts TCP or Unix stream connections carrying binary packets or
+// newline-delimited text payloads.
+type streamSource struct {
+	network string
+	addr    string
+	allow   func(net.Addr) bool
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+}
+
+// SetAllow implements addrFilterer; it only applies to TCP listeners.
+func (s *streamSource) SetAllow(allow func(net.Addr) bool) {
+	s.allow = allow
+}
+
+// Start listens for writers and spawns the accept loop.
+func (s *streamSource) Start(handle PacketHandler) error {
+	if s.network == "unix" {
+		// A socket file left by a previous run would make Listen fail.
+		if info, err := os.Stat(s.addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(s.addr)
+		}
+	}
+	ln, err := net.Listen(s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.conns = map[net.Conn]struct{}{}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				handle(nil, err)
+				continue
+			}
+			if s.allow != nil && !s.allow(conn.RemoteAddr()) {
+				_ = conn.Close()
+				continue
+			}
+			s.track(conn, true)
+			go func() {
+				defer s.track(conn, false)
+				defer conn.Close()
+				if err := readPackets(conn, handle); err != nil && !errors.Is(err, net.ErrClosed) {
+					handle(nil, err)
+				}
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// track records open connections so Close can drop them.
+func (s *streamSource) track(conn net.Conn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// Close stops accepting and closes all open connections.
+func (s *streamSource) Close() error {
+	if s.ln == nil {
+		return nil
+	}
+	err := s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// readerSource reads binary packets or newline-delimited payloads from a reader such as stdin.
+type readerSource struct {
+	r io.Reader
+}
+
+// Start spawns the line reader goroutine.
+func (s *readerSource) Start(handle PacketHandler) error {
+	go func() {
+		if err := readPackets(s.r, handle); err != nil {
+			handle(nil, err)
+		}
+	}()
+	return nil
+}
+
+// Close is a no-op; the reader is owned by the caller.
+func (s *readerSource) Close() error {
+	return nil
+}
+
+// fileSource replays a recorded file of binary packets or text lines.
+//
+// Lines are paced by their t field; speed 2 plays twice as fast and an unset
+// speed plays in real time. Lines without t are delivered immediately.
+type fileSource struct {
+	path  string
+	speed float64
+	loop  bool
+	clock Clock
+
+	once sync.Once
+	done chan struct{}
+}
+
+// Start opens the file and spawns the replay goroutine.
+func (s *fileSource) Start(handle PacketHandler) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	s.done = make(chan struct{})
+
+	go func() {
+		defer f.Close()
+		for {
+			if err := s.replay(f, handle); err != nil {
+				handle(nil, err)
+				return
+			}
+			if !s.loop {
+				return
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				handle(nil, err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// replay delivers one pass over the file.
+func (s *fileSource) replay(r io.Reader, handle PacketHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitPackets)
+	var lastT float64
+	var hasLast bool
+	for scanner.Scan() {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if t, ok := replayTime(line); ok {
+			if hasLast && t > lastT {
+				wait := time.Duration((t - lastT) / s.speed * float64(time.Second))
+				timer := s.clock.NewTimer(wait)
+				select {
+				case <-timer.C():
+				case <-s.done:
+					timer.Stop()
+					return nil
+				}
+			}
+			lastT = t
+			hasLast = true
+		}
+		handle(line, nil)
+	}
+	return scanner.Err()
+}
+
+// Close stops the replay.
+func (s *fileSource) Close() error {
+	if s.done != nil {
+		s.once.Do(func() { close(s.done) })
+	}
+	return nil
+}
+
+// pipeSource reads binary packets or newline-delimited payloads from a named pipe.
+//
+// The FIFO is opened read-write so opening never blocks waiting for a writer
+// and writers can come and go without the reader seeing EOF.
+type pipeSource struct {
+	path string
+	f    *os.File
+}
+
+// Start opens the pipe and spawns the reader goroutine.
+func (s *pipeSource) Start(handle PacketHandler) error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	go func() {
+		if err := readPackets(f, handle); err != nil && !errors.Is(err, os.ErrClosed) {
+			handle(nil, err)
+		}
+	}()
+	return nil
+}
+
+// Close stops reading from the pipe.
+func (s *pipeSource) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// readPackets delivers each binary packet or non-empty line until EOF.
+func readPackets(r io.Reader, handle PacketHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitPackets)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		handle(line, nil)
+	}
+	return scanner.Err()
+}
+
+// replayTime extracts the camera timestamp from a recorded payload.
+func replayTime(payload []byte) (float64, bool) {
+	sample, err := decodeLivePayload(unsealedPayload(payload))
+	if err != nil || !sample.HasT {
+		return 0, false
+	}
+	return sample.Obs.T, true
+}
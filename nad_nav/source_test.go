@@ -0,0 +1,286 @@
+package nad_nav
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// packetCollector records what a source hands its PacketHandler.
+type packetCollector struct {
+	payloads chan string
+	errs     chan error
+}
+
+func newPacketCollector() *packetCollector {
+	return &packetCollector{payloads: make(chan string, 16), errs: make(chan error, 16)}
+}
+
+func (c *packetCollector) handle(payload []byte, err error) {
+	if err != nil {
+		c.errs <- err
+		return
+	}
+	c.payloads <- string(payload)
+}
+
+// next waits for the next payload.
+func (c *packetCollector) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case p := <-c.payloads:
+		return p
+	case err := <-c.errs:
+		t.Fatalf("read error %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no payload delivered")
+	}
+	return ""
+}
+
+// expect checks that the next payloads are want, in order.
+func (c *packetCollector) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if got := c.next(t); got != w {
+			t.Errorf("payload %q, want %q", got, w)
+		}
+	}
+}
+
+// none checks that nothing is delivered for a short while.
+func (c *packetCollector) none(t *testing.T) {
+	t.Helper()
+	select {
+	case p := <-c.payloads:
+		t.Errorf("unexpected payload %q", p)
+	case err := <-c.errs:
+		t.Errorf("unexpected error %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestNewObservationSourceErrors(t *testing.T) {
+	for _, cfg := range []LiveConfig{
+		{},
+		{Source: "udp"},
+		{Source: "tcp"},
+		{Source: "unix"},
+		{Source: "file"},
+		{Source: "pipe"},
+		{Source: "http", Path: "/obs"},
+	} {
+		if _, err := NewObservationSource(cfg); err == nil {
+			t.Errorf("source %q: no error", cfg.Source)
+		}
+	}
+	if src, err := NewObservationSource(LiveConfig{Source: "stdin"}); err != nil || src == nil {
+		t.Errorf("stdin: %v %v", src, err)
+	}
+}
+
+func TestUDPSource(t *testing.T) {
+	src, err := NewObservationSource(LiveConfig{UDPAddr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	allowed, err := net.ListenUDP("udp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer allowed.Close()
+	other, err := net.ListenUDP("udp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	c := newPacketCollector()
+	src.(addrFilterer).SetAllow(func(addr net.Addr) bool { return addr.String() == allowed.LocalAddr().String() })
+	if err := src.Start(c.handle); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	addr := src.(*udpSource).conn.LocalAddr().(*net.UDPAddr)
+
+	if _, err := other.WriteToUDP([]byte("1,0.9,0.1,0.2,0.05"), addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allowed.WriteToUDP([]byte("1,0.8,0.3,0.4,0.05"), addr); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(t, "1,0.8,0.3,0.4,0.05")
+	c.none(t)
+}
+
+func TestStreamSources(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "nad.sock")
+	// A socket file left by an earlier run does not stop the listener.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	for _, cfg := range []LiveConfig{
+		{Source: "tcp", TCPAddr: "127.0.0.1:0"},
+		{Source: "unix", Path: sock},
+	} {
+		t.Run(cfg.Source, func(t *testing.T) {
+			src, err := NewObservationSource(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newPacketCollector()
+			if err := src.Start(c.handle); err != nil {
+				t.Fatal(err)
+			}
+			ln := src.(*streamSource).ln
+			conn, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			packet := mustEncode(t, testObservationPacket())
+			stream := "1,0.9,0.1,0.2,0.05\n\n" + string(packet) + "{\"detected\":false}\r\n"
+			if _, err := conn.Write([]byte(stream)); err != nil {
+				t.Fatal(err)
+			}
+			c.expect(t, "1,0.9,0.1,0.2,0.05", string(packet), "{\"detected\":false}")
+
+			// Close drops connections that are still open.
+			if err := src.Close(); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err == nil {
+				t.Error("connection still open after Close")
+			}
+		})
+	}
+}
+
+func TestReaderAndPipeSources(t *testing.T) {
+	text := "0.5,1,0.9,0.1,0.2,0.05\n\n0.6,0,0,0,0,0\n"
+	path := filepath.Join(t.TempDir(), "obs.csv")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pipe, err := NewObservationSource(LiveConfig{Source: "pipe", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	for name, src := range map[string]ObservationSource{
+		"reader": &readerSource{r: strings.NewReader(text)},
+		"pipe":   pipe,
+	} {
+		c := newPacketCollector()
+		if err := src.Start(c.handle); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		c.expect(t, "0.5,1,0.9,0.1,0.2,0.05", "0.6,0,0,0,0,0")
+		c.none(t)
+	}
+
+	missing, err := NewObservationSource(LiveConfig{Source: "pipe", Path: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := missing.Start(newPacketCollector().handle); err == nil {
+		t.Error("opening a missing pipe: no error")
+	}
+}
+
+func TestFileSourceReplayPacing(t *testing.T) {
+	lines := []string{
+		"10,1,0.9,0.1,0.2,0.05",
+		"11,1,0.9,0.2,0.2,0.05",
+		"1,0.9,0.3,0.2,0.05", // no t: delivered straight after the previous line
+		"11.5,1,0.9,0.4,0.2,0.05",
+	}
+	path := filepath.Join(t.TempDir(), "obs.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clock := NewSimClock(time.Unix(1000, 0))
+	src, err := newObservationSource(LiveConfig{Source: "file", Path: path, ReplaySpeed: 2}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newPacketCollector()
+	if err := src.Start(c.handle); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// waitTimer returns how far ahead the replay's next timer is.
+	waitTimer := func() time.Duration {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if next, ok := clock.NextDeadline(); ok {
+				return next.Sub(clock.Now())
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("replay is not waiting on the clock")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	c.expect(t, lines[0])
+	// One second of camera time at speed 2.
+	if d := waitTimer(); d != 500*time.Millisecond {
+		t.Errorf("waiting %v before line 2, want 500ms", d)
+	}
+	c.none(t)
+	clock.Advance(500 * time.Millisecond)
+	c.expect(t, lines[1], lines[2])
+
+	if d := waitTimer(); d != 250*time.Millisecond {
+		t.Errorf("waiting %v before line 4, want 250ms", d)
+	}
+	clock.Advance(250 * time.Millisecond)
+	c.expect(t, lines[3])
+	c.none(t)
+}
+
+func TestFileSourceCloseStopsReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "obs.csv")
+	if err := os.WriteFile(path, []byte("0,1,0.9,0,0,0.1\n60,1,0.9,0,0,0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clock := NewSimClock(time.Unix(1000, 0))
+	src, err := newObservationSource(LiveConfig{Source: "file", Path: path, ReplayLoop: true}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newPacketCollector()
+	if err := src.Start(c.handle); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(t, "0,1,0.9,0,0,0.1")
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The replay gives up its timer and delivers nothing more.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := clock.NextDeadline(); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replay still waiting after Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.none(t)
+}
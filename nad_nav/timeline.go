@@ -0,0 +1,143 @@
+package nad_nav
+
+// InputAnomalies counts observation packets with suspicious timing.
+type InputAnomalies struct {
+	OutOfOrder uint64
+	Duplicate  uint64
+	ClockReset uint64
+	Stale      uint64
+}
+
+// guardVerdict is the outcome of checking one packet against the input timeline.
+type guardVerdict int
+
+const (
+	guardAccept guardVerdict = iota
+	guardDrop
+	guardReset
+)
+
+// seqResetWindow is how far the frame sequence may step back before it counts as a restart.
+const seqResetWindow = 256
+
+// seqRestartFrames is how close to zero a sequence that steps back must land to
+// count as a restart even within seqResetWindow.
+const seqRestartFrames = 16
+
+// timelineGuard rejects reordered and duplicated packets and detects camera clock resets.
+//
+// A jump is treated as a clock reset when the camera time steps back by more than
+// resetSeconds, or runs ahead of the receive time by more than resetSeconds.
+// Smaller backward steps are reordering. Camera time lagging receive time is
+// normal after a link stall and is accepted. Packets starting or stopping to
+// carry a camera time also mean a new time base, and so a reset.
+//
+// When packets carry a frame sequence it decides ordering. A sequence that
+// falls back by more than seqResetWindow frames, falls back to near zero, or
+// falls back while the camera time moves forward is also a reset.
+type timelineGuard struct {
+	resetSeconds float64
+
+	hasLast    bool
+	lastT      float64
+	lastHasT   bool
+	lastSeq    uint32
+	lastHasSeq bool
+	lastRecvT  float64
+
+	counts InputAnomalies
+}
+
+// newTimelineGuard constructs a guard with a default 1 s reset threshold.
+func newTimelineGuard(resetSeconds float64) timelineGuard {
+	if resetSeconds <= 0 {
+		resetSeconds = 1.0
+	}
+	return timelineGuard{resetSeconds: resetSeconds}
+}
+
+// Check classifies a packet and updates the counters.
+func (g *timelineGuard) Check(sample liveSample) guardVerdict {
+	if !sample.HasT && !sample.HasSeq {
+		return guardAccept
+	}
+	if !g.hasLast {
+		g.remember(sample)
+		return guardAccept
+	}
+
+	if g.isReset(sample) {
+		g.counts.ClockReset++
+		g.remember(sample)
+		return guardReset
+	}
+
+	var step float64
+	switch {
+	case sample.HasSeq && g.lastHasSeq:
+		step = float64(seqDelta(sample.Seq, g.lastSeq))
+	case sample.HasT && g.lastHasT:
+		step = sample.Obs.T - g.lastT
+	default:
+		g.remember(sample)
+		return guardAccept
+	}
+	if step == 0 {
+		g.counts.Duplicate++
+		return guardDrop
+	}
+	if step < 0 {
+		g.counts.OutOfOrder++
+		return guardDrop
+	}
+	g.remember(sample)
+	return guardAccept
+}
+
+// isReset reports whether the packet timing implies the camera restarted.
+func (g *timelineGuard) isReset(sample liveSample) bool {
+	if sample.HasT != g.lastHasT {
+		return true
+	}
+	if sample.HasT {
+		dt := sample.Obs.T - g.lastT
+		ahead := dt - (sample.RecvT - g.lastRecvT)
+		if dt < -g.resetSeconds || ahead > g.resetSeconds {
+			return true
+		}
+	}
+	if sample.HasSeq && g.lastHasSeq {
+		if seqRestart(sample.Seq, g.lastSeq) {
+			return true
+		}
+		// A reordered packet is older on both counts; a sequence stepping
+		// back against the camera clock was restarted.
+		if sample.HasT && seqDelta(sample.Seq, g.lastSeq) < 0 && sample.Obs.T > g.lastT {
+			return true
+		}
+	}
+	return false
+}
+
+// remember stores the timing of an accepted packet.
+func (g *timelineGuard) remember(sample liveSample) {
+	g.hasLast = true
+	g.lastT = sample.Obs.T
+	g.lastHasT = sample.HasT
+	g.lastSeq = sample.Seq
+	g.lastHasSeq = sample.HasSeq
+	g.lastRecvT = sample.RecvT
+}
+
+// seqRestart reports whether seq, following last, means the camera restarted
+// its frame counter: it fell back by more than seqResetWindow, or fell back
+// by more than seqRestartFrames to within seqRestartFrames of zero.
+func seqRestart(seq, last uint32) bool {
+	d := seqDelta(seq, last)
+	return d < -seqResetWindow || (d < -seqRestartFrames && seq < seqRestartFrames)
+}
+
+// seqDelta returns the signed distance from b to a, accounting for wraparound.
+func seqDelta(a, b uint32) int32 {
+	return int32(a - b)
+}
@@ -0,0 +1,52 @@
+package nad_nav
+
+import "testing"
+
+// seqSample builds a packet with a frame sequence, camera time and receive time.
+func seqSample(seq uint32, t, recvT float64) liveSample {
+	return liveSample{Obs: AnchorObservation{T: t}, HasT: true, Seq: seq, HasSeq: true, RecvT: recvT}
+}
+
+func TestTimelineGuard(t *testing.T) {
+	tests := []struct {
+		name string
+		prev liveSample
+		next liveSample
+		want guardVerdict
+	}{
+		{"next frame", seqSample(100, 10, 20), seqSample(101, 10.03, 20.03), guardAccept},
+		{"duplicate", seqSample(100, 10, 20), seqSample(100, 10, 20.01), guardDrop},
+		{"reordered", seqSample(100, 10, 20), seqSample(98, 9.94, 20.01), guardDrop},
+		{"far behind", seqSample(1000, 10, 20), seqSample(500, 9.9, 20.01), guardReset},
+		{"time steps back", seqSample(100, 10, 20), seqSample(101, 5, 20.03), guardReset},
+		{"time runs ahead", seqSample(100, 10, 20), seqSample(101, 15, 20.03), guardReset},
+		{"quick restart to zero", seqSample(200, 10, 20), seqSample(0, 9.5, 20.5), guardReset},
+		{"reordered near zero", seqSample(5, 10, 20), seqSample(2, 9.9, 20.01), guardDrop},
+		{"sequence back, time forward", seqSample(200, 10, 20), seqSample(150, 10.5, 20.5), guardReset},
+		{"time base appears", liveSample{Seq: 200, HasSeq: true, RecvT: 20}, seqSample(201, 0.1, 20.03), guardReset},
+		{"time base lost", seqSample(200, 10, 20), liveSample{Seq: 201, HasSeq: true, RecvT: 20.03}, guardReset},
+		{"wraparound", seqSample(0xFFFFFFFF, 10, 20), seqSample(0, 10.03, 20.03), guardAccept},
+	}
+	for _, tt := range tests {
+		g := newTimelineGuard(1)
+		g.Check(tt.prev)
+		if got := g.Check(tt.next); got != tt.want {
+			t.Errorf("%s: got verdict %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLinkStatsSeqRestart(t *testing.T) {
+	s := NewLinkStats()
+	for seq := uint32(200); seq < 210; seq++ {
+		s.RecordPacket(seqSample(seq, 0, 0))
+	}
+	// A quick restart must not give back loss or count the new frames as late.
+	for seq := uint32(0); seq < 5; seq++ {
+		s.RecordPacket(seqSample(seq, 0, 0))
+	}
+	s.RecordPacket(seqSample(7, 0, 0))
+	if snap := s.Snapshot(0); snap.Lost != 2 {
+		t.Errorf("lost = %d, want 2", snap.Lost)
+	}
+}
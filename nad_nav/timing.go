@@ -0,0 +1,171 @@
+package nad_nav
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tickBucketsMs are the upper bounds of the tick-duration histogram buckets.
+var tickBucketsMs = []float64{1, 2, 5, 10, 20, 50, 100}
+
+// HistogramBucket counts ticks whose duration was at most UpperMs (the last
+// bucket has an infinite bound).
+type HistogramBucket struct {
+	UpperMs float64
+	Count   uint64
+}
+
+// LoopTimingStats describes how well the loop keeps its schedule.
+type LoopTimingStats struct {
+	Ticks      uint64
+	Rate       float64 // ticks per second over the last second
+	Overruns   uint64  // ticks that finished after the next deadline, once per stall
+	CatchUp    uint64  // missed deadlines run back to back by the catch_up policy
+	Skipped    uint64  // deadlines dropped by the skip policy or a catch-up resync
+	LateMs     float64 // smoothed start lateness against the deadline
+	MaxLateMs  float64
+	MaxTickMs  float64
+	MeanTickMs float64
+	Histogram  []HistogramBucket
+}
+
+// String formats the statistics for the console log.
+func (s LoopTimingStats) String() string {
+	var buckets []string
+	for _, b := range s.Histogram {
+		label := fmt.Sprintf("<=%gms", b.UpperMs)
+		if math.IsInf(b.UpperMs, 1) {
+			label = "inf"
+		}
+		buckets = append(buckets, fmt.Sprintf("%s:%d", label, b.Count))
+	}
+	return fmt.Sprintf(
+		"timing rate=%.1f/s late=%.2fms max_late=%.2fms tick=%.2fms max_tick=%.2fms overruns=%d catch_up=%d skipped=%d hist={%s}",
+		s.Rate, s.LateMs, s.MaxLateMs, s.MeanTickMs, s.MaxTickMs, s.Overruns, s.CatchUp, s.Skipped, strings.Join(buckets, " "),
+	)
+}
+
+// loopTiming accumulates scheduling statistics.
+type loopTiming struct {
+	mu       sync.Mutex
+	ticks    uint64
+	starts   []time.Time // tick starts within the last second
+	overruns uint64
+	catchUp  uint64
+	skipped  uint64
+	behind   bool // the next tick runs a missed deadline
+	late     float64
+	maxLate  float64
+	meanTick float64
+	maxTick  float64
+	counts   []uint64
+}
+
+func newLoopTiming() *loopTiming {
+	return &loopTiming{counts: make([]uint64, len(tickBucketsMs)+1)}
+}
+
+// recordTick records one tick that was due at deadline, started at start
+// and took duration. A zero deadline means the tick was not scheduled.
+func (t *loopTiming) recordTick(deadline, start time.Time, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ticks++
+	t.starts = append(t.starts, start)
+	i := 0
+	for i < len(t.starts) && start.Sub(t.starts[i]) >= time.Second {
+		i++
+	}
+	t.starts = append(t.starts[:0], t.starts[i:]...)
+
+	if !deadline.IsZero() {
+		late := math.Max(0, start.Sub(deadline).Seconds()*1000)
+		t.late += 0.1 * (late - t.late)
+		t.maxLate = math.Max(t.maxLate, late)
+	}
+
+	ms := duration.Seconds() * 1000
+	t.meanTick += 0.1 * (ms - t.meanTick)
+	t.maxTick = math.Max(t.maxTick, ms)
+	bucket := len(tickBucketsMs)
+	for j, upper := range tickBucketsMs {
+		if ms <= upper {
+			bucket = j
+			break
+		}
+	}
+	t.counts[bucket]++
+}
+
+// recordDeadline records the outcome of nextDeadline for a tick that took
+// tick out of period.
+//
+// A stall is one overrun however many missed deadlines the catch_up policy
+// then runs; those ticks are counted as catch-up ticks, and only count as
+// overruns again if they take longer than a period themselves.
+func (t *loopTiming) recordDeadline(tick, period time.Duration, skipped int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.behind && tick <= period:
+		t.catchUp++
+	case skipped >= 0:
+		t.overruns++
+	}
+	if skipped > 0 {
+		t.skipped += uint64(skipped)
+	}
+	t.behind = skipped == 0
+}
+
+// Stats returns a copy of the statistics.
+func (t *loopTiming) Stats() LoopTimingStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := LoopTimingStats{
+		Ticks:      t.ticks,
+		Overruns:   t.overruns,
+		CatchUp:    t.catchUp,
+		Skipped:    t.skipped,
+		LateMs:     t.late,
+		MaxLateMs:  t.maxLate,
+		MaxTickMs:  t.maxTick,
+		MeanTickMs: t.meanTick,
+	}
+	if n := len(t.starts); n >= 2 {
+		if span := t.starts[n-1].Sub(t.starts[0]).Seconds(); span > 0 {
+			s.Rate = float64(n-1) / span
+		}
+	}
+	for i, n := range t.counts {
+		upper := math.Inf(1)
+		if i < len(tickBucketsMs) {
+			upper = tickBucketsMs[i]
+		}
+		s.Histogram = append(s.Histogram, HistogramBucket{UpperMs: upper, Count: n})
+	}
+	return s
+}
+
+// nextDeadline advances a tick deadline after a tick that ended at end.
+//
+// On time, the next deadline is one period later. When the tick overran,
+// policy "skip" jumps to the first future deadline, while "catch_up" keeps
+// the missed deadlines so they run back to back, resyncing like skip once
+// more than maxCatchUp periods behind. It returns the new deadline and the
+// number of deadlines dropped, or -1 when there was no overrun.
+func nextDeadline(deadline, end time.Time, period time.Duration, policy string, maxCatchUp int) (time.Time, int64) {
+	next := deadline.Add(period)
+	if end.Before(next) {
+		return next, -1
+	}
+	behind := int64(end.Sub(next)/period) + 1
+	if policy == "catch_up" && behind <= int64(maxCatchUp) {
+		return next, 0
+	}
+	return next.Add(time.Duration(behind) * period), behind
+}
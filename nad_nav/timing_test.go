@@ -0,0 +1,54 @@
+package nad_nav
+
+import (
+	"testing"
+	"time"
+)
+
+// simulateFixed runs the fixed-rate schedule over ticks of the given lengths
+// and returns the resulting statistics.
+func simulateFixed(policy string, period time.Duration, ticks []time.Duration) LoopTimingStats {
+	timing := newLoopTiming()
+	t0 := time.Unix(1000, 0)
+	deadline, now := t0, t0
+	for _, d := range ticks {
+		if now.Before(deadline) {
+			now = deadline
+		}
+		start := now
+		now = now.Add(d)
+		timing.recordTick(deadline, start, d)
+		next, skipped := nextDeadline(deadline, now, period, policy, 3)
+		timing.recordDeadline(d, period, skipped)
+		deadline = next
+	}
+	return timing.Stats()
+}
+
+func TestLoopTimingOverrunPolicies(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		name     string
+		policy   string
+		ticks    []time.Duration
+		overruns uint64
+		catchUp  uint64
+		skipped  uint64
+	}{
+		{"on time", "skip", []time.Duration{ms, ms, ms}, 0, 0, 0},
+		{"skip", "skip", []time.Duration{ms, 25 * ms, ms, ms}, 1, 0, 2},
+		// One stall of 2.5 periods: two missed deadlines run back to back.
+		{"catch up", "catch_up", []time.Duration{ms, 25 * ms, ms, ms, ms}, 1, 2, 0},
+		// A catch-up tick that overruns by itself is a second stall.
+		{"stall while catching up", "catch_up", []time.Duration{25 * ms, 15 * ms, ms, ms, ms, ms}, 2, 3, 0},
+		// Too far behind: resync like skip.
+		{"resync", "catch_up", []time.Duration{ms, 45 * ms, ms}, 1, 0, 4},
+	}
+	for _, tt := range tests {
+		s := simulateFixed(tt.policy, 10*ms, tt.ticks)
+		if s.Overruns != tt.overruns || s.CatchUp != tt.catchUp || s.Skipped != tt.skipped {
+			t.Errorf("%s: overruns=%d catch_up=%d skipped=%d, want %d %d %d",
+				tt.name, s.Overruns, s.CatchUp, s.Skipped, tt.overruns, tt.catchUp, tt.skipped)
+		}
+	}
+}
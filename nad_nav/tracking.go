@@ -3,18 +3,64 @@ package nad_nav
 import "math"
 
 // TrackerConfig controls smoothing and dropout handling for observations.
+//
+// PositionAlpha and SizeAlpha override Alpha for cx/cy and size when set.
 type TrackerConfig struct {
-	Alpha            float64 `json:"alpha"`
-	HoldSeconds      float64 `json:"hold_seconds"`
-	Decay            float64 `json:"decay"`
-	ReacquireConfMin float64 `json:"reacquire_conf_min"`
+	Alpha            float64                `json:"alpha"`
+	PositionAlpha    *float64               `json:"position_alpha"`
+	SizeAlpha        *float64               `json:"size_alpha"`
+	ConfidenceWeight ConfidenceWeightConfig `json:"confidence_weight"`
+	HoldSeconds      float64                `json:"hold_seconds"`
+	Decay            float64                `json:"decay"`
+	ReacquireConfMin float64                `json:"reacquire_conf_min"`
+}
+
+// ConfidenceWeightConfig maps detection confidence to a smoothing weight in [min_weight, 1].
+//
+// Curves:
+//   - "none" (default): every accepted detection has weight 1.
+//   - "linear": ramps from low to high confidence.
+//   - "power": the linear ramp raised to exponent (default 2).
+type ConfidenceWeightConfig struct {
+	Curve     string  `json:"curve"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	Exponent  float64 `json:"exponent"`
+	MinWeight float64 `json:"min_weight"`
+}
+
+// Weight returns how strongly a detection with the given confidence updates the estimate.
+func (c ConfidenceWeightConfig) Weight(conf float64) float64 {
+	var u float64
+	switch c.Curve {
+	case "", "none":
+		return 1
+	case "linear", "power":
+		high := c.High
+		if high <= c.Low {
+			high = 1
+		}
+		u = clamp((conf-c.Low)/(high-c.Low), 0, 1)
+		if c.Curve == "power" {
+			exp := c.Exponent
+			if exp <= 0 {
+				exp = 2
+			}
+			u = math.Pow(u, exp)
+		}
+	default:
+		return 1
+	}
+	minWeight := clamp(c.MinWeight, 0, 1)
+	return minWeight + (1-minWeight)*u
 }
 
 // AnchorTracker tracks the anchor position with smoothing and velocity estimates.
 type AnchorTracker struct {
 	cfg TrackerConfig
 
-	lastT       *float64
+	started     bool
+	lastMeasT   *float64 // time of the last accepted measurement
 	cx, cy      float64
 	size        float64
 	vx, vy      float64
@@ -23,6 +69,7 @@ type AnchorTracker struct {
 	lastRawCX   *float64
 	lastRawCY   *float64
 	lastRawSize *float64
+	resets      uint64
 }
 
 // NewAnchorTracker constructs a new tracker with the provided configuration.
@@ -30,24 +77,31 @@ func NewAnchorTracker(cfg TrackerConfig) *AnchorTracker {
 	return &AnchorTracker{cfg: cfg}
 }
 
+// Reset discards all filter state, e.g. after the camera clock restarted.
+func (tr *AnchorTracker) Reset() {
+	*tr = AnchorTracker{cfg: tr.cfg, resets: tr.resets + 1}
+}
+
+// Resets returns how many times the tracker has been re-initialized.
+func (tr *AnchorTracker) Resets() uint64 {
+	return tr.resets
+}
+
 // Update ingests the latest observation and returns a filtered AnchorState.
+//
+// Measurements may be stamped with their capture time, which lags the time of
+// the dropout ticks in between. Velocity is therefore taken against the last
+// accepted measurement only, and a detection no newer than it is skipped.
 func (tr *AnchorTracker) Update(obs AnchorObservation, confMin float64) AnchorState {
 	t := obs.T
 
-	if tr.lastT == nil {
-		tr.lastT = &t
+	if !tr.started {
+		tr.started = true
 		if obs.Detected && obs.Confidence >= confMin {
 			tr.lastValidT = &t
 		}
 	}
 
-	lastT := t
-	if tr.lastT != nil {
-		lastT = *tr.lastT
-	}
-	dt := math.Max(1e-3, t-lastT)
-	*tr.lastT = t
-
 	minConf := confMin
 	if tr.lastValidT == nil || t-*tr.lastValidT > tr.cfg.HoldSeconds {
 		if tr.cfg.ReacquireConfMin > 0 {
@@ -56,12 +110,16 @@ func (tr *AnchorTracker) Update(obs AnchorObservation, confMin float64) AnchorSt
 	}
 
 	good := obs.Detected && obs.Confidence >= minConf
+	if good && tr.lastMeasT != nil && t <= *tr.lastMeasT {
+		good = false
+	}
 
 	var valid bool
 	var age float64
 
 	if good {
 		if tr.lastRawCX != nil {
+			dt := math.Max(1e-3, t-*tr.lastMeasT)
 			tr.vx = (obs.CX - *tr.lastRawCX) / dt
 			tr.vy = (obs.CY - *tr.lastRawCY) / dt
 			tr.vsize = (obs.Size - *tr.lastRawSize) / dt
@@ -70,18 +128,22 @@ func (tr *AnchorTracker) Update(obs AnchorObservation, confMin float64) AnchorSt
 		tr.lastRawCX = &obs.CX
 		tr.lastRawCY = &obs.CY
 		tr.lastRawSize = &obs.Size
+		tr.lastMeasT = &t
 
-		a := tr.cfg.Alpha
-		tr.cx = a*tr.cx + (1-a)*obs.CX
-		tr.cy = a*tr.cy + (1-a)*obs.CY
-		tr.size = a*tr.size + (1-a)*obs.Size
+		// Low-confidence detections move the estimate proportionally less.
+		w := tr.cfg.ConfidenceWeight.Weight(obs.Confidence)
+		posGain := (1 - tr.positionAlpha()) * w
+		sizeGain := (1 - tr.sizeAlpha()) * w
+		tr.cx += posGain * (obs.CX - tr.cx)
+		tr.cy += posGain * (obs.CY - tr.cy)
+		tr.size += sizeGain * (obs.Size - tr.size)
 
 		tr.lastValidT = &t
 		valid = true
 		age = 0
 	} else {
 		if tr.lastValidT != nil {
-			age = t - *tr.lastValidT
+			age = math.Max(0, t-*tr.lastValidT)
 		} else {
 			age = 999
 		}
@@ -105,3 +167,34 @@ func (tr *AnchorTracker) Update(obs AnchorObservation, confMin float64) AnchorSt
 		Size: tr.size, VSize: tr.vsize, Age: age,
 	}
 }
+
+// positionAlpha returns the smoothing factor for cx/cy.
+func (tr *AnchorTracker) positionAlpha() float64 {
+	if tr.cfg.PositionAlpha != nil {
+		return *tr.cfg.PositionAlpha
+	}
+	return tr.cfg.Alpha
+}
+
+// sizeAlpha returns the smoothing factor for size.
+func (tr *AnchorTracker) sizeAlpha() float64 {
+	if tr.cfg.SizeAlpha != nil {
+		return *tr.cfg.SizeAlpha
+	}
+	return tr.cfg.Alpha
+}
+
+// Predict extrapolates the state from the last good measurement to time now.
+//
+// The horizon is clamped to maxHorizon so a stale target is not projected far away.
+func (tr *AnchorTracker) Predict(st AnchorState, now, maxHorizon float64) AnchorState {
+	if tr.lastValidT == nil || !st.Valid || maxHorizon <= 0 {
+		return st
+	}
+	h := clamp(now-*tr.lastValidT, 0, maxHorizon)
+	st.CX += st.VX * h
+	st.CY += st.VY * h
+	st.Size += st.VSize * h
+	st.T = now
+	return st
+}
@@ -0,0 +1,130 @@
+package nad_nav
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTrackerVelocityWithLatencyAndGaps(t *testing.T) {
+	const (
+		latency = 0.08 // capture to receive
+		speed   = 0.5  // cx per second
+		hz      = 30.0
+		frameDt = 0.1 // one packet every three ticks
+	)
+	t0 := time.Unix(1000, 0)
+	est := NewLatencyEstimator(LatencyConfig{Enabled: true, CameraEpoch: true, MaxPredict: 0.25}, t0)
+	tr := NewAnchorTracker(TrackerConfig{HoldSeconds: 0.5})
+	camBase := float64(t0.UnixNano()) / 1e9
+
+	nextCapture := 0.0
+	for i := 0; i < 90; i++ {
+		simT := float64(i) / hz
+		var st AnchorState
+		if simT >= nextCapture+latency {
+			// The packet captured at nextCapture arrives now, re-stamped to its
+			// capture time, behind the dropout ticks already fed.
+			est.Observe(camBase+nextCapture, simT)
+			obs := AnchorObservation{T: est.ToLocal(camBase + nextCapture), Detected: true, Confidence: 0.9, CX: speed * nextCapture}
+			st = tr.Update(obs, 0.5)
+			nextCapture += frameDt
+		} else {
+			st = tr.Update(AnchorObservation{T: simT}, 0.5)
+		}
+		if math.Abs(st.VX) > 2*speed {
+			t.Fatalf("tick %d: vx = %v, want about %v", i, st.VX, speed)
+		}
+		pred := tr.Predict(st, simT, est.MaxPredict())
+		if want := speed * simT; st.Valid && nextCapture > frameDt && math.Abs(pred.CX-want) > 0.05 {
+			t.Errorf("tick %d: predicted cx %v, want about %v", i, pred.CX, want)
+		}
+	}
+}
+
+func TestTrackerSkipsOlderMeasurement(t *testing.T) {
+	tr := NewAnchorTracker(TrackerConfig{HoldSeconds: 1})
+	tr.Update(AnchorObservation{T: 1, Detected: true, Confidence: 1, CX: 0.1}, 0.5)
+	tr.Update(AnchorObservation{T: 1.1, Detected: true, Confidence: 1, CX: 0.2}, 0.5)
+	st := tr.Update(AnchorObservation{T: 1.05, Detected: true, Confidence: 1, CX: 0.9}, 0.5)
+	if math.Abs(st.VX-1) > 1e-9 || math.Abs(st.CX-0.2) > 1e-9 {
+		t.Errorf("older measurement was used: cx %v vx %v", st.CX, st.VX)
+	}
+	if !st.Valid || st.Age != 0 {
+		t.Errorf("valid %v age %v, want a valid state", st.Valid, st.Age)
+	}
+}
+
+func TestConfidenceWeightCurves(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ConfidenceWeightConfig
+		conf float64
+		want float64
+	}{
+		{name: "none", cfg: ConfidenceWeightConfig{}, conf: 0.1, want: 1},
+		{name: "unknown curve", cfg: ConfidenceWeightConfig{Curve: "cubic"}, conf: 0.1, want: 1},
+		{name: "linear below", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.4, High: 0.8}, conf: 0.2, want: 0},
+		{name: "linear mid", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.4, High: 0.8}, conf: 0.6, want: 0.5},
+		{name: "linear above", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.4, High: 0.8}, conf: 0.95, want: 1},
+		{name: "linear default high", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.5}, conf: 0.75, want: 0.5},
+		{name: "linear min weight", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.4, High: 0.8, MinWeight: 0.2}, conf: 0.6, want: 0.6},
+		{name: "min weight floor", cfg: ConfidenceWeightConfig{Curve: "linear", Low: 0.4, High: 0.8, MinWeight: 0.2}, conf: 0, want: 0.2},
+		{name: "power default exponent", cfg: ConfidenceWeightConfig{Curve: "power", Low: 0, High: 1}, conf: 0.5, want: 0.25},
+		{name: "power exponent 3", cfg: ConfidenceWeightConfig{Curve: "power", Low: 0, High: 1, Exponent: 3}, conf: 0.5, want: 0.125},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Weight(tt.conf); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Weight(%v) = %v, want %v", tt.name, tt.conf, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerSmoothing(t *testing.T) {
+	posAlpha, sizeAlpha := 0.75, 0.5
+	tests := []struct {
+		name             string
+		cfg              TrackerConfig
+		conf             float64
+		wantCX, wantSize float64
+	}{
+		{name: "unsmoothed", cfg: TrackerConfig{}, conf: 0.9, wantCX: 0.4, wantSize: 0.2},
+		{name: "shared alpha", cfg: TrackerConfig{Alpha: 0.5}, conf: 0.9, wantCX: 0.2, wantSize: 0.1},
+		{name: "per channel", cfg: TrackerConfig{Alpha: 0.9, PositionAlpha: &posAlpha, SizeAlpha: &sizeAlpha}, conf: 0.9, wantCX: 0.1, wantSize: 0.1},
+		{
+			name:   "confidence weighted",
+			cfg:    TrackerConfig{ConfidenceWeight: ConfidenceWeightConfig{Curve: "linear", Low: 0.5, High: 1}},
+			conf:   0.75,
+			wantCX: 0.2, wantSize: 0.1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewAnchorTracker(tt.cfg)
+			st := tr.Update(AnchorObservation{T: 0, Detected: true, Confidence: tt.conf, CX: 0.4, CY: -0.4, Size: 0.2}, 0.5)
+			if !st.Valid || math.Abs(st.CX-tt.wantCX) > 1e-9 || math.Abs(st.CY+tt.wantCX) > 1e-9 || math.Abs(st.Size-tt.wantSize) > 1e-9 {
+				t.Errorf("state %+v, want cx %v cy %v size %v", st, tt.wantCX, -tt.wantCX, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestTrackerLowConfidenceMovesLess(t *testing.T) {
+	cfg := TrackerConfig{Alpha: 0.5, ConfidenceWeight: ConfidenceWeightConfig{Curve: "power", Low: 0.3, High: 1, MinWeight: 0.1}}
+	high, low := NewAnchorTracker(cfg), NewAnchorTracker(cfg)
+	var hs, ls AnchorState
+	for i := 0; i < 5; i++ {
+		obs := AnchorObservation{T: float64(i) * 0.1, Detected: true, CX: 0.5, Size: 0.1}
+		obs.Confidence = 1
+		hs = high.Update(obs, 0.3)
+		obs.Confidence = 0.4
+		ls = low.Update(obs, 0.3)
+	}
+	if !(ls.CX > 0 && ls.CX < hs.CX && hs.CX < 0.5) {
+		t.Errorf("cx after five updates: high confidence %v, low confidence %v; want 0 < low < high < 0.5", hs.CX, ls.CX)
+	}
+	// Velocity comes from the raw measurements and is not weighted.
+	if hs.VX != 0 || ls.VX != 0 {
+		t.Errorf("vx %v %v for a still target, want 0", hs.VX, ls.VX)
+	}
+}
@@ -0,0 +1,506 @@
+package nad_nav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VehicleConfig controls MAVLink telemetry ingestion from the flight controller.
+//
+// Source is "udp" (listen on addr), "serial" (device path addr at baud,
+// default 57600) or "file" (replay a recorded byte stream from addr, such as
+// a mavlink-standin -raw capture, paced by message boot times at
+// replay_speed, default 1). An empty source disables telemetry.
+//
+// Only frames from system_id are used; 0 (default) locks onto the first
+// autopilot that sends a HEARTBEAT. Heartbeats from ground stations and
+// companions (autopilot INVALID) are ignored. The link counts as lost when
+// no heartbeat arrived for stale_seconds (default 2). manual_channel
+// (1-based, 0 disables) is the RC channel of the pilot's manual switch,
+// engaged above manual_pwm (default 1700). Attitude feeds ATTITUDE messages
+// into attitude compensation, with or without attitude.udp_addr.
+type VehicleConfig struct {
+	Source        string  `json:"source"`
+	Addr          string  `json:"addr"`
+	Baud          int     `json:"baud"`
+	ReplaySpeed   float64 `json:"replay_speed"`
+	SystemID      int     `json:"system_id"`
+	StaleSeconds  float64 `json:"stale_seconds"`
+	ManualChannel int     `json:"manual_channel"`
+	ManualPWM     int     `json:"manual_pwm"`
+	Attitude      bool    `json:"attitude"`
+}
+
+// VehicleState is the flight controller's latest reported state. Times are
+// controller seconds at receipt.
+type VehicleState struct {
+	Connected    bool // a heartbeat arrived within stale_seconds
+	HeartbeatT   float64
+	SystemID     uint8
+	Armed        bool
+	BaseMode     uint8
+	CustomMode   uint32
+	SystemStatus uint8
+
+	HasAttitude bool
+	Attitude    AttitudeSample
+
+	HasBattery       bool
+	BatteryVoltage   float64 // V
+	BatteryCurrent   float64 // A, negative when unknown
+	BatteryRemaining int     // percent, -1 when unknown
+
+	HasRC      bool
+	RCChannels []uint16 // PWM per channel, channel 1 first
+	RSSI       uint8    // 0..254, 255 when unknown
+	Manual     bool     // the manual switch is engaged
+}
+
+// String formats the state for the console log.
+func (v VehicleState) String() string {
+	var b strings.Builder
+	link := "lost"
+	if v.Connected {
+		link = "ok"
+	}
+	fmt.Fprintf(&b, "vehicle link=%s armed=%t custom_mode=%d", link, v.Armed, v.CustomMode)
+	if v.HasBattery {
+		fmt.Fprintf(&b, " battery=%.2fV", v.BatteryVoltage)
+		if v.BatteryCurrent >= 0 {
+			fmt.Fprintf(&b, " %.1fA", v.BatteryCurrent)
+		}
+		if v.BatteryRemaining >= 0 {
+			fmt.Fprintf(&b, " %d%%", v.BatteryRemaining)
+		}
+	}
+	if v.HasRC {
+		fmt.Fprintf(&b, " rc=%dch manual=%t", len(v.RCChannels), v.Manual)
+	}
+	if v.HasAttitude {
+		fmt.Fprintf(&b, " att(roll=%+.1f pitch=%+.1f yaw=%+.1f)",
+			v.Attitude.Roll*180/math.Pi, v.Attitude.Pitch*180/math.Pi, v.Attitude.Yaw*180/math.Pi)
+	}
+	return b.String()
+}
+
+// VehicleLinkStats counts MAVLink frames received from the flight controller.
+type VehicleLinkStats struct {
+	Frames     uint64
+	Errors     uint64 // corrupt frames and garbage runs
+	Unknown    uint64 // frames of messages nad does not decode
+	Ignored    uint64 // frames from other systems
+	ReadErrors uint64 // failed reads and reopen attempts on the source
+	Reopens    uint64 // serial ports reopened after a read error
+}
+
+// Retry delays after a telemetry read error, doubling while it persists.
+const (
+	vehicleRetryMin = 10 * time.Millisecond
+	vehicleRetryMax = time.Second
+)
+
+// VehicleLink ingests MAVLink telemetry into a VehicleState.
+type VehicleLink struct {
+	cfg        VehicleConfig
+	now        func() float64
+	onAttitude func(AttitudeSample)
+	closer     io.Closer
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	mu           sync.Mutex
+	parser       MAVLinkParser
+	ignored      uint64
+	readErrors   uint64
+	reopens      uint64
+	system       uint8
+	hasHeartbeat bool
+	state        VehicleState
+}
+
+// StartVehicleLink starts reading telemetry from cfg's source. It returns nil
+// when telemetry is not configured. now supplies the controller time used to
+// stamp messages; onAttitude, if set, receives every ATTITUDE sample.
+func StartVehicleLink(cfg VehicleConfig, clock Clock, now func() float64, onAttitude func(AttitudeSample)) (*VehicleLink, error) {
+	if cfg.Source == "" {
+		return nil, nil
+	}
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("vehicle: addr is required")
+	}
+	if cfg.SystemID < 0 || cfg.SystemID > math.MaxUint8 {
+		return nil, fmt.Errorf("vehicle: system_id %d out of range", cfg.SystemID)
+	}
+	v := NewVehicleLink(cfg, now, onAttitude)
+
+	switch cfg.Source {
+	case "udp":
+		addr, err := net.ResolveUDPAddr("udp", cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle: %w", err)
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle: %w", err)
+		}
+		v.closer = conn
+		go v.read(conn, nil)
+	case "serial":
+		baud := cfg.Baud
+		if baud == 0 {
+			baud = 57600
+		}
+		port, err := openSerial(cfg.Addr, baud)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle: %w", err)
+		}
+		v.closer = port
+		go v.read(port, func() (io.ReadCloser, error) { return openSerial(cfg.Addr, baud) })
+	case "file":
+		data, err := os.ReadFile(cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle: %w", err)
+		}
+		speed := cfg.ReplaySpeed
+		if speed <= 0 {
+			speed = 1
+		}
+		go v.replay(data, clock, speed)
+	default:
+		return nil, fmt.Errorf("vehicle: unknown source %q", cfg.Source)
+	}
+	return v, nil
+}
+
+// NewVehicleLink creates a link without a source, driven through Feed.
+func NewVehicleLink(cfg VehicleConfig, now func() float64, onAttitude func(AttitudeSample)) *VehicleLink {
+	if cfg.StaleSeconds <= 0 {
+		cfg.StaleSeconds = 2
+	}
+	if cfg.ManualPWM <= 0 {
+		cfg.ManualPWM = 1700
+	}
+	return &VehicleLink{
+		cfg:        cfg,
+		now:        now,
+		onAttitude: onAttitude,
+		system:     uint8(cfg.SystemID),
+		stop:       make(chan struct{}),
+	}
+}
+
+// read feeds bytes from r until it is closed.
+//
+// Other read errors are retried after a backoff growing from vehicleRetryMin
+// to vehicleRetryMax. With reopen set, r is then replaced by a freshly opened
+// source: a serial port fails with EIO or EOF for good once a USB flight
+// controller is unplugged. The link goes stale in the meantime.
+func (v *VehicleLink) read(r io.Reader, reopen func() (io.ReadCloser, error)) {
+	buf := make([]byte, 4096)
+	backoff := vehicleRetryMin
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			v.Feed(buf[:n])
+			backoff = vehicleRetryMin
+		}
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) || (reopen == nil && errors.Is(err, io.EOF)) {
+			return
+		}
+		v.readError(err, backoff == vehicleRetryMin)
+		for {
+			if !v.wait(backoff) {
+				return
+			}
+			backoff = min(2*backoff, vehicleRetryMax)
+			if reopen == nil {
+				break
+			}
+			port, err := reopen()
+			if err != nil {
+				v.readError(err, false)
+				continue
+			}
+			if !v.replaceSource(port) {
+				return
+			}
+			r = port
+			break
+		}
+	}
+}
+
+// readError counts a failed read or reopen, logging it when first is set.
+func (v *VehicleLink) readError(err error, first bool) {
+	if first {
+		log.Printf("vehicle: read error: %v; retrying", err)
+	}
+	v.mu.Lock()
+	v.readErrors++
+	v.mu.Unlock()
+}
+
+// wait sleeps for d, returning false when the link is closed first.
+func (v *VehicleLink) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-v.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// replaceSource closes the failed source and installs port in its place. It
+// returns false, closing port, when the link was closed meanwhile.
+func (v *VehicleLink) replaceSource(port io.ReadCloser) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	select {
+	case <-v.stop:
+		port.Close()
+		return false
+	default:
+	}
+	if v.closer != nil {
+		v.closer.Close()
+	}
+	v.closer = port
+	v.reopens++
+	log.Printf("vehicle: source reopened")
+	return true
+}
+
+// replay applies a recorded byte stream, pacing frames that carry a boot
+// time (ATTITUDE, RC_CHANNELS) at speed times real time.
+func (v *VehicleLink) replay(data []byte, clock Clock, speed float64) {
+	var parser MAVLinkParser
+	frames := parser.Parse(data)
+	v.mu.Lock()
+	v.parser.Errors += parser.Errors
+	v.parser.Unknown += parser.Unknown
+	v.mu.Unlock()
+
+	var start time.Time
+	var bootStart uint32
+	paced := false
+	for _, frame := range frames {
+		if boot, ok := frameBootMs(frame); ok {
+			if !paced || boot < bootStart {
+				start, bootStart, paced = clock.Now(), boot, true
+			}
+			due := start.Add(time.Duration(float64(boot-bootStart) / speed * float64(time.Millisecond)))
+			if wait := due.Sub(clock.Now()); wait > 0 {
+				timer := clock.NewTimer(wait)
+				select {
+				case <-v.stop:
+					timer.Stop()
+					return
+				case <-timer.C():
+				}
+			}
+		}
+		select {
+		case <-v.stop:
+			return
+		default:
+		}
+		v.mu.Lock()
+		v.parser.Frames++
+		v.apply(frame, v.now())
+		v.mu.Unlock()
+	}
+}
+
+// frameBootMs returns the time_boot_ms of messages that carry one.
+func frameBootMs(f MAVLinkFrame) (uint32, bool) {
+	switch f.MessageID {
+	case MAVLinkMsgAttitude, MAVLinkMsgRCChannels:
+		if len(f.Payload) >= 4 {
+			return uint32(f.Payload[0]) | uint32(f.Payload[1])<<8 | uint32(f.Payload[2])<<16 | uint32(f.Payload[3])<<24, true
+		}
+	}
+	return 0, false
+}
+
+// Feed parses raw MAVLink bytes and applies every complete frame at the
+// current controller time. Tools and tests can drive a link with recorded
+// bytes this way.
+func (v *VehicleLink) Feed(data []byte) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t := v.now()
+	for _, frame := range v.parser.Parse(data) {
+		v.apply(frame, t)
+	}
+}
+
+// apply updates the state from one frame. Callers must hold v.mu.
+func (v *VehicleLink) apply(frame MAVLinkFrame, t float64) {
+	msg, err := DecodeMAVLinkMessage(frame)
+	if err != nil {
+		v.parser.Errors++
+		return
+	}
+	if hb, ok := msg.(MAVLinkHeartbeat); ok && hb.Autopilot == mavAutopilotInvalid {
+		// Ground stations and companions, including nad's own heartbeats.
+		return
+	}
+	if v.system != 0 && frame.SystemID != v.system {
+		v.ignored++
+		return
+	}
+
+	s := &v.state
+	switch m := msg.(type) {
+	case MAVLinkHeartbeat:
+		v.system = frame.SystemID
+		v.hasHeartbeat = true
+		s.HeartbeatT = t
+		s.SystemID = frame.SystemID
+		s.Armed = m.BaseMode&mavModeFlagSafetyArmed != 0
+		s.BaseMode = m.BaseMode
+		s.CustomMode = m.CustomMode
+		s.SystemStatus = m.SystemStatus
+	case MAVLinkAttitude:
+		sample := AttitudeSample{
+			T:         t,
+			Roll:      float64(m.Roll),
+			Pitch:     float64(m.Pitch),
+			Yaw:       float64(m.Yaw),
+			RollRate:  float64(m.RollSpeed),
+			PitchRate: float64(m.PitchSpeed),
+			YawRate:   float64(m.YawSpeed),
+		}
+		s.HasAttitude = true
+		s.Attitude = sample
+		if v.onAttitude != nil {
+			v.onAttitude(sample)
+		}
+	case MAVLinkSysStatus:
+		if m.VoltageBattery == math.MaxUint16 {
+			return
+		}
+		s.HasBattery = true
+		s.BatteryVoltage = float64(m.VoltageBattery) / 1000
+		s.BatteryCurrent = batteryCurrent(m.CurrentBattery)
+		s.BatteryRemaining = int(m.BatteryRemain)
+	case MAVLinkBatteryStatus:
+		if m.ID != 0 {
+			return
+		}
+		var mv float64
+		for _, cell := range m.Voltages {
+			if cell != math.MaxUint16 {
+				mv += float64(cell)
+			}
+		}
+		s.HasBattery = true
+		s.BatteryVoltage = mv / 1000
+		s.BatteryCurrent = batteryCurrent(m.CurrentBattery)
+		s.BatteryRemaining = int(m.BatteryRemain)
+	case MAVLinkRCChannels:
+		count := min(int(m.ChanCount), len(m.Channels))
+		s.HasRC = true
+		s.RCChannels = append(s.RCChannels[:0:0], m.Channels[:count]...)
+		s.RSSI = m.RSSI
+		ch := v.cfg.ManualChannel
+		s.Manual = ch > 0 && ch <= count && int(m.Channels[ch-1]) > v.cfg.ManualPWM
+	}
+}
+
+// batteryCurrent converts a MAVLink cA reading to amperes, -1 when unknown.
+func batteryCurrent(cA int16) float64 {
+	if cA < 0 {
+		return -1
+	}
+	return float64(cA) / 100
+}
+
+// State returns the latest vehicle state at controller time now.
+func (v *VehicleLink) State(now float64) VehicleState {
+	if v == nil {
+		return VehicleState{}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.state
+	s.Connected = v.hasHeartbeat && now-s.HeartbeatT <= v.cfg.StaleSeconds
+	return s
+}
+
+// Stats returns the frame counters.
+func (v *VehicleLink) Stats() VehicleLinkStats {
+	if v == nil {
+		return VehicleLinkStats{}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return VehicleLinkStats{
+		Frames:     v.parser.Frames,
+		Errors:     v.parser.Errors,
+		Unknown:    v.parser.Unknown,
+		Ignored:    v.ignored,
+		ReadErrors: v.readErrors,
+		Reopens:    v.reopens,
+	}
+}
+
+// Close stops reading telemetry.
+func (v *VehicleLink) Close() error {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	v.stopOnce.Do(func() { close(v.stop) })
+	closer := v.closer
+	v.mu.Unlock()
+	if closer == nil {
+		return nil
+	}
+	return closer.Close()
+}
+
+// VehicleGuardConfig makes the controller send STOP on vehicle conditions
+// reported by MAVLink telemetry. It only applies when vehicle telemetry is
+// configured.
+//
+// require_link stops while no heartbeat arrives, require_armed while the
+// flight controller is disarmed, stop_on_manual while the pilot's manual
+// switch is engaged, and min_battery_v (0 disables) below that voltage.
+type VehicleGuardConfig struct {
+	RequireLink  bool    `json:"require_link"`
+	RequireArmed bool    `json:"require_armed"`
+	StopOnManual bool    `json:"stop_on_manual"`
+	MinBatteryV  float64 `json:"min_battery_v"`
+}
+
+// Check returns why the guard stops the vehicle, or "" when it does not.
+func (g VehicleGuardConfig) Check(v VehicleState) string {
+	switch {
+	case g.RequireLink && !v.Connected:
+		return "link_lost"
+	case g.RequireArmed && !v.Armed:
+		return "disarmed"
+	case g.StopOnManual && v.Manual:
+		return "manual"
+	case g.MinBatteryV > 0 && v.HasBattery && v.BatteryVoltage < g.MinBatteryV:
+		return "low_battery"
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+package nad_nav
+
+import (
+	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestVehicleLinkFeedRecordedStream(t *testing.T) {
+	now := 10.0
+	var attitudes []AttitudeSample
+	link := NewVehicleLink(VehicleConfig{}, func() float64 { return now }, func(s AttitudeSample) {
+		attitudes = append(attitudes, s)
+	})
+
+	// A ground station heartbeat must not claim the link, and garbage and
+	// odd chunk boundaries must not lose frames.
+	stream := refFrames(t, refGCSHeartbeat)
+	stream = append(stream, "\x00\x01garbage"...)
+	stream = append(stream, refStream(t)...)
+	for i := 0; i < len(stream); i += 5 {
+		link.Feed(stream[i:min(i+5, len(stream))])
+	}
+
+	s := link.State(now)
+	if !s.Connected || !s.Armed || s.SystemID != 1 || s.CustomMode != 4 || s.HeartbeatT != 10 {
+		t.Errorf("heartbeat state %+v", s)
+	}
+	if !s.HasAttitude || math.Abs(s.Attitude.Roll-0.1) > 1e-6 || math.Abs(s.Attitude.Yaw-1.5) > 1e-6 || s.Attitude.T != 10 {
+		t.Errorf("attitude %+v", s.Attitude)
+	}
+	if len(attitudes) != 1 || attitudes[0] != s.Attitude {
+		t.Errorf("onAttitude got %+v", attitudes)
+	}
+	// BATTERY_STATUS follows SYS_STATUS in the stream and wins.
+	if !s.HasBattery || math.Abs(s.BatteryVoltage-12.0) > 1e-9 || s.BatteryCurrent != 15 || s.BatteryRemaining != 64 {
+		t.Errorf("battery %+v", s)
+	}
+	if stats := link.Stats(); stats.Frames != 5 || stats.Errors == 0 {
+		t.Errorf("stats %+v, want 5 frames and the garbage counted", stats)
+	}
+
+	// SYS_STATUS alone reports the pack voltage.
+	link.Feed(refFrames(t, refSysStatus))
+	if s := link.State(now); s.BatteryVoltage != 11.9 || s.BatteryCurrent != 12.5 || s.BatteryRemaining != 76 {
+		t.Errorf("sys_status battery %+v", s)
+	}
+
+	// A signed heartbeat disarms; a corrupt one changes nothing.
+	now = 11
+	link.Feed(refFrames(t, refSignedHeartbeat))
+	if s := link.State(now); s.Armed || s.HeartbeatT != 11 {
+		t.Errorf("after signed heartbeat %+v", s)
+	}
+	bad := refFrames(t, refHeartbeat)
+	bad[len(bad)-1] ^= 0xFF
+	now = 12
+	link.Feed(bad)
+	if s := link.State(now); s.Armed || s.HeartbeatT != 11 {
+		t.Errorf("corrupt heartbeat was applied: %+v", s)
+	}
+
+	if s := link.State(11 + 2.5); s.Connected {
+		t.Error("link should be stale after stale_seconds without a heartbeat")
+	}
+}
+
+func TestVehicleLinkIgnoresOtherSystems(t *testing.T) {
+	link := NewVehicleLink(VehicleConfig{SystemID: 2}, func() float64 { return 0 }, nil)
+	link.Feed(refStream(t))
+	if s := link.State(0); s.Connected || s.HasAttitude || s.HasBattery {
+		t.Errorf("frames from system 1 were applied: %+v", s)
+	}
+	if stats := link.Stats(); stats.Ignored != 4 {
+		t.Errorf("ignored %d frames, want 4", stats.Ignored)
+	}
+}
+
+// failingPort is a serial port whose reads fail, as after a USB unplug.
+type failingPort struct{ reads atomic.Int64 }
+
+func (p *failingPort) Read([]byte) (int, error) {
+	p.reads.Add(1)
+	return 0, syscall.EIO
+}
+
+func (p *failingPort) Close() error { return nil }
+
+// streamPort serves data once, then blocks until closed, like an os.File.
+type streamPort struct {
+	data   []byte
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (p *streamPort) Read(buf []byte) (int, error) {
+	n := 0
+	p.once.Do(func() { n = copy(buf, p.data) })
+	if n > 0 {
+		return n, nil
+	}
+	<-p.closed
+	return 0, os.ErrClosed
+}
+
+func (p *streamPort) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func TestVehicleLinkReadErrorsBackOff(t *testing.T) {
+	link := NewVehicleLink(VehicleConfig{}, func() float64 { return 0 }, nil)
+	port := &failingPort{}
+	link.closer = port
+	done := make(chan struct{})
+	go func() {
+		link.read(port, nil)
+		close(done)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	// 10+20+40+80 ms of backoff fit in 200 ms; a busy loop would read
+	// millions of times.
+	if n := port.reads.Load(); n < 2 || n > 8 {
+		t.Errorf("%d reads in 200ms, want a backoff between retries", n)
+	}
+	link.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not return after Close")
+	}
+	if stats := link.Stats(); stats.ReadErrors != uint64(port.reads.Load()) {
+		t.Errorf("read errors %d, want %d", stats.ReadErrors, port.reads.Load())
+	}
+}
+
+func TestVehicleLinkReopensFailedPort(t *testing.T) {
+	link := NewVehicleLink(VehicleConfig{}, func() float64 { return 5 }, nil)
+	failed := &failingPort{}
+	link.closer = failed
+	fresh := &streamPort{data: refStream(t), closed: make(chan struct{})}
+	var opens atomic.Int64
+	reopen := func() (io.ReadCloser, error) {
+		if opens.Add(1) == 1 {
+			return nil, syscall.ENOENT // not re-enumerated yet
+		}
+		return fresh, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		link.read(failed, reopen)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !link.State(5).Connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("link did not recover: %+v", link.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := link.Stats(); stats.Reopens != 1 || stats.ReadErrors != 2 {
+		t.Errorf("stats %+v, want 1 reopen and 2 read errors", stats)
+	}
+	link.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not return after Close")
+	}
+}
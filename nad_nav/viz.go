@@ -1,9 +1,14 @@
 package nad_nav
 
 import (
+	"context"
 	"expvar"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // VizConfig controls the optional expvar endpoint used by jplot.
@@ -14,12 +19,26 @@ type VizConfig struct {
 
 // VizMetrics exposes live input/output values via expvar.
 type VizMetrics struct {
-	input  *expvar.Map
-	output *expvar.Map
-	flat   map[string]*expvar.Float
+	input    *expvar.Map
+	state    *expvar.Map
+	attitude *expvar.Map
+	latency  *expvar.Map
+	anomaly  *expvar.Map
+	link     *expvar.Map
+	loop     *expvar.Map
+	cameras  *expvar.Map
+	auth     *expvar.Map
+	output   *expvar.Map
+	sinks    *expvar.Map
+	vehicle  *expvar.Map
+	flat     map[string]*expvar.Float
+	server   *http.Server
 }
 
 // StartViz starts an HTTP server exposing /debug/vars for plotting.
+//
+// The expvar variables are process-wide: starting viz again, for example for
+// a second Runner, resets them and the newest VizMetrics publishes into them.
 func StartViz(cfg VizConfig) (*VizMetrics, error) {
 	if !cfg.Enabled {
 		return nil, nil
@@ -28,27 +47,75 @@ func StartViz(cfg VizConfig) (*VizMetrics, error) {
 		cfg.Addr = "127.0.0.1:7070"
 	}
 
+	vizVarsMu.Lock()
+	defer vizVarsMu.Unlock()
 	metrics := &VizMetrics{
-		input:  expvar.NewMap("input"),
-		output: expvar.NewMap("output"),
-		flat:   map[string]*expvar.Float{},
+		input:    vizMap("input"),
+		state:    vizMap("state"),
+		attitude: vizMap("attitude"),
+		latency:  vizMap("latency"),
+		anomaly:  vizMap("anomalies"),
+		link:     vizMap("link"),
+		loop:     vizMap("loop"),
+		cameras:  vizMap("cameras"),
+		auth:     vizMap("auth"),
+		output:   vizMap("output"),
+		sinks:    vizMap("sinks"),
+		vehicle:  vizMap("vehicle"),
+		flat:     map[string]*expvar.Float{},
 	}
 	metrics.input.Set("cx", new(expvar.Float))
 	metrics.input.Set("cy", new(expvar.Float))
 	metrics.input.Set("size", new(expvar.Float))
+	metrics.state.Set("cx", new(expvar.Float))
+	metrics.state.Set("cy", new(expvar.Float))
+	metrics.state.Set("bearing_deg", new(expvar.Float))
+	metrics.state.Set("elevation_deg", new(expvar.Float))
+	metrics.state.Set("range_m", new(expvar.Float))
+	metrics.attitude.Set("roll_deg", new(expvar.Float))
+	metrics.attitude.Set("pitch_deg", new(expvar.Float))
+	metrics.attitude.Set("yaw_rate_dps", new(expvar.Float))
+	metrics.latency.Set("latency_ms", new(expvar.Float))
+	metrics.latency.Set("last_ms", new(expvar.Float))
+	metrics.latency.Set("offset_s", new(expvar.Float))
+	metrics.link.Set("last_error", new(expvar.String))
+	metrics.vehicle.Set("guard", new(expvar.String))
 	metrics.output.Set("yaw", new(expvar.Float))
 	metrics.output.Set("vertical", new(expvar.Float))
 	metrics.output.Set("forward", new(expvar.Float))
 	metrics.output.Set("mode", new(expvar.Float))
-	metrics.flat["input_cx"] = expvar.NewFloat("input_cx")
-	metrics.flat["input_cy"] = expvar.NewFloat("input_cy")
-	metrics.flat["input_size"] = expvar.NewFloat("input_size")
-	metrics.flat["output_yaw"] = expvar.NewFloat("output_yaw")
-	metrics.flat["output_vertical"] = expvar.NewFloat("output_vertical")
-	metrics.flat["output_forward"] = expvar.NewFloat("output_forward")
-	metrics.flat["output_mode"] = expvar.NewFloat("output_mode")
+	metrics.flat["input_cx"] = vizFloat("input_cx")
+	metrics.flat["input_cy"] = vizFloat("input_cy")
+	metrics.flat["input_size"] = vizFloat("input_size")
+	metrics.flat["state_cx"] = vizFloat("state_cx")
+	metrics.flat["state_cy"] = vizFloat("state_cy")
+	metrics.flat["state_bearing_deg"] = vizFloat("state_bearing_deg")
+	metrics.flat["state_elevation_deg"] = vizFloat("state_elevation_deg")
+	metrics.flat["state_range_m"] = vizFloat("state_range_m")
+	metrics.flat["attitude_roll_deg"] = vizFloat("attitude_roll_deg")
+	metrics.flat["attitude_pitch_deg"] = vizFloat("attitude_pitch_deg")
+	metrics.flat["attitude_yaw_rate_dps"] = vizFloat("attitude_yaw_rate_dps")
+	metrics.flat["latency_ms"] = vizFloat("latency_ms")
+	metrics.flat["latency_last_ms"] = vizFloat("latency_last_ms")
+	metrics.flat["link_rate"] = vizFloat("link_rate")
+	metrics.flat["link_jitter_ms"] = vizFloat("link_jitter_ms")
+	metrics.flat["link_loss_pct"] = vizFloat("link_loss_pct")
+	metrics.flat["loop_latency_ms"] = vizFloat("loop_latency_ms")
+	metrics.flat["loop_rate"] = vizFloat("loop_rate")
+	metrics.flat["loop_late_ms"] = vizFloat("loop_late_ms")
+	metrics.flat["loop_tick_ms"] = vizFloat("loop_tick_ms")
+	metrics.flat["loop_overruns"] = vizFloat("loop_overruns")
+	metrics.flat["fusion_camera"] = vizFloat("fusion_camera")
+	metrics.flat["output_yaw"] = vizFloat("output_yaw")
+	metrics.flat["output_vertical"] = vizFloat("output_vertical")
+	metrics.flat["output_forward"] = vizFloat("output_forward")
+	metrics.flat["output_mode"] = vizFloat("output_mode")
+	metrics.flat["vehicle_connected"] = vizFloat("vehicle_connected")
+	metrics.flat["vehicle_armed"] = vizFloat("vehicle_armed")
+	metrics.flat["vehicle_battery_v"] = vizFloat("vehicle_battery_v")
 
 	server := &http.Server{Addr: cfg.Addr, Handler: http.DefaultServeMux}
+	metrics.server = server
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("viz server error: %v", err)
@@ -58,6 +125,40 @@ func StartViz(cfg VizConfig) (*VizMetrics, error) {
 	return metrics, nil
 }
 
+// vizVarsMu serializes registration of the process-wide expvar variables.
+var vizVarsMu sync.Mutex
+
+// vizMap returns the published map called name, cleared, publishing it on
+// first use. expvar panics on a second registration, and a process may start
+// viz for more than one Runner.
+func vizMap(name string) *expvar.Map {
+	if m, ok := expvar.Get(name).(*expvar.Map); ok {
+		m.Init()
+		return m
+	}
+	return expvar.NewMap(name)
+}
+
+// vizFloat returns the published float called name, reset to zero, publishing
+// it on first use.
+func vizFloat(name string) *expvar.Float {
+	if f, ok := expvar.Get(name).(*expvar.Float); ok {
+		f.Set(0)
+		return f
+	}
+	return expvar.NewFloat(name)
+}
+
+// Close stops the HTTP server, waiting briefly for open requests.
+func (v *VizMetrics) Close() error {
+	if v == nil || v.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return v.server.Shutdown(ctx)
+}
+
 // UpdateInput publishes the latest camera input values.
 func (v *VizMetrics) UpdateInput(obs AnchorObservation) {
 	if v == nil {
@@ -71,6 +172,171 @@ func (v *VizMetrics) UpdateInput(obs AnchorObservation) {
 	setFlat(v.flat, "input_size", obs.Size)
 }
 
+// UpdateState publishes the filtered target state, including angles and range.
+func (v *VizMetrics) UpdateState(st AnchorState) {
+	if v == nil {
+		return
+	}
+	bearing := rad2deg(st.Bearing)
+	elevation := rad2deg(st.Elevation)
+	setFloat(v.state, "cx", st.CX)
+	setFloat(v.state, "cy", st.CY)
+	setFloat(v.state, "bearing_deg", bearing)
+	setFloat(v.state, "elevation_deg", elevation)
+	setFloat(v.state, "range_m", st.Range)
+	setFlat(v.flat, "state_cx", st.CX)
+	setFlat(v.flat, "state_cy", st.CY)
+	setFlat(v.flat, "state_bearing_deg", bearing)
+	setFlat(v.flat, "state_elevation_deg", elevation)
+	setFlat(v.flat, "state_range_m", st.Range)
+}
+
+// UpdateAttitude publishes the latest vehicle attitude used for de-rotation.
+func (v *VizMetrics) UpdateAttitude(att AttitudeSample) {
+	if v == nil {
+		return
+	}
+	roll := rad2deg(att.Roll)
+	pitch := rad2deg(att.Pitch)
+	yawRate := rad2deg(att.YawRate)
+	setFloat(v.attitude, "roll_deg", roll)
+	setFloat(v.attitude, "pitch_deg", pitch)
+	setFloat(v.attitude, "yaw_rate_dps", yawRate)
+	setFlat(v.flat, "attitude_roll_deg", roll)
+	setFlat(v.flat, "attitude_pitch_deg", pitch)
+	setFlat(v.flat, "attitude_yaw_rate_dps", yawRate)
+}
+
+// UpdateLatency publishes the estimated pipeline latency and clock offset.
+func (v *VizMetrics) UpdateLatency(latency, last, offset float64) {
+	if v == nil {
+		return
+	}
+	setFloat(v.latency, "latency_ms", latency*1000)
+	setFloat(v.latency, "last_ms", last*1000)
+	setFloat(v.latency, "offset_s", offset)
+	setFlat(v.flat, "latency_ms", latency*1000)
+	setFlat(v.flat, "latency_last_ms", last*1000)
+}
+
+// UpdateAnomalies publishes input timing anomaly counters.
+func (v *VizMetrics) UpdateAnomalies(a InputAnomalies, trackerResets uint64) {
+	if v == nil {
+		return
+	}
+	setFloat(v.anomaly, "out_of_order", float64(a.OutOfOrder))
+	setFloat(v.anomaly, "duplicate", float64(a.Duplicate))
+	setFloat(v.anomaly, "clock_reset", float64(a.ClockReset))
+	setFloat(v.anomaly, "stale", float64(a.Stale))
+	setFloat(v.anomaly, "tracker_resets", float64(trackerResets))
+}
+
+// UpdateLink publishes input link-quality statistics.
+func (v *VizMetrics) UpdateLink(s LinkStatsSnapshot) {
+	if v == nil {
+		return
+	}
+	setFloat(v.link, "packets", float64(s.Packets))
+	setFloat(v.link, "rate", s.Rate)
+	setFloat(v.link, "jitter_ms", s.JitterMs)
+	setFloat(v.link, "lost", float64(s.Lost))
+	setFloat(v.link, "loss_pct", 100*s.LossRatio)
+	setFloat(v.link, "read_errors", float64(s.ReadErrors))
+	for reason, n := range s.ParseErrors {
+		setFloat(v.link, "parse_errors_"+reason, float64(n))
+	}
+	if last, ok := v.link.Get("last_error").(*expvar.String); ok {
+		last.Set(s.LastError)
+	}
+	setFlat(v.flat, "link_rate", s.Rate)
+	setFlat(v.flat, "link_jitter_ms", s.JitterMs)
+	setFlat(v.flat, "link_loss_pct", 100*s.LossRatio)
+}
+
+// UpdateQueue publishes the ingestion queue counters.
+func (v *VizMetrics) UpdateQueue(q QueueStats) {
+	if v == nil {
+		return
+	}
+	setFloat(v.loop, "queue_depth", float64(q.Depth))
+	setFloat(v.loop, "queue_high_water", float64(q.HighWater))
+	setFloat(v.loop, "queue_dropped", float64(q.Dropped))
+}
+
+// UpdateLoopLatency publishes the packet-receive to command-send latency.
+func (v *VizMetrics) UpdateLoopLatency(latency, last float64) {
+	if v == nil {
+		return
+	}
+	setFloat(v.loop, "latency_ms", latency*1000)
+	setFloat(v.loop, "last_latency_ms", last*1000)
+	setFlat(v.flat, "loop_latency_ms", latency*1000)
+}
+
+// UpdateCamera publishes one input's state and fusion score.
+//
+// index is the input's position in the config; the authoritative input's
+// index is also published as the flat fusion_camera variable.
+func (v *VizMetrics) UpdateCamera(index int, name string, st AnchorState, score float64, authoritative bool) {
+	if v == nil {
+		return
+	}
+	valid := 0.0
+	if st.Valid {
+		valid = 1
+	}
+	setFloat(v.cameras, name+"_cx", st.CX)
+	setFloat(v.cameras, name+"_cy", st.CY)
+	setFloat(v.cameras, name+"_valid", valid)
+	setFloat(v.cameras, name+"_age", st.Age)
+	setFloat(v.cameras, name+"_score", score)
+	if authoritative {
+		setFlat(v.flat, "fusion_camera", float64(index))
+	}
+}
+
+// UpdateAuth publishes packet authentication counters for one listener,
+// named by channel (an input name or "attitude").
+func (v *VizMetrics) UpdateAuth(channel string, s AuthStats) {
+	if v == nil {
+		return
+	}
+	var rejected uint64
+	for reason, n := range s.Rejected {
+		rejected += n
+		setFloat(v.auth, channel+"_rejected_"+reason, float64(n))
+	}
+	setFloat(v.auth, channel+"_accepted", float64(s.Accepted))
+	setFloat(v.auth, channel+"_rejected", float64(rejected))
+}
+
+// UpdateLoopTiming publishes loop scheduling statistics.
+func (v *VizMetrics) UpdateLoopTiming(s LoopTimingStats) {
+	if v == nil {
+		return
+	}
+	setFloat(v.loop, "rate", s.Rate)
+	setFloat(v.loop, "ticks", float64(s.Ticks))
+	setFloat(v.loop, "overruns", float64(s.Overruns))
+	setFloat(v.loop, "catch_up", float64(s.CatchUp))
+	setFloat(v.loop, "skipped", float64(s.Skipped))
+	setFloat(v.loop, "late_ms", s.LateMs)
+	setFloat(v.loop, "max_late_ms", s.MaxLateMs)
+	setFloat(v.loop, "tick_ms", s.MeanTickMs)
+	setFloat(v.loop, "max_tick_ms", s.MaxTickMs)
+	for _, b := range s.Histogram {
+		key := fmt.Sprintf("tick_hist_le_%gms", b.UpperMs)
+		if math.IsInf(b.UpperMs, 1) {
+			key = "tick_hist_inf"
+		}
+		setFloat(v.loop, key, float64(b.Count))
+	}
+	setFlat(v.flat, "loop_rate", s.Rate)
+	setFlat(v.flat, "loop_late_ms", s.LateMs)
+	setFlat(v.flat, "loop_tick_ms", s.MeanTickMs)
+	setFlat(v.flat, "loop_overruns", float64(s.Overruns))
+}
+
 // UpdateOutput publishes the latest controller output values.
 func (v *VizMetrics) UpdateOutput(cmd BodyCommand) {
 	if v == nil {
@@ -86,6 +352,79 @@ func (v *VizMetrics) UpdateOutput(cmd BodyCommand) {
 	setFlat(v.flat, "output_mode", float64(cmd.Mode))
 }
 
+// UpdateSinks publishes per-sink output and acknowledgment counters.
+func (v *VizMetrics) UpdateSinks(stats []SinkStats) {
+	if v == nil {
+		return
+	}
+	for _, s := range stats {
+		connected := 0.0
+		if s.Connected {
+			connected = 1
+		}
+		setFloat(v.sinks, s.Name+"_sent", float64(s.Sent))
+		setFloat(v.sinks, s.Name+"_limited", float64(s.Limited))
+		setFloat(v.sinks, s.Name+"_dropped", float64(s.Dropped))
+		setFloat(v.sinks, s.Name+"_errors", float64(s.Errors))
+		setFloat(v.sinks, s.Name+"_connected", connected)
+		if a := s.Ack; a != nil {
+			fault := 0.0
+			if a.Fault {
+				fault = 1
+			}
+			setFloat(v.sinks, s.Name+"_ack_rtt_ms", a.RTT*1000)
+			setFloat(v.sinks, s.Name+"_ack_lost", float64(a.Lost))
+			setFloat(v.sinks, s.Name+"_ack_mismatched", float64(a.Mismatched))
+			setFloat(v.sinks, s.Name+"_ack_errors", float64(a.Errors))
+			setFloat(v.sinks, s.Name+"_ack_fault", fault)
+		}
+	}
+}
+
+// UpdateVehicle publishes flight controller telemetry, the MAVLink frame
+// counters and the vehicle guard's STOP reason ("" when not tripped).
+func (v *VizMetrics) UpdateVehicle(s VehicleState, stats VehicleLinkStats, guard string) {
+	if v == nil {
+		return
+	}
+	flag := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	setFloat(v.vehicle, "connected", flag(s.Connected))
+	setFloat(v.vehicle, "armed", flag(s.Armed))
+	setFloat(v.vehicle, "custom_mode", float64(s.CustomMode))
+	setFloat(v.vehicle, "frames", float64(stats.Frames))
+	setFloat(v.vehicle, "errors", float64(stats.Errors))
+	setFloat(v.vehicle, "read_errors", float64(stats.ReadErrors))
+	setFloat(v.vehicle, "reopens", float64(stats.Reopens))
+	setFlat(v.flat, "vehicle_connected", flag(s.Connected))
+	setFlat(v.flat, "vehicle_armed", flag(s.Armed))
+	if last, ok := v.vehicle.Get("guard").(*expvar.String); ok {
+		last.Set(guard)
+	}
+	if s.HasBattery {
+		setFloat(v.vehicle, "battery_v", s.BatteryVoltage)
+		setFloat(v.vehicle, "battery_a", s.BatteryCurrent)
+		setFloat(v.vehicle, "battery_pct", float64(s.BatteryRemaining))
+		setFlat(v.flat, "vehicle_battery_v", s.BatteryVoltage)
+	}
+	if s.HasAttitude {
+		setFloat(v.vehicle, "roll_deg", s.Attitude.Roll*180/math.Pi)
+		setFloat(v.vehicle, "pitch_deg", s.Attitude.Pitch*180/math.Pi)
+		setFloat(v.vehicle, "yaw_deg", s.Attitude.Yaw*180/math.Pi)
+	}
+	if s.HasRC {
+		setFloat(v.vehicle, "manual", flag(s.Manual))
+		setFloat(v.vehicle, "rssi", float64(s.RSSI))
+		for i, pwm := range s.RCChannels {
+			setFloat(v.vehicle, fmt.Sprintf("rc_%d", i+1), float64(pwm))
+		}
+	}
+}
+
 // setFloat updates an expvar.Float stored inside a map.
 func setFloat(m *expvar.Map, key string, value float64) {
 	if v := m.Get(key); v != nil {
@@ -0,0 +1,36 @@
+package nad_nav
+
+import (
+	"expvar"
+	"testing"
+)
+
+func TestStartVizTwice(t *testing.T) {
+	first, err := StartViz(VizConfig{Enabled: true, Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.UpdateInput(AnchorObservation{CX: 0.5})
+	first.UpdateCamera(0, "front", AnchorState{}, 1, true)
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second viz in the same process, as for a second Runner, must not
+	// panic on the already published names, and starts from fresh values.
+	second, err := StartViz(VizConfig{Enabled: true, Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if got := expvar.Get("input_cx").(*expvar.Float).Value(); got != 0 {
+		t.Errorf("input_cx = %v after restart, want 0", got)
+	}
+	if got := expvar.Get("cameras").(*expvar.Map).Get("front_cx"); got != nil {
+		t.Errorf("stale camera value %v survived the restart", got)
+	}
+	second.UpdateInput(AnchorObservation{CX: -0.25})
+	if got := expvar.Get("input_cx").(*expvar.Float).Value(); got != -0.25 {
+		t.Errorf("input_cx = %v, want -0.25", got)
+	}
+}
@@ -1,12 +1,24 @@
+import json
 import socket
 import time
 import sys
 from pymavlink import mavutil
 
+import nad_auth
+import nad_output
+
 INPUT_IP = "0.0.0.0"
 INPUT_PORT = 9002
+ATTITUDE_ADDR = ("127.0.0.1", 9003)  # nad attitude.udp_addr
+# Shared key file for signed commands/attitude (see nad output.key_file); None disables.
+AUTH_KEY_FILE = None
+AUTH_KEY_ID = 0
 SERVO_MIN = 700
 SERVO_MAX = 2200
+# Fins go neutral when nad's heartbeat stalls this long (see output sink min_hz).
+COMMAND_TIMEOUT = 0.5
+# Acknowledge version 2 commands back to nad (see nad output sink ack).
+SEND_ACKS = True
 
 # FC Connection
 DEVICE = '/dev/serial0'
@@ -75,13 +87,63 @@ def mix_xtail(p, y):
     return [norm[0], norm[1], norm[0], norm[1]]
 
 
+def send_servos(servo_pwm):
+    for i in range(4):
+        master.mav.command_long_send(
+            master.target_system, master.target_component,
+            mavutil.mavlink.MAV_CMD_DO_SET_SERVO, 0,
+            i + 1, servo_pwm[i], 0, 0, 0, 0, 0
+        )
+
+
 configure_and_arm()
 
 sock_in = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
 sock_in.bind((INPUT_IP, INPUT_PORT))
+sock_in.settimeout(0.05)
+sock_att = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+
+command_filter = nad_output.CommandFilter(max_age=0.2)
+verifier = None
+att_sealer = None
+if AUTH_KEY_FILE:
+    keys = nad_auth.load_keys(AUTH_KEY_FILE)
+    verifier = nad_auth.Verifier(keys)
+    att_sealer = nad_auth.Sealer(keys[AUTH_KEY_ID], AUTH_KEY_ID)
+
+
+def forward_attitude():
+    # Relay every pending ATTITUDE message to nad for ego-rotation compensation.
+    while True:
+        att = master.recv_match(type="ATTITUDE", blocking=False)
+        if att is None:
+            return
+        payload = (
+            f"{att.time_boot_ms / 1000.0:.3f},{att.roll:.5f},{att.pitch:.5f},{att.yaw:.5f},"
+            f"{att.rollspeed:.5f},{att.pitchspeed:.5f},{att.yawspeed:.5f}"
+        )
+        packet = payload.encode()
+        if att_sealer:
+            packet = att_sealer.seal(packet)
+        sock_att.sendto(packet, ATTITUDE_ADDR)
+
+
+def send_ack(cmd, addr, pwm=None, error=None):
+    if not SEND_ACKS or cmd["seq"] is None:
+        return
+    ack = {"seq": cmd["seq"], "heartbeat": cmd["heartbeat"]}
+    if pwm is not None:
+        ack["pwm"] = pwm
+    if error is not None:
+        ack["error"] = error
+    sock_in.sendto(json.dumps(ack).encode(), addr)
+
 
 print(f"Listening UDP on {INPUT_PORT}...")
 last_heartbeat = time.time()
+last_beat = None
+last_beat_time = time.time()
+neutralized = False
 
 while True:
     if time.time() - last_heartbeat > 0.5:
@@ -89,14 +151,50 @@ while True:
                                  mavutil.mavlink.MAV_AUTOPILOT_INVALID, 0, 0, 0)
         last_heartbeat = time.time()
 
-    data, addr = sock_in.recvfrom(1024)
+    forward_attitude()
+
+    if not neutralized and time.time() - last_beat_time > COMMAND_TIMEOUT:
+        send_servos([to_pwm(v) for v in mix_xtail(0.0, 0.0)])
+        neutralized = True
+        print("Command timeout: fins neutral")
+
+    try:
+        data, addr = sock_in.recvfrom(1024)
+    except socket.timeout:
+        continue
+    if verifier:
+        data = verifier.open(data)
+        if data is None:
+            print("Rejected packet:", verifier.rejected)
+            continue
 
     try:
         message = data.decode().strip()
-        yaw, vertical, forward, mode = message.split(",")
+        cmd = nad_output.parse_command(message)
+    except Exception as e:
+        print("Parse error:", e)
+        continue
 
-        yaw = float(yaw)
-        vertical = float(vertical)
+    try:
+        if not command_filter.accept(cmd):
+            print("Dropped command:", command_filter.rejected)
+            send_ack(cmd, addr, error="dropped")
+            continue
+        if cmd["heartbeat"] is None or cmd["heartbeat"] != last_beat:
+            last_beat = cmd["heartbeat"]
+            last_beat_time = time.time()
+            neutralized = False
+        elif neutralized:
+            # A keepalive repeat while nad's loop is stalled.
+            send_ack(cmd, addr, error="timeout")
+            continue
+
+        yaw = cmd["yaw"]
+        vertical = cmd["vertical"]
+        if not cmd["armed"]:
+            # nad is shutting down or the vehicle is disarmed. STOP alone
+            # still carries steering and is applied.
+            yaw, vertical = 0.0, 0.0
 
         yaw = clamp(yaw, -1, 1)
         vertical = clamp(vertical, -1, 1)
@@ -104,14 +202,11 @@ while True:
         servo_norm = mix_xtail(vertical, yaw)
         servo_pwm = [to_pwm(v) for v in servo_norm]
 
-        for i in range(4):
-            master.mav.command_long_send(
-                master.target_system, master.target_component,
-                mavutil.mavlink.MAV_CMD_DO_SET_SERVO, 0,
-                i + 1, servo_pwm[i], 0, 0, 0, 0, 0
-            )
+        send_servos(servo_pwm)
+        send_ack(cmd, addr, pwm=servo_pwm)
 
         print(f"IN: {message} | OUT PWM: {servo_pwm}")
 
     except Exception as e:
-        print("Parse error:", e)
+        print("Apply error:", e)
+        send_ack(cmd, addr, error=str(e))
@@ -0,0 +1,83 @@
+import hashlib
+import hmac
+import struct
+import time
+
+# Signed envelope shared with nad (see nad_nav/auth.go):
+# "NS", version, key id, seq u64, unix time f64, payload len u16, payload, HMAC-SHA256.
+MAGIC = b"NS"
+VERSION = 1
+HEADER = struct.Struct("<2sBBQdH")
+MAC_LEN = 32
+
+
+def load_keys(path):
+    keys = {}
+    with open(path) as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith("#"):
+                continue
+            fields = line.split()
+            if len(fields) == 1:
+                keys[0] = bytes.fromhex(fields[0])
+            else:
+                keys[int(fields[0])] = bytes.fromhex(fields[1])
+    return keys
+
+
+class Sealer:
+    def __init__(self, key, key_id=0):
+        self.key = key
+        self.key_id = key_id
+        # Starting at Unix microseconds keeps seq increasing across restarts.
+        self.seq = int(time.time() * 1e6)
+
+    def seal(self, payload):
+        self.seq += 1
+        head = HEADER.pack(MAGIC, VERSION, self.key_id, self.seq, time.time(), len(payload))
+        body = head + payload
+        return body + hmac.new(self.key, body, hashlib.sha256).digest()
+
+
+class Verifier:
+    def __init__(self, keys, window=64, max_skew=0.0):
+        self.keys = keys
+        self.window = window
+        self.max_skew = max_skew
+        self.high = {}
+        self.seen = {}
+        self.rejected = {}
+
+    def _reject(self, reason):
+        self.rejected[reason] = self.rejected.get(reason, 0) + 1
+        return None
+
+    def open(self, packet):
+        if packet[:2] != MAGIC:
+            return self._reject("unsigned")
+        if len(packet) < HEADER.size + MAC_LEN:
+            return self._reject("malformed")
+        magic, version, key_id, seq, t, n = HEADER.unpack_from(packet)
+        if version != VERSION or len(packet) != HEADER.size + n + MAC_LEN:
+            return self._reject("malformed")
+        key = self.keys.get(key_id)
+        if key is None:
+            return self._reject("unknown_key")
+        body, mac = packet[:HEADER.size + n], packet[HEADER.size + n:]
+        if not hmac.compare_digest(hmac.new(key, body, hashlib.sha256).digest(), mac):
+            return self._reject("bad_mac")
+        if self.max_skew > 0 and abs(time.time() - t) > self.max_skew:
+            return self._reject("stale")
+
+        high = self.high.get(key_id)
+        seen = self.seen.setdefault(key_id, set())
+        if high is not None:
+            if seq <= high - self.window or seq in seen:
+                return self._reject("replay")
+        if high is None or seq > high:
+            self.high[key_id] = seq
+            high = seq
+        seen.add(seq)
+        self.seen[key_id] = {s for s in seen if s > high - self.window}
+        return body[HEADER.size:]
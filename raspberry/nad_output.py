@@ -0,0 +1,102 @@
+import time
+
+# Command records sent by nad (see nad_nav/encoder.go):
+#   legacy:     yaw,vertical,forward,mode
+#   version 2:  v2,seq,heartbeat,t,mode,yaw,vertical,forward,armed[,valid,confidence,cx,cy,size][,sample_offset]
+# heartbeat advances only while nad's control loop runs; keepalive repeats
+# (output sink min_hz) carry the same value. Sinks with an output schedule
+# append sample_offset, the offset of the resampled values from t.
+MODE_NAMES = {
+    1: "SEARCH",
+    2: "TRACK",
+    3: "APPROACH",
+    4: "CAPTURE",
+    5: "FLY_STRAIGHT",
+    6: "LATERAL_ONLY",
+    7: "STOP",
+}
+
+
+def parse_command(message):
+    fields = message.strip().split(",")
+    if fields[0] != "v2":
+        yaw, vertical, forward, mode = fields
+        return {
+            "version": 1,
+            "seq": None,
+            "heartbeat": None,
+            "t": None,
+            "mode": mode,
+            "yaw": float(yaw),
+            "vertical": float(vertical),
+            "forward": float(forward),
+            # Legacy records carry no armed flag.
+            "armed": True,
+            "target": None,
+            "sample_offset": None,
+        }
+    if len(fields) not in (9, 10, 14, 15):
+        raise ValueError(f"expected 9 to 15 fields, got {len(fields)}")
+    cmd = {
+        "version": 2,
+        "seq": int(fields[1]),
+        "heartbeat": int(fields[2]),
+        "t": float(fields[3]),
+        "mode": MODE_NAMES.get(int(fields[4]), fields[4]),
+        "yaw": float(fields[5]),
+        "vertical": float(fields[6]),
+        "forward": float(fields[7]),
+        "armed": fields[8] == "1",
+        "target": None,
+        "sample_offset": None,
+    }
+    if len(fields) in (10, 15):
+        cmd["sample_offset"] = float(fields.pop())
+    if len(fields) == 14:
+        cmd["target"] = {
+            "valid": fields[9] == "1",
+            "confidence": float(fields[10]),
+            "cx": float(fields[11]),
+            "cy": float(fields[12]),
+            "size": float(fields[13]),
+        }
+    return cmd
+
+
+class CommandFilter:
+    """Rejects reordered, duplicate and stale version 2 commands.
+
+    nad's command time is seconds since its own start, so the filter tracks
+    the smallest local-minus-nad offset seen and treats a command as stale
+    when it arrives more than max_age seconds after that baseline.
+    """
+
+    def __init__(self, max_age=0.2, reorder_window=64):
+        self.max_age = max_age
+        self.reorder_window = reorder_window
+        self.last_seq = None
+        self.offset = None
+        self.rejected = {}
+
+    def _reject(self, reason):
+        self.rejected[reason] = self.rejected.get(reason, 0) + 1
+        return False
+
+    def accept(self, cmd, now=None):
+        if cmd["version"] < 2:
+            return True
+        now = time.monotonic() if now is None else now
+        seq = cmd["seq"]
+        if self.last_seq is not None and seq <= self.last_seq:
+            if self.last_seq - seq < self.reorder_window:
+                return self._reject("reordered")
+            # A much smaller seq means nad restarted; start over.
+            self.offset = None
+        self.last_seq = seq
+
+        offset = now - cmd["t"]
+        if self.offset is None or offset < self.offset:
+            self.offset = offset
+        if offset - self.offset > self.max_age:
+            return self._reject("stale")
+        return True
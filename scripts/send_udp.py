@@ -1,7 +1,10 @@
 #!/usr/bin/env python3
 import argparse
 import csv
+import hashlib
+import hmac
 import socket
+import struct
 import time
 
 
@@ -43,6 +46,25 @@ def load_rows(path):
     return rows
 
 
+def load_key(path, key_id):
+    with open(path) as f:
+        for line in f:
+            fields = line.split()
+            if not fields or fields[0].startswith("#"):
+                continue
+            if len(fields) == 1 and key_id == 0:
+                return bytes.fromhex(fields[0])
+            if len(fields) == 2 and int(fields[0]) == key_id:
+                return bytes.fromhex(fields[1])
+    raise SystemExit(f"no key {key_id} in {path}")
+
+
+def seal(key, key_id, seq, payload):
+    # Signed envelope, see nad_nav/auth.go.
+    body = struct.pack("<2sBBQdH", b"NS", 1, key_id, seq, time.time(), len(payload)) + payload
+    return body + hmac.new(key, body, hashlib.sha256).digest()
+
+
 def main():
     ap = argparse.ArgumentParser()
     ap.add_argument("--log", default="iva-log-2.log")
@@ -51,12 +73,17 @@ def main():
     ap.add_argument("--speed", type=float, default=1.0, help="1.0 = real-time")
     ap.add_argument("--print-every", type=int, default=1, help="print every Nth send (default: 1)")
     ap.add_argument("--quiet", action="store_true", help="disable printing")
+    ap.add_argument("--key-file", help="sign packets with a key from this file")
+    ap.add_argument("--key-id", type=int, default=0)
     args = ap.parse_args()
 
     host, port = args.addr.split(":")
     addr = (host, int(port))
     sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
 
+    key = load_key(args.key_file, args.key_id) if args.key_file else None
+    seq = int(time.time() * 1e6)
+
     rows = load_rows(args.log)
     if not rows:
         raise SystemExit("no rows in log")
@@ -71,7 +98,11 @@ def main():
             last_t = row["t"]
 
             payload = f"{row['t']},{int(row['detected'])},{row['conf']},{row['cx']},{row['cy']},{row['size']}".encode()
-            sock.sendto(payload, addr)
+            if key:
+                seq += 1
+                sock.sendto(seal(key, args.key_id, seq, payload), addr)
+            else:
+                sock.sendto(payload, addr)
             count += 1
             if not args.quiet and (count % max(args.print_every, 1) == 0):
                 print(payload.decode(errors="replace"))